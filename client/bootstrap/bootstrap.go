@@ -0,0 +1,392 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/bootz/common/hashalg"
+	"google.golang.org/grpc"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// bootstrapState is a step of the bootstrap process.
+type bootstrapState int
+
+const (
+	stateDiscover bootstrapState = iota
+	stateConnect
+	stateFetch
+	stateVerify
+	stateDownload
+	stateInstall
+	stateReport
+	stateDone
+)
+
+func (s bootstrapState) String() string {
+	switch s {
+	case stateDiscover:
+		return "discover"
+	case stateConnect:
+		return "connect"
+	case stateFetch:
+		return "fetch"
+	case stateVerify:
+		return "verify"
+	case stateDownload:
+		return "download"
+	case stateInstall:
+		return "install"
+	case stateReport:
+		return "report"
+	case stateDone:
+		return "done"
+	}
+	return fmt.Sprintf("bootstrapState(%d)", int(s))
+}
+
+// serverFault reports whether a failure in the state may be down to the server,
+// so that another server could succeed where this one failed.
+func (s bootstrapState) serverFault() bool {
+	return s == stateConnect || s == stateFetch || s == stateVerify
+}
+
+// RetryPolicy decides how often and how soon a failed bootstrap is restarted.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts before giving up, or 0 to retry forever.
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt. It doubles after each further failure.
+	Backoff time.Duration
+	// MaxBackoff is the longest delay between attempts, or 0 for no limit.
+	MaxBackoff time.Duration
+	// Jitter is the fraction by which delays are randomly lengthened or shortened,
+	// so that devices do not retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the retry policy of clients which do not set one.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     5 * time.Second,
+	MaxBackoff:  2 * time.Minute,
+	Jitter:      0.2,
+}
+
+// delay returns how long to wait after the given number of failed attempts.
+func (p *RetryPolicy) delay(failures int) time.Duration {
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = math.MaxInt64 / 2
+	}
+	d := p.Backoff
+	for i := 1; i < failures && d > 0 && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// bootstrapper holds the state of a run of the bootstrap process.
+type bootstrapper struct {
+	*Client
+
+	// The state of the current attempt.
+	servers []string
+	// next is the index in servers of the next server to connect to.
+	next   int
+	conn   *grpc.ClientConn
+	client bpb.BootstrapClient
+	nonce  string
+	resp   *bpb.GetBootstrapDataResponse
+	// images are the downloaded images, keyed by control card serial number.
+	images map[string]*downloadedImage
+}
+
+// Bootstrap runs the whole bootstrap process: it discovers the Bootz servers, fetches
+// and verifies the bootstrap data, downloads the images, applies the data to the device
+// and reports the outcome. Every failure is reported to the server and the process is
+// restarted from discovery, as set by the retry policy, until it succeeds. With a state
+// store, ErrRebootRequired is returned at each reboot point.
+func (c *Client) Bootstrap(ctx context.Context) error {
+	if c.discover == nil {
+		return fmt.Errorf("no discoverer configured to find the bootz servers")
+	}
+	if c.device == nil {
+		return fmt.Errorf("no device configured to apply the bootstrap data to")
+	}
+	b := &bootstrapper{Client: c}
+	return b.run(ctx)
+}
+
+// run bootstraps the device, restarting from discovery after each failure until
+// the bootstrap succeeds or the attempts run out.
+func (b *bootstrapper) run(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := b.attempt(ctx)
+		if err == nil || errors.Is(err, ErrRebootRequired) {
+			return err
+		}
+		if b.retry.MaxAttempts > 0 && attempt >= b.retry.MaxAttempts {
+			return fmt.Errorf("bootstrap failed after %d attempts: %w", attempt, err)
+		}
+		d := b.retry.delay(attempt)
+		log.Warningf("Bootstrap attempt %d failed, restarting from discovery in %v: %v", attempt, d, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// attempt runs the bootstrap state machine once. Failures which may be down to
+// the server move on to the next discovered server, any other failure ends the attempt.
+// Every failure is reported to the server the device is connected to.
+func (b *bootstrapper) attempt(ctx context.Context) error {
+	defer b.disconnect()
+	state := stateDiscover
+	for state != stateDone {
+		log.Infof("=============================================================================")
+		log.Infof("Bootstrap step: %v", state)
+		log.Infof("=============================================================================")
+		next, err := b.step(ctx, state)
+		if err == nil {
+			state = next
+			continue
+		}
+		if errors.Is(err, ErrRebootRequired) {
+			return err
+		}
+		err = fmt.Errorf("%v step failed: %w", state, err)
+		b.reportFailure(ctx, err)
+		b.disconnect()
+		if state.serverFault() && b.next < len(b.servers) {
+			log.Warningf("%v, trying the next bootz server", err)
+			state = stateConnect
+			continue
+		}
+		return err
+	}
+	return nil
+}
+
+// step runs a single state and returns the state to move to.
+func (b *bootstrapper) step(ctx context.Context, state bootstrapState) (bootstrapState, error) {
+	switch state {
+	case stateDiscover:
+		return stateConnect, b.discoverServers(ctx)
+	case stateConnect:
+		return stateFetch, b.connect(ctx)
+	case stateFetch:
+		return stateVerify, b.fetch(ctx)
+	case stateVerify:
+		return stateDownload, b.verify()
+	case stateDownload:
+		return stateInstall, b.downloadImages(ctx)
+	case stateInstall:
+		return stateReport, b.install(ctx)
+	case stateReport:
+		return stateDone, b.reportSuccess(ctx)
+	}
+	return stateDone, fmt.Errorf("unknown bootstrap state %v", state)
+}
+
+func (b *bootstrapper) discoverServers(ctx context.Context) error {
+	servers, err := b.discover(ctx)
+	if err != nil {
+		return err
+	}
+	b.servers, b.next = servers, 0
+	return nil
+}
+
+func (b *bootstrapper) connect(ctx context.Context) error {
+	if b.next >= len(b.servers) {
+		return fmt.Errorf("no bootz server left to connect to")
+	}
+	addr := b.servers[b.next]
+	b.next++
+	conn, err := dialBootzServer(ctx, addr, b.creds, b.dialTimeout)
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+	b.client = bpb.NewBootstrapClient(conn)
+	log.Infof("Client connected to bootz server at %q", addr)
+	return nil
+}
+
+// disconnect closes the connection to the current server, if any.
+func (b *bootstrapper) disconnect() {
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.conn, b.client = nil, nil
+}
+
+func (b *bootstrapper) fetch(ctx context.Context) error {
+	if c := b.chassis.ActiveCard; c != nil {
+		log.Infof("Setting active control card with serial number: %v, slot: %v, part number: %v",
+			c.GetSerialNumber(), c.GetSlot(), c.GetPartNumber())
+	} else {
+		log.Infof("Fixed form factor chassis, making the request with chassis serial number %v", b.chassis.Descriptor.GetSerialNumber())
+	}
+
+	b.nonce = ""
+	if !b.insecureBoot {
+		// Generate a fresh nonce for every request that the Bootz server will use to sign the response.
+		nonce, err := NewNonce()
+		if err != nil {
+			return fmt.Errorf("unable to generate nonce: %v", err)
+		}
+		b.nonce = nonce
+		log.Infof("Nonce of %v generated successfully", nonce)
+	}
+	resp, err := b.Fetch(ctx, b.client, b.nonce)
+	if err != nil {
+		return err
+	}
+	b.resp = resp
+	return nil
+}
+
+// verify checks the OC, OV and response signature if the device is in secure mode.
+func (b *bootstrapper) verify() error {
+	if b.insecureBoot {
+		log.Infof("Device in insecure boot mode, skipping validation of the response")
+		return nil
+	}
+	if err := b.Verify(b.resp, b.nonce); err != nil {
+		return fmt.Errorf("unable to validate signed data: %w", err)
+	}
+	// Pin the server certificates now that the data they came in is trusted.
+	for _, data := range b.resp.GetSignedResponse().GetResponses() {
+		if cert := data.GetServerTrustCert(); cert != "" {
+			b.state.card(data.GetSerialNum()).ServerTrustCert = cert
+		}
+	}
+	return b.saveState()
+}
+
+func (b *bootstrapper) downloadImages(ctx context.Context) error {
+	b.images = map[string]*downloadedImage{}
+	for _, data := range b.resp.GetSignedResponse().GetResponses() {
+		image := data.GetIntendedImage()
+		if b.state.card(data.GetSerialNum()).Image.matches(image) {
+			log.Infof("Control card %v is already running image %v, skipping the download", data.GetSerialNum(), image.GetVersion())
+			continue
+		}
+		log.Infof("Start to download and validate image for control card %v, received: %+v...", data.GetSerialNum(), image)
+		alg, err := hashalg.Lookup(image.GetHashAlgorithm())
+		if err != nil {
+			return fmt.Errorf("invalid intended image: %v", err)
+		}
+		downloaded, err := b.downloader.download(ctx, image.GetUrl(), alg.New)
+		if err != nil {
+			return err
+		}
+		if err := verifyDigest(downloaded.digest, image); err != nil {
+			return fmt.Errorf("invalid intended image: %w", err)
+		}
+		b.images[data.GetSerialNum()] = downloaded
+	}
+	return nil
+}
+
+// install installs the downloaded images and applies the rest of the bootstrap data to
+// the device. With a state store, the device reboots once the images are installed and
+// again once new configuration is applied.
+func (b *bootstrapper) install(ctx context.Context) error {
+	installed := 0
+	for _, data := range b.resp.GetSignedResponse().GetResponses() {
+		serial := data.GetSerialNum()
+		downloaded := b.images[serial]
+		if downloaded == nil {
+			continue
+		}
+		image := data.GetIntendedImage()
+		log.Infof("Installing image %v on control card %v...", downloaded.path, serial)
+		if err := b.device.InstallImage(ctx, serial, image, downloaded.path); err != nil {
+			return fmt.Errorf("control card %v: unable to install image: %v", serial, err)
+		}
+		b.state.card(serial).Image = &ImageState{
+			Name:          image.GetName(),
+			Version:       image.GetVersion(),
+			HashAlgorithm: image.GetHashAlgorithm(),
+			Hash:          image.GetOsImageHash(),
+		}
+		installed++
+	}
+	if err := b.rebootPoint(installed, "image installed"); err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, data := range b.resp.GetSignedResponse().GetResponses() {
+		n, err := applyConfig(ctx, b.device, data, b.state.card(data.GetSerialNum()).ConfigHashes)
+		applied += n
+		if err != nil {
+			// Keep the hashes of what was applied before the failure.
+			if err := b.saveState(); err != nil {
+				log.Warningf("Unable to save device state: %v", err)
+			}
+			return fmt.Errorf("control card %v: %v", data.GetSerialNum(), err)
+		}
+		log.Infof("Applied bootstrap data to control card %v", data.GetSerialNum())
+	}
+	return b.rebootPoint(applied, "config applied")
+}
+
+// rebootPoint saves the state and returns ErrRebootRequired if the step changed anything
+// and the client has a state store.
+func (b *bootstrapper) rebootPoint(changes int, point string) error {
+	if changes == 0 || b.store == nil {
+		return nil
+	}
+	if err := b.saveState(); err != nil {
+		return err
+	}
+	log.Infof("Reboot point reached: %v", point)
+	return fmt.Errorf("%v: %w", point, ErrRebootRequired)
+}
+
+// reportSuccess reports that all control cards are initialized.
+func (b *bootstrapper) reportSuccess(ctx context.Context) error {
+	if err := b.Report(ctx, b.client, bpb.ReportStatusRequest_BOOTSTRAP_STATUS_SUCCESS, "Bootstrap Success"); err != nil {
+		return err
+	}
+	return b.saveState()
+}
+
+// reportFailure reports a failed bootstrap to the server the device is connected to.
+// Failing to report is only logged, as the bootstrap is restarted anyway.
+func (b *bootstrapper) reportFailure(ctx context.Context, cause error) {
+	if b.client == nil {
+		return
+	}
+	if err := b.Report(ctx, b.client, bpb.ReportStatusRequest_BOOTSTRAP_STATUS_FAILURE, cause.Error()); err != nil {
+		log.Warningf("Unable to report bootstrap failure: %v", err)
+	}
+}
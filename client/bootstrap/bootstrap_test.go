@@ -0,0 +1,375 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// fakeBootzServer answers bootstrap requests with a fixed image, failing the first
+// requests as configured, and records the status reports it receives.
+type fakeBootzServer struct {
+	bpb.UnimplementedBootstrapServer
+	// failures is the number of GetBootstrapData requests to fail.
+	failures int
+	// imageHash is the hash of the intended image.
+	imageHash string
+	// vendorConfig is the vendor config sent, if any.
+	vendorConfig []byte
+
+	mu      sync.Mutex
+	reports []*bpb.ReportStatusRequest
+}
+
+func (s *fakeBootzServer) GetBootstrapData(ctx context.Context, req *bpb.GetBootstrapDataRequest) (*bpb.GetBootstrapDataResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, status.Errorf(codes.Unavailable, "server overloaded")
+	}
+	data := &bpb.BootstrapDataResponse{
+		SerialNum: req.GetControlCardState().GetSerialNumber(),
+		IntendedImage: &bpb.SoftwareImage{
+			Url:           "https://path/to/image",
+			OsImageHash:   s.imageHash,
+			HashAlgorithm: "SHA256",
+		},
+	}
+	if s.vendorConfig != nil {
+		data.BootConfig = &bpb.BootConfig{VendorConfig: s.vendorConfig}
+	}
+	return &bpb.GetBootstrapDataResponse{
+		SignedResponse: &bpb.BootstrapDataSigned{
+			Responses: []*bpb.BootstrapDataResponse{data},
+		},
+	}, nil
+}
+
+func (s *fakeBootzServer) ReportStatus(ctx context.Context, req *bpb.ReportStatusRequest) (*bpb.EmptyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, req)
+	return &bpb.EmptyResponse{}, nil
+}
+
+// statuses returns a summary of the reports received, e.g. "FAILURE: fetch step failed".
+func (s *fakeBootzServer) statuses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var got []string
+	for _, r := range s.reports {
+		got = append(got, strings.TrimPrefix(r.GetStatus().String(), "BOOTSTRAP_STATUS_")+": "+r.GetStatusMessage())
+	}
+	return got
+}
+
+func startFakeBootzServer(t *testing.T, s *fakeBootzServer) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	bpb.RegisterBootstrapServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// unusedAddress returns the address of a port nothing listens on.
+func unusedAddress(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+// defaultTestChassis is a modular chassis matching the server's test inventory.
+func defaultTestChassis() *bpb.ChassisDescriptor {
+	return &bpb.ChassisDescriptor{
+		Manufacturer: "Cisco",
+		SerialNumber: "123",
+		ControlCards: []*bpb.ControlCard{
+			{SerialNumber: "123A", Slot: 1, PartNumber: "123A"},
+			{SerialNumber: "123B", Slot: 2, PartNumber: "123B"},
+		},
+	}
+}
+
+const testImageHash = "e9c0f8b575cbfcb42ab3b78ecc87efa3b011d9a5d10b09fa4e96f240bf6a82f5"
+
+func TestBootstrap(t *testing.T) {
+	tests := []struct {
+		desc string
+		// servers are the fake servers to start, in discovery order. A nil server is unreachable.
+		servers      []*fakeBootzServer
+		maxAttempts  int
+		wantStatuses [][]string
+		wantErr      string
+	}{{
+		desc:         "Success",
+		servers:      []*fakeBootzServer{{imageHash: testImageHash}},
+		maxAttempts:  1,
+		wantStatuses: [][]string{{"SUCCESS: Bootstrap Success"}},
+	}, {
+		desc:        "Falls back to the next server",
+		servers:     []*fakeBootzServer{{failures: 1}, {imageHash: testImageHash}},
+		maxAttempts: 1,
+		wantStatuses: [][]string{
+			{"FAILURE: fetch step failed"},
+			{"SUCCESS: Bootstrap Success"},
+		},
+	}, {
+		desc:         "Skips unreachable server",
+		servers:      []*fakeBootzServer{nil, {imageHash: testImageHash}},
+		maxAttempts:  1,
+		wantStatuses: [][]string{nil, {"SUCCESS: Bootstrap Success"}},
+	}, {
+		desc:         "Restarts after all servers fail",
+		servers:      []*fakeBootzServer{{failures: 2, imageHash: testImageHash}},
+		maxAttempts:  3,
+		wantStatuses: [][]string{{"FAILURE: fetch step failed", "FAILURE: fetch step failed", "SUCCESS: Bootstrap Success"}},
+	}, {
+		desc:         "Gives up after the last attempt",
+		servers:      []*fakeBootzServer{{imageHash: strings.Repeat("00", 32)}},
+		maxAttempts:  2,
+		wantStatuses: [][]string{{"FAILURE: download step failed", "FAILURE: download step failed"}},
+		wantErr:      "bootstrap failed after 2 attempts: download step failed: invalid intended image: invalid image hash: unmatched hash",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var addresses []string
+			for _, s := range test.servers {
+				if s == nil {
+					addresses = append(addresses, unusedAddress(t))
+					continue
+				}
+				addresses = append(addresses, startFakeBootzServer(t, s))
+			}
+			device, err := NewFSDevice(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			chassis, err := NewChassis(defaultTestChassis(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := New(chassis, device,
+				WithInsecureBoot(),
+				WithTransportCredentials(insecure.NewCredentials()),
+				WithDiscoverer(StaticServers(addresses...)),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: test.maxAttempts, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+				WithDialTimeout(time.Second),
+				WithImageDir(t.TempDir()),
+				WithImageMirrors(map[string]string{"https://path/to/image": "../../testdata/image.txt"}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.Bootstrap(context.Background())
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Errorf("Bootstrap() %s", s)
+			}
+			_, statErr := os.Stat(filepath.Join(device.dir, "123A", imageFile))
+			if installed := statErr == nil; installed != (err == nil) {
+				t.Errorf("Bootstrap() installed image = %v, want %v", installed, err == nil)
+			}
+			for i, s := range test.servers {
+				if s == nil {
+					continue
+				}
+				got := s.statuses()
+				if len(got) != len(test.wantStatuses[i]) {
+					t.Fatalf("server %d got reports %q, want %q", i, got, test.wantStatuses[i])
+				}
+				for j, want := range test.wantStatuses[i] {
+					if !strings.HasPrefix(got[j], want) {
+						t.Errorf("server %d report %d = %q, want prefix %q", i, j, got[j], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBootstrapResume(t *testing.T) {
+	server := &fakeBootzServer{imageHash: testImageHash, vendorConfig: []byte("hostname router")}
+	addr := startFakeBootzServer(t, server)
+	device, err := NewFSDevice(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagePath := filepath.Join(device.dir, "123A", imageFile)
+	configPath := filepath.Join(device.dir, "123A", vendorConfigFile)
+
+	// boot powers the device up and runs the bootstrap, as after each reboot.
+	boot := func() (*Chassis, error) {
+		t.Helper()
+		chassis, err := NewChassis(defaultTestChassis(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := New(chassis, device,
+			WithInsecureBoot(),
+			WithTransportCredentials(insecure.NewCredentials()),
+			WithDiscoverer(StaticServers(addr)),
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			WithImageDir(t.TempDir()),
+			WithImageMirrors(map[string]string{"https://path/to/image": "../../testdata/image.txt"}),
+			WithStateStore(store),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chassis, c.Bootstrap(context.Background())
+	}
+
+	if _, err := boot(); !errors.Is(err, ErrRebootRequired) {
+		t.Fatalf("first Bootstrap() err = %v, want %v", err, ErrRebootRequired)
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		t.Fatalf("image not installed before the first reboot: %v", err)
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		t.Errorf("vendor config applied before the first reboot")
+	}
+	// The image is not installed again after the reboot.
+	if err := os.Remove(imagePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := boot(); !errors.Is(err, ErrRebootRequired) {
+		t.Fatalf("second Bootstrap() err = %v, want %v", err, ErrRebootRequired)
+	}
+	if _, err := os.Stat(imagePath); err == nil {
+		t.Errorf("image installed again after the first reboot")
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		t.Fatalf("vendor config not applied before the second reboot: %v", err)
+	}
+	if got := server.statuses(); len(got) != 0 {
+		t.Errorf("server got reports %q before the bootstrap finished, want none", got)
+	}
+	if _, err := boot(); err != nil {
+		t.Fatalf("third Bootstrap() err = %v, want nil", err)
+	}
+	if got := server.statuses(); len(got) != 1 || got[0] != "SUCCESS: Bootstrap Success" {
+		t.Errorf("server got reports %q, want one success", got)
+	}
+
+	// Once bootstrapped, the cards come up initialized and nothing is applied again.
+	if err := os.Remove(configPath); err != nil {
+		t.Fatal(err)
+	}
+	chassis, err := boot()
+	if err != nil {
+		t.Fatalf("Bootstrap() after the bootstrap err = %v, want nil", err)
+	}
+	for serial, state := range chassis.States {
+		if state != bpb.ControlCardState_CONTROL_CARD_STATUS_INITIALIZED {
+			t.Errorf("control card %v state = %v, want INITIALIZED", serial, state)
+		}
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		t.Errorf("vendor config applied again after the bootstrap")
+	}
+}
+
+func TestVerifyPinsServerTrustCert(t *testing.T) {
+	chassis, err := NewChassis(defaultTestChassis(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := NewFSDevice(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(chassis, device, WithVendorCAs(certPool(t, "vendorca_pub.pem")), WithImageDir(t.TempDir()), WithStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bootstrapper{Client: c, resp: signedTestResponse(t), nonce: testNonce}
+	if err := b.verify(); err != nil {
+		t.Fatalf("verify() err = %v, want nil", err)
+	}
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, ok := state.Cards["123A"]
+	if !ok {
+		t.Fatalf("no saved state for control card 123A")
+	}
+	if got := card.ServerTrustCert; got != "FakeTLSCert" {
+		t.Errorf("saved server trust cert = %q, want %q", got, "FakeTLSCert")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	capped := &RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second, Jitter: 0.2}
+	uncapped := &RetryPolicy{Backoff: time.Second, Jitter: 0.2}
+	tests := []struct {
+		policy   *RetryPolicy
+		failures int
+		want     time.Duration
+	}{
+		{policy: capped, failures: 1, want: time.Second},
+		{policy: capped, failures: 2, want: 2 * time.Second},
+		{policy: capped, failures: 4, want: 8 * time.Second},
+		{policy: capped, failures: 5, want: 10 * time.Second},
+		{policy: capped, failures: 100, want: 10 * time.Second},
+		{policy: uncapped, failures: 1, want: time.Second},
+		{policy: uncapped, failures: 5, want: 16 * time.Second},
+		{policy: uncapped, failures: 20, want: (1 << 19) * time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			got := test.policy.delay(test.failures)
+			min, max := time.Duration(float64(test.want)*0.8), time.Duration(float64(test.want)*1.2)
+			if got < min || got > max {
+				t.Errorf("delay(%d) = %v, want between %v and %v", test.failures, got, min, max)
+			}
+		}
+	}
+}
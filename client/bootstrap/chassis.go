@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// Chassis is the identity of the device being bootstrapped and the state of its control cards.
+type Chassis struct {
+	// Descriptor describes the chassis and its control cards.
+	Descriptor *bpb.ChassisDescriptor
+	// ActiveCard is the control card making the bootstrap request, or nil for fixed form factor devices.
+	ActiveCard *bpb.ControlCard
+	// States holds the current state of each control card, keyed by serial number.
+	States map[string]bpb.ControlCardState_ControlCardStatus
+}
+
+// NewChassis checks that the descriptor identifies the device unambiguously and returns
+// a chassis whose control cards are all NOT_INITIALIZED. The active control card is the
+// card with the given serial number, or the first card if activeSerial is empty.
+func NewChassis(descriptor *bpb.ChassisDescriptor, activeSerial string) (*Chassis, error) {
+	c := &Chassis{
+		Descriptor: descriptor,
+		States:     map[string]bpb.ControlCardState_ControlCardStatus{},
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.selectActiveCard(activeSerial); err != nil {
+		return nil, err
+	}
+	for _, serial := range c.CardSerials() {
+		c.States[serial] = bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED
+	}
+	return c, nil
+}
+
+// validate checks that the chassis descriptor identifies the device unambiguously.
+func (c *Chassis) validate() error {
+	if c.Descriptor.GetManufacturer() == "" {
+		return fmt.Errorf("chassis descriptor has no manufacturer")
+	}
+	cards := c.Descriptor.GetControlCards()
+	if len(cards) == 0 && c.Descriptor.GetSerialNumber() == "" {
+		return fmt.Errorf("fixed form factor chassis must have a serial number")
+	}
+	seen := map[string]bool{}
+	for _, card := range cards {
+		if card.GetSerialNumber() == "" {
+			return fmt.Errorf("control card in slot %v has no serial number", card.GetSlot())
+		}
+		if seen[card.GetSerialNumber()] {
+			return fmt.Errorf("duplicate control card serial number %q", card.GetSerialNumber())
+		}
+		seen[card.GetSerialNumber()] = true
+	}
+	return nil
+}
+
+// selectActiveCard sets the active control card to the card with the given serial,
+// or to the first card if serial is empty.
+func (c *Chassis) selectActiveCard(serial string) error {
+	cards := c.Descriptor.GetControlCards()
+	if len(cards) == 0 {
+		if serial != "" {
+			return fmt.Errorf("active control card %q given for a fixed form factor chassis", serial)
+		}
+		return nil
+	}
+	if serial == "" {
+		c.ActiveCard = cards[0]
+		return nil
+	}
+	for _, card := range cards {
+		if card.GetSerialNumber() == serial {
+			c.ActiveCard = card
+			return nil
+		}
+	}
+	return fmt.Errorf("active control card %q is not in the chassis", serial)
+}
+
+// SetState sets the state of the control card with the given serial number.
+func (c *Chassis) SetState(serial string, state bpb.ControlCardState_ControlCardStatus) error {
+	if _, ok := c.States[serial]; !ok {
+		return fmt.Errorf("unknown control card %q", serial)
+	}
+	c.States[serial] = state
+	return nil
+}
+
+// ActiveSerial returns the serial number identifying the device in requests:
+// the active control card for modular devices and the chassis otherwise.
+func (c *Chassis) ActiveSerial() string {
+	if c.ActiveCard != nil {
+		return c.ActiveCard.GetSerialNumber()
+	}
+	return c.Descriptor.GetSerialNumber()
+}
+
+// ActiveState returns the state of the control card making the request.
+func (c *Chassis) ActiveState() *bpb.ControlCardState {
+	return &bpb.ControlCardState{
+		SerialNumber: c.ActiveSerial(),
+		Status:       c.States[c.ActiveSerial()],
+	}
+}
+
+// CardSerials returns the serial numbers of the control cards, or the chassis serial for fixed form factor devices.
+func (c *Chassis) CardSerials() []string {
+	if len(c.Descriptor.GetControlCards()) == 0 {
+		return []string{c.Descriptor.GetSerialNumber()}
+	}
+	var serials []string
+	for _, card := range c.Descriptor.GetControlCards() {
+		serials = append(serials, card.GetSerialNumber())
+	}
+	return serials
+}
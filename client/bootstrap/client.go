@@ -0,0 +1,303 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bootstrap implements the device side of Bootz: it fetches bootstrap data
+// from a Bootz server, verifies it, downloads the intended images, applies the data
+// to the device and reports the outcome.
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// Represents a 128 bit nonce.
+const nonceLength = 16
+
+// Client bootstraps a device. Create it with New.
+type Client struct {
+	chassis *Chassis
+	// device applies the bootstrap data to the device.
+	device          Device
+	vendorCAs       *x509.CertPool
+	insecureBoot    bool
+	legacyOV        bool
+	requireOVNonce  bool
+	fetchRevocation bool
+	creds           credentials.TransportCredentials
+	discover        Discoverer
+	retry           RetryPolicy
+	dialTimeout     time.Duration
+	downloader      *imageDownloader
+	// store, if set, keeps the state across reboots.
+	store StateStore
+	state *State
+	// verifyServerIdentity checks the server's TLS certificate against the PDC and OC once they are verified.
+	verifyServerIdentity bool
+	// serverCerts is the TLS certificate chain presented by the server of the last Fetch.
+	serverCerts []*x509.Certificate
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithVendorCAs sets the manufacturer CAs the ownership voucher must be signed by.
+// Required unless the device boots insecurely.
+func WithVendorCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.vendorCAs = pool
+	}
+}
+
+// WithInsecureBoot makes the device boot in non-secure mode, in which the server
+// does not send ownership certificates or vouchers and the bootstrap data is not verified.
+func WithInsecureBoot() Option {
+	return func(c *Client) {
+		c.insecureBoot = true
+	}
+}
+
+// WithLegacyOVParsing accepts ownership vouchers issued in the pre-RFC 3339 timestamp format.
+func WithLegacyOVParsing() Option {
+	return func(c *Client) {
+		c.legacyOV = true
+	}
+}
+
+// WithRequireOVNonce requires the ownership voucher to be bound to the nonce sent in
+// the request. Vouchers that carry a nonce are always checked.
+func WithRequireOVNonce() Option {
+	return func(c *Client) {
+		c.requireOVNonce = true
+	}
+}
+
+// WithRevocationFetching sets whether CRLs and OCSP responses the server did not
+// include are fetched when the ownership voucher requests revocation checks. Defaults to true.
+func WithRevocationFetching(fetch bool) Option {
+	return func(c *Client) {
+		c.fetchRevocation = fetch
+	}
+}
+
+// WithServerIdentityVerification makes the client verify the server it fetched the
+// bootstrap data from once the ownership voucher and certificate are verified: the TLS
+// certificate the server presented must chain to the voucher's pinned domain cert or be
+// the ownership certificate. Otherwise Verify fails and the data is not used. This keeps
+// a server which replays data signed for the device from bootstrapping it, while the TLS
+// connection itself still accepts any certificate, as the PDC is not known before the
+// voucher arrives. Requires secure mode.
+func WithServerIdentityVerification() Option {
+	return func(c *Client) {
+		c.verifyServerIdentity = true
+	}
+}
+
+// WithTransportCredentials sets the credentials used to connect to Bootz servers.
+// By default the server's TLS certificate is not verified, as the bootstrap data
+// is authenticated by its signature instead.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(c *Client) {
+		c.creds = creds
+	}
+}
+
+// WithDiscoverer sets how Bootstrap finds the Bootz servers to try.
+func WithDiscoverer(d Discoverer) Option {
+	return func(c *Client) {
+		c.discover = d
+	}
+}
+
+// WithRetryPolicy sets how often and how soon Bootstrap restarts after a failure.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// WithDialTimeout sets how long to wait for a connection to each Bootz server.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = d
+	}
+}
+
+// WithImageDir sets the directory images are downloaded to. Interrupted downloads
+// are resumed from here. Defaults to a new temporary directory.
+func WithImageDir(dir string) Option {
+	return func(c *Client) {
+		c.downloader.dir = dir
+	}
+}
+
+// WithImageRootCAs sets the CAs used to verify HTTPS image servers. Defaults to the system trust store.
+func WithImageRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.downloader.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+}
+
+// WithImageMaxSize sets the maximum size in bytes of a downloaded image.
+func WithImageMaxSize(size int64) Option {
+	return func(c *Client) {
+		c.downloader.maxSize = size
+	}
+}
+
+// WithImageRetries sets how many times a failed image download is retried.
+func WithImageRetries(retries int) Option {
+	return func(c *Client) {
+		c.downloader.retries = retries
+	}
+}
+
+// WithImageMirrors makes images with the given URLs be read from local files instead of downloaded.
+func WithImageMirrors(mirrors map[string]string) Option {
+	return func(c *Client) {
+		for url, path := range mirrors {
+			c.downloader.mirrors[url] = path
+		}
+	}
+}
+
+// WithStateStore keeps the control card statuses, installed images, applied configuration
+// and pinned server certificates in store across reboots. The saved statuses replace
+// those of the chassis, images already running are not installed again, and Bootstrap
+// stops with ErrRebootRequired after installing an image or applying new configuration.
+func WithStateStore(store StateStore) Option {
+	return func(c *Client) {
+		c.store = store
+	}
+}
+
+// New returns a client bootstrapping the chassis, which applies the bootstrap data to device.
+// The device is only needed by Bootstrap and may be nil for clients which just fetch,
+// verify and report.
+func New(chassis *Chassis, device Device, opts ...Option) (*Client, error) {
+	if chassis == nil {
+		return nil, fmt.Errorf("a chassis is required")
+	}
+	c := &Client{
+		chassis:         chassis,
+		device:          device,
+		fetchRevocation: true,
+		creds:           credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}),
+		retry:           DefaultRetryPolicy,
+		dialTimeout:     defaultDialTimeout,
+		downloader:      newImageDownloader(),
+		state:           &State{Cards: map[string]*CardState{}},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if !c.insecureBoot && c.vendorCAs == nil {
+		return nil, fmt.Errorf("a vendor CA is required to verify bootstrap data in secure mode")
+	}
+	if c.insecureBoot && c.verifyServerIdentity {
+		return nil, fmt.Errorf("the server identity can only be verified in secure mode")
+	}
+	if c.store != nil {
+		state, err := c.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		if err := restoreState(chassis, state); err != nil {
+			return nil, err
+		}
+		c.state = state
+	}
+	return c, nil
+}
+
+// NewNonce generates a fresh nonce for a bootstrap request.
+func NewNonce() (string, error) {
+	b := make([]byte, nonceLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Request builds the bootstrap data request of the active control card with the given nonce.
+func (c *Client) Request(nonce string) *bpb.GetBootstrapDataRequest {
+	return &bpb.GetBootstrapDataRequest{
+		ChassisDescriptor: c.chassis.Descriptor,
+		// This is the active control card, e.g. the one making the bootz request.
+		ControlCardState: c.chassis.ActiveState(),
+		Nonce:            nonce,
+	}
+}
+
+// Fetch requests the bootstrap data from a Bootz server. The nonce should be
+// generated with NewNonce for each request, or be empty in insecure mode. The TLS
+// certificate the server presented is kept for Verify.
+func (c *Client) Fetch(ctx context.Context, client bpb.BootstrapClient, nonce string) (*bpb.GetBootstrapDataResponse, error) {
+	req := c.Request(nonce)
+	log.Infof("Built bootstrap data request with %v chassis %v and control card %v with status %v and nonce %v",
+		req.ChassisDescriptor.Manufacturer, req.ChassisDescriptor.SerialNumber, req.ControlCardState.SerialNumber, req.ControlCardState.Status, req.Nonce)
+	var p peer.Peer
+	resp, err := client.GetBootstrapData(ctx, req, grpc.Peer(&p))
+	if err != nil {
+		return nil, fmt.Errorf("GetBootstrapData failed: %w", err)
+	}
+	c.serverCerts = nil
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		c.serverCerts = info.State.PeerCertificates
+	}
+	log.Infof("Successfully retrieved Bootstrap Data from server")
+	return resp, nil
+}
+
+// Report sends a status report for every control card. On success all cards are
+// reported as initialized, and their state is updated once the server has the report.
+// Otherwise the cards are reported in their current state.
+func (c *Client) Report(ctx context.Context, client bpb.BootstrapClient, status bpb.ReportStatusRequest_BootstrapStatus, message string) error {
+	req := &bpb.ReportStatusRequest{
+		Status:        status,
+		StatusMessage: message,
+	}
+	for _, serial := range c.chassis.CardSerials() {
+		state := c.chassis.States[serial]
+		if status == bpb.ReportStatusRequest_BOOTSTRAP_STATUS_SUCCESS {
+			state = bpb.ControlCardState_CONTROL_CARD_STATUS_INITIALIZED
+		}
+		req.States = append(req.States, &bpb.ControlCardState{
+			Status:       state,
+			SerialNumber: serial,
+		})
+	}
+	if _, err := client.ReportStatus(ctx, req); err != nil {
+		return fmt.Errorf("unable to report status: %w", err)
+	}
+	for _, s := range req.GetStates() {
+		c.chassis.States[s.GetSerialNumber()] = s.GetStatus()
+	}
+	log.Infof("Status report sent")
+	return nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+	authz "github.com/openconfig/gnsi/authz"
+	certz "github.com/openconfig/gnsi/certz"
+	pathz "github.com/openconfig/gnsi/pathz"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// Names of the files the filesystem device writes in each control card's directory.
+const (
+	imageFile              = "image"
+	imageInfoFile          = "image.textproto"
+	vendorConfigFile       = "vendor_config"
+	ocConfigFile           = "oc_config.json"
+	bootloaderPasswordFile = "bootloader_password_hash"
+	bootloaderConfigFile   = "bootloader_config.json"
+	metadataFile           = "metadata.json"
+	authzFile              = "authz.textproto"
+	pathzFile              = "pathz.textproto"
+	certzFile              = "certz.textproto"
+	credentialsFile        = "credentials.textproto"
+)
+
+// Device applies bootstrap data to a control card of the device.
+// Each method is given the serial number of the control card the data is for.
+type Device interface {
+	// InstallImage installs the image downloaded to path.
+	InstallImage(ctx context.Context, serial string, image *bpb.SoftwareImage, path string) error
+	// ApplyVendorConfig applies configuration in the vendor's native format.
+	ApplyVendorConfig(ctx context.Context, serial string, config []byte) error
+	// ApplyOCConfig applies JSON rendered OpenConfig configuration.
+	ApplyOCConfig(ctx context.Context, serial string, config []byte) error
+	// SetBootloaderPassword sets the hash of the bootloader password.
+	SetBootloaderPassword(ctx context.Context, serial string, hash string) error
+	// ApplyBootloaderConfig applies the bootloader key-value parameters.
+	ApplyBootloaderConfig(ctx context.Context, serial string, config *structpb.Struct) error
+	// ApplyMetadata applies the proprietary boot key-value parameters.
+	ApplyMetadata(ctx context.Context, serial string, metadata *structpb.Struct) error
+	// UploadAuthz installs the gNSI authz policy.
+	UploadAuthz(ctx context.Context, serial string, req *authz.UploadRequest) error
+	// UploadPathz installs the gNSI pathz policy.
+	UploadPathz(ctx context.Context, serial string, req *pathz.UploadRequest) error
+	// UploadCertz installs the gNSI certz certificates.
+	UploadCertz(ctx context.Context, serial string, req *certz.UploadRequest) error
+	// UploadCredentials installs the gNSI credentialz keys, users and passwords.
+	UploadCredentials(ctx context.Context, serial string, creds *bpb.Credentials) error
+}
+
+// artifact is a piece of configuration in the bootstrap data.
+type artifact struct {
+	// name identifies the artifact in the saved config hashes.
+	name string
+	// action describes applying the artifact in errors.
+	action string
+	// data is the serialized artifact, which its hash is computed from.
+	data  []byte
+	apply func() error
+}
+
+// configArtifacts returns the configuration in the bootstrap data, in the order it is applied.
+// Empty fields are left out, so the device only sees the data the server sent.
+func configArtifacts(ctx context.Context, d Device, data *bpb.BootstrapDataResponse) ([]artifact, error) {
+	serial := data.GetSerialNum()
+	var artifacts []artifact
+	add := func(name, action string, m proto.Message, apply func() error) error {
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("unable to serialize %v: %v", name, err)
+		}
+		artifacts = append(artifacts, artifact{name: name, action: action, data: b, apply: apply})
+		return nil
+	}
+	if hash := data.GetBootPasswordHash(); hash != "" {
+		artifacts = append(artifacts, artifact{name: "bootloader_password", action: "set bootloader password", data: []byte(hash),
+			apply: func() error { return d.SetBootloaderPassword(ctx, serial, hash) }})
+	}
+	boot := data.GetBootConfig()
+	if c := boot.GetBootloaderConfig(); c != nil {
+		if err := add("bootloader_config", "apply bootloader config", c,
+			func() error { return d.ApplyBootloaderConfig(ctx, serial, c) }); err != nil {
+			return nil, err
+		}
+	}
+	if m := boot.GetMetadata(); m != nil {
+		if err := add("metadata", "apply metadata", m,
+			func() error { return d.ApplyMetadata(ctx, serial, m) }); err != nil {
+			return nil, err
+		}
+	}
+	if c := boot.GetVendorConfig(); len(c) > 0 {
+		artifacts = append(artifacts, artifact{name: "vendor_config", action: "apply vendor config", data: c,
+			apply: func() error { return d.ApplyVendorConfig(ctx, serial, c) }})
+	}
+	if c := boot.GetOcConfig(); len(c) > 0 {
+		artifacts = append(artifacts, artifact{name: "oc_config", action: "apply OC config", data: c,
+			apply: func() error { return d.ApplyOCConfig(ctx, serial, c) }})
+	}
+	if p := data.GetAuthz(); p != nil {
+		if err := add("authz", "upload authz policy", p,
+			func() error { return d.UploadAuthz(ctx, serial, p) }); err != nil {
+			return nil, err
+		}
+	}
+	if p := data.GetPathz(); p != nil {
+		if err := add("pathz", "upload pathz policy", p,
+			func() error { return d.UploadPathz(ctx, serial, p) }); err != nil {
+			return nil, err
+		}
+	}
+	if c := data.GetCertificates(); c != nil {
+		if err := add("certz", "upload certificates", c,
+			func() error { return d.UploadCertz(ctx, serial, c) }); err != nil {
+			return nil, err
+		}
+	}
+	if c := data.GetCredentials(); c != nil {
+		if err := add("credentials", "upload credentials", c,
+			func() error { return d.UploadCredentials(ctx, serial, c) }); err != nil {
+			return nil, err
+		}
+	}
+	return artifacts, nil
+}
+
+// applyConfig applies the configuration in the bootstrap data to the device, skipping
+// the artifacts whose hash is already in applied. The hashes of the applied artifacts
+// are added to applied, and the number of artifacts applied is returned.
+func applyConfig(ctx context.Context, d Device, data *bpb.BootstrapDataResponse, applied map[string]string) (int, error) {
+	artifacts, err := configArtifacts(ctx, d, data)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, a := range artifacts {
+		sum := sha256.Sum256(a.data)
+		hash := hex.EncodeToString(sum[:])
+		if applied[a.name] == hash {
+			log.Infof("Skipping %v on control card %v, it is already applied", a.name, data.GetSerialNum())
+			continue
+		}
+		log.Infof("Applying %v on control card %v...", a.name, data.GetSerialNum())
+		if err := a.apply(); err != nil {
+			return n, fmt.Errorf("unable to %v: %v", a.action, err)
+		}
+		applied[a.name] = hash
+		n++
+	}
+	return n, nil
+}
+
+// FSDevice is a fake Device which writes every artifact it is given to a file in
+// a directory per control card, so tests can check exactly what a device received.
+type FSDevice struct {
+	dir string
+}
+
+// NewFSDevice returns a filesystem device writing to dir, which is created if needed.
+func NewFSDevice(dir string) (*FSDevice, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create device directory: %v", err)
+	}
+	return &FSDevice{dir: dir}, nil
+}
+
+// path returns the path of the named file of the control card, creating its directory.
+func (d *FSDevice) path(serial, name string) (string, error) {
+	if serial == "" || serial != filepath.Base(serial) || serial == ".." {
+		return "", fmt.Errorf("invalid control card serial number %q", serial)
+	}
+	dir := filepath.Join(d.dir, serial)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func (d *FSDevice) write(serial, name string, data []byte) error {
+	path, err := d.path(serial, name)
+	if err != nil {
+		return err
+	}
+	log.Infof("Writing %v for control card %v to %v", name, serial, path)
+	return os.WriteFile(path, data, 0644)
+}
+
+func (d *FSDevice) writeText(serial, name string, m proto.Message) error {
+	data, err := prototext.MarshalOptions{Multiline: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return d.write(serial, name, data)
+}
+
+func (d *FSDevice) writeJSON(serial, name string, m proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return d.write(serial, name, data)
+}
+
+// InstallImage copies the image into the control card's directory.
+func (d *FSDevice) InstallImage(ctx context.Context, serial string, image *bpb.SoftwareImage, path string) error {
+	dest, err := d.path(serial, imageFile)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return d.writeText(serial, imageInfoFile, image)
+}
+
+// ApplyVendorConfig writes the vendor config as is.
+func (d *FSDevice) ApplyVendorConfig(ctx context.Context, serial string, config []byte) error {
+	return d.write(serial, vendorConfigFile, config)
+}
+
+// ApplyOCConfig writes the OC config as is.
+func (d *FSDevice) ApplyOCConfig(ctx context.Context, serial string, config []byte) error {
+	return d.write(serial, ocConfigFile, config)
+}
+
+// SetBootloaderPassword writes the password hash.
+func (d *FSDevice) SetBootloaderPassword(ctx context.Context, serial string, hash string) error {
+	return d.write(serial, bootloaderPasswordFile, []byte(hash))
+}
+
+// ApplyBootloaderConfig writes the bootloader config as JSON.
+func (d *FSDevice) ApplyBootloaderConfig(ctx context.Context, serial string, config *structpb.Struct) error {
+	return d.writeJSON(serial, bootloaderConfigFile, config)
+}
+
+// ApplyMetadata writes the metadata as JSON.
+func (d *FSDevice) ApplyMetadata(ctx context.Context, serial string, metadata *structpb.Struct) error {
+	return d.writeJSON(serial, metadataFile, metadata)
+}
+
+// UploadAuthz writes the authz upload request as prototext.
+func (d *FSDevice) UploadAuthz(ctx context.Context, serial string, req *authz.UploadRequest) error {
+	return d.writeText(serial, authzFile, req)
+}
+
+// UploadPathz writes the pathz upload request as prototext.
+func (d *FSDevice) UploadPathz(ctx context.Context, serial string, req *pathz.UploadRequest) error {
+	return d.writeText(serial, pathzFile, req)
+}
+
+// UploadCertz writes the certz upload request as prototext.
+func (d *FSDevice) UploadCertz(ctx context.Context, serial string, req *certz.UploadRequest) error {
+	return d.writeText(serial, certzFile, req)
+}
+
+// UploadCredentials writes the credentials as prototext.
+func (d *FSDevice) UploadCredentials(ctx context.Context, serial string, creds *bpb.Credentials) error {
+	return d.writeText(serial, credentialsFile, creds)
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/h-fam/errdiff"
+	authz "github.com/openconfig/gnsi/authz"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+func TestApplyConfig(t *testing.T) {
+	metadata, err := structpb.NewStruct(map[string]any{"feature": "on"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc      string
+		data      *bpb.BootstrapDataResponse
+		applied   map[string]string
+		wantFiles map[string]string
+		wantErr   string
+	}{{
+		desc: "All artifacts",
+		data: &bpb.BootstrapDataResponse{
+			SerialNum:        "123A",
+			IntendedImage:    &bpb.SoftwareImage{Name: "Default Image", Url: "https://path/to/image"},
+			BootPasswordHash: "ABCD123",
+			BootConfig: &bpb.BootConfig{
+				VendorConfig:     []byte("hostname router"),
+				OcConfig:         []byte(`{"system": {}}`),
+				Metadata:         metadata,
+				BootloaderConfig: metadata,
+			},
+			Authz:       &authz.UploadRequest{Version: "v1", Policy: "{}"},
+			Credentials: &bpb.Credentials{},
+		},
+		wantFiles: map[string]string{
+			bootloaderPasswordFile: "ABCD123",
+			bootloaderConfigFile:   "",
+			metadataFile:           "",
+			vendorConfigFile:       "hostname router",
+			ocConfigFile:           `{"system": {}}`,
+			authzFile:              "",
+			credentialsFile:        "",
+		},
+	}, {
+		desc: "Only what the server sent",
+		data: &bpb.BootstrapDataResponse{
+			SerialNum:  "123B",
+			BootConfig: &bpb.BootConfig{VendorConfig: []byte("hostname router")},
+		},
+		wantFiles: map[string]string{vendorConfigFile: "hostname router"},
+	}, {
+		desc: "Skips applied artifacts",
+		data: &bpb.BootstrapDataResponse{
+			SerialNum:        "123A",
+			BootPasswordHash: "ABCD123",
+			BootConfig:       &bpb.BootConfig{VendorConfig: []byte("hostname router")},
+		},
+		applied: map[string]string{
+			// SHA-256 of "hostname router".
+			"vendor_config": "0eef28b07ea47b26283640737f0e1bb92ec66f6f7cdc5fc53ba31357778882e5",
+		},
+		wantFiles: map[string]string{bootloaderPasswordFile: "ABCD123"},
+	}, {
+		desc:    "Serial number escaping the directory",
+		data:    &bpb.BootstrapDataResponse{SerialNum: "../123A", BootPasswordHash: "ABCD123"},
+		wantErr: "invalid control card serial number",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d, err := NewFSDevice(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			applied := map[string]string{}
+			for name, hash := range test.applied {
+				applied[name] = hash
+			}
+			_, err = applyConfig(context.Background(), d, test.data, applied)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("applyConfig() %s", s)
+			}
+			if err != nil {
+				return
+			}
+			dir := filepath.Join(d.dir, test.data.GetSerialNum())
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			for name, content := range test.wantFiles {
+				want = append(want, name)
+				if content == "" {
+					continue
+				}
+				b, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != content {
+					t.Errorf("%v = %q, want %q", name, b, content)
+				}
+			}
+			sort.Strings(want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("applyConfig() wrote files diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFSDeviceRoundTrip(t *testing.T) {
+	d, err := NewFSDevice(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	config, err := structpb.NewStruct(map[string]any{"timeout": 30, "console": "ttyS0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ApplyBootloaderConfig(ctx, "123A", config); err != nil {
+		t.Fatalf("ApplyBootloaderConfig() err = %v", err)
+	}
+	policy := &authz.UploadRequest{Version: "v1", CreatedOn: 1, Policy: `{"name": "default"}`}
+	if err := d.UploadAuthz(ctx, "123A", policy); err != nil {
+		t.Fatalf("UploadAuthz() err = %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(d.dir, "123A", bootloaderConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotConfig := &structpb.Struct{}
+	if err := protojson.Unmarshal(b, gotConfig); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(config, gotConfig, protocmp.Transform()); diff != "" {
+		t.Errorf("bootloader config diff (-want +got):\n%s", diff)
+	}
+	b, err = os.ReadFile(filepath.Join(d.dir, "123A", authzFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotPolicy := &authz.UploadRequest{}
+	if err := prototext.Unmarshal(b, gotPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(policy, gotPolicy, protocmp.Transform()); diff != "" {
+		t.Errorf("authz policy diff (-want +got):\n%s", diff)
+	}
+}
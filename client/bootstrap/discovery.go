@@ -0,0 +1,224 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+)
+
+// Discoverer returns the addresses of the Bootz servers to try, in order.
+type Discoverer func(ctx context.Context) ([]string, error)
+
+// StaticServers returns a Discoverer which always returns the given addresses.
+func StaticServers(addresses ...string) Discoverer {
+	return func(context.Context) ([]string, error) {
+		return addresses, nil
+	}
+}
+
+// dhcpConfig configures DHCP discovery.
+type dhcpConfig struct {
+	ipv4    bool
+	ipv6    bool
+	timeout time.Duration
+}
+
+// DHCPOption configures DHCP discovery.
+type DHCPOption func(*dhcpConfig)
+
+// WithDHCPv4 sets whether a DHCPv4 request is sent. Defaults to true.
+func WithDHCPv4(enabled bool) DHCPOption {
+	return func(c *dhcpConfig) {
+		c.ipv4 = enabled
+	}
+}
+
+// WithDHCPv6 sets whether a DHCPv6 solicit is sent. Defaults to true.
+func WithDHCPv6(enabled bool) DHCPOption {
+	return func(c *dhcpConfig) {
+		c.ipv6 = enabled
+	}
+}
+
+// WithDHCPTimeout sets how long to wait for each DHCP reply before retransmitting.
+func WithDHCPTimeout(d time.Duration) DHCPOption {
+	return func(c *dhcpConfig) {
+		c.timeout = d
+	}
+}
+
+// DHCPDiscoverer returns a Discoverer which finds the Bootz servers in the sZTP redirect
+// options of DHCP replies on iface. The serial number is sent as DHCPv4 client identifier.
+func DHCPDiscoverer(iface string, serial string, opts ...DHCPOption) Discoverer {
+	cfg := &dhcpConfig{ipv4: true, ipv6: true, timeout: defaultDHCPTimeout}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(ctx context.Context) ([]string, error) {
+		addresses, err := discoverBootzServers(ctx, iface, serial, cfg)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Discovered bootz servers %v", addresses)
+		return addresses, nil
+	}
+}
+
+const (
+	// bootzScheme is the URI scheme of Bootz servers in the sZTP redirect options.
+	bootzScheme = "bootz"
+	// dhcpRetries is how many times a DHCP message is retransmitted.
+	dhcpRetries = 3
+	// defaultDHCPTimeout is how long to wait for each DHCP reply by default.
+	defaultDHCPTimeout = 5 * time.Second
+	// defaultDialTimeout is how long to wait for a connection to each Bootz server.
+	defaultDialTimeout = 10 * time.Second
+)
+
+// parseBootstrapServerList parses the value of an sZTP redirect option. A value
+// which is not an RFC 8572 list of URIs is taken to be a single URI, as sent by
+// servers which predate the RFC encoding.
+func parseBootstrapServerList(data []byte) []string {
+	uris, err := plbootz.ParseBootstrapServerList(data)
+	if err != nil {
+		return []string{string(data)}
+	}
+	return uris
+}
+
+// bootzAddress returns the host:port to dial for a bootz://host:port URI.
+func bootzAddress(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid bootstrap server URI %q: %v", uri, err)
+	}
+	if u.Scheme != bootzScheme {
+		return "", fmt.Errorf("bootstrap server URI %q does not have the %v scheme", uri, bootzScheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return "", fmt.Errorf("bootstrap server URI %q must have a host and a port", uri)
+	}
+	return u.Host, nil
+}
+
+// discoverV4 sends a DHCPv4 request on iface which asks for the sZTP redirect option
+// and returns the URIs in the reply. The client identifier is the device serial number.
+func discoverV4(ctx context.Context, iface string, serial string, timeout time.Duration) ([]string, error) {
+	client, err := nclient4.New(iface, nclient4.WithTimeout(timeout), nclient4.WithRetry(dhcpRetries))
+	if err != nil {
+		return nil, fmt.Errorf("unable to start DHCPv4 client on %v: %v", iface, err)
+	}
+	defer client.Close()
+	code := dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT)
+	lease, err := client.Request(ctx,
+		dhcpv4.WithRequestedOptions(code),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(append([]byte{0}, serial...))),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("DHCPv4 request failed: %v", err)
+	}
+	log.Infof("DHCPv4 lease: address %v from server %v", lease.ACK.YourIPAddr, lease.ACK.ServerIdentifier())
+	data := lease.ACK.Options.Get(code)
+	if data == nil {
+		return nil, fmt.Errorf("DHCPv4 reply has no sZTP redirect option")
+	}
+	return parseBootstrapServerList(data), nil
+}
+
+// discoverV6 sends a DHCPv6 solicit on iface which asks for the sZTP redirect option
+// and returns the URIs in the reply.
+func discoverV6(ctx context.Context, iface string, timeout time.Duration) ([]string, error) {
+	client, err := nclient6.New(iface, nclient6.WithTimeout(timeout), nclient6.WithRetry(dhcpRetries))
+	if err != nil {
+		return nil, fmt.Errorf("unable to start DHCPv6 client on %v: %v", iface, err)
+	}
+	defer client.Close()
+	code := dhcpv6.OptionCode(plbootz.OPTION_V6_SZTP_REDIRECT)
+	reply, err := client.RapidSolicit(ctx, dhcpv6.WithRequestedOptions(code))
+	if err != nil {
+		return nil, fmt.Errorf("DHCPv6 solicit failed: %v", err)
+	}
+	log.Infof("DHCPv6 reply from server %v", reply.Options.ServerID())
+	var uris []string
+	for _, opt := range reply.Options.Get(code) {
+		uris = append(uris, parseBootstrapServerList(opt.ToBytes())...)
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("DHCPv6 reply has no sZTP redirect option")
+	}
+	return uris, nil
+}
+
+// discoverBootzServers finds the Bootz servers advertised by DHCP on iface and returns
+// their addresses in the order they should be tried: the DHCPv4 servers, then the DHCPv6 ones.
+func discoverBootzServers(ctx context.Context, iface string, serial string, cfg *dhcpConfig) ([]string, error) {
+	var uris []string
+	if cfg.ipv4 {
+		v4, err := discoverV4(ctx, iface, serial, cfg.timeout)
+		if err != nil {
+			log.Warningf("DHCPv4 discovery on %v failed: %v", iface, err)
+		}
+		uris = append(uris, v4...)
+	}
+	if cfg.ipv6 {
+		v6, err := discoverV6(ctx, iface, cfg.timeout)
+		if err != nil {
+			log.Warningf("DHCPv6 discovery on %v failed: %v", iface, err)
+		}
+		uris = append(uris, v6...)
+	}
+	var addresses []string
+	seen := map[string]bool{}
+	for _, uri := range uris {
+		addr, err := bootzAddress(uri)
+		if err != nil {
+			log.Warningf("Ignoring bootstrap server: %v", err)
+			continue
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			addresses = append(addresses, addr)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no Bootz server discovered on %v", iface)
+	}
+	return addresses, nil
+}
+
+// dialBootzServer connects to the Bootz server at addr, waiting at most timeout for the connection.
+func dialBootzServer(ctx context.Context, addr string, creds credentials.TransportCredentials, timeout time.Duration) (*grpc.ClientConn, error) {
+	log.Infof("Connecting to bootz server at address %q", addr)
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to bootz server at %q: %v", addr, err)
+	}
+	return conn, nil
+}
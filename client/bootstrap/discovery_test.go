@@ -0,0 +1,212 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/h-fam/errdiff"
+
+	"github.com/openconfig/bootz/dhcp"
+)
+
+func TestParseBootstrapServerList(t *testing.T) {
+	tests := []struct {
+		desc string
+		data []byte
+		want []string
+	}{{
+		desc: "RFC 8572 list",
+		data: []byte("\x00\x10bootz://a:1/grpc\x00\x10bootz://[::1]:15"),
+		want: []string{"bootz://a:1/grpc", "bootz://[::1]:15"},
+	}, {
+		desc: "Bare URI",
+		data: []byte("bootz://a:1/grpc"),
+		want: []string{"bootz://a:1/grpc"},
+	}, {
+		desc: "Truncated list",
+		data: []byte("\x00\x20bootz://a:1"),
+		want: []string{"\x00\x20bootz://a:1"},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, parseBootstrapServerList(test.data)); diff != "" {
+				t.Errorf("parseBootstrapServerList() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBootzAddress(t *testing.T) {
+	tests := []struct {
+		desc    string
+		uri     string
+		want    string
+		wantErr string
+	}{{
+		desc: "Host and port",
+		uri:  "bootz://bootz.example.com:15006/grpc",
+		want: "bootz.example.com:15006",
+	}, {
+		desc: "IPv6 host",
+		uri:  "bootz://[2001:db8::1]:15006",
+		want: "[2001:db8::1]:15006",
+	}, {
+		desc:    "Wrong scheme",
+		uri:     "https://bootz.example.com:15006",
+		wantErr: "does not have the bootz scheme",
+	}, {
+		desc:    "No port",
+		uri:     "bootz://bootz.example.com",
+		wantErr: "must have a host and a port",
+	}, {
+		desc:    "Not a URI",
+		uri:     "\x00\x20bootz://a:1",
+		wantErr: "invalid bootstrap server URI",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := bootzAddress(test.uri)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("bootzAddress() %s", s)
+			}
+			if got != test.want {
+				t.Errorf("bootzAddress() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// setupVeth creates a veth pair, with the server end addressed, and returns the client end.
+// The test is skipped if the pair cannot be created, e.g. when not running as root.
+func setupVeth(t *testing.T, server, client, serverAddr string) *net.Interface {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("creating a veth pair requires root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("the ip command is required to create a veth pair")
+	}
+	run := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%v failed: %v: %s", args, err, out)
+		}
+	}
+	if out, err := exec.Command("ip", "link", "add", server, "type", "veth", "peer", "name", client).CombinedOutput(); err != nil {
+		t.Skipf("unable to create a veth pair: %v: %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("ip", "link", "del", server).Run() })
+	for _, intf := range []string{server, client} {
+		// Skip duplicate address detection so the link local addresses are usable at once.
+		os.WriteFile("/proc/sys/net/ipv6/conf/"+intf+"/accept_dad", []byte("0"), 0644)
+		run("ip", "link", "set", intf, "up")
+	}
+	run("ip", "addr", "add", serverAddr, "dev", server)
+	intf, err := net.InterfaceByName(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return intf
+}
+
+func TestDiscoverBootzServers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping DHCP end-to-end test in short mode")
+	}
+	client := setupVeth(t, "bzdhcps0", "bzdhcpc0", "192.0.2.1/24")
+	conf := &dhcp.Config{
+		Interfaces: []string{"bzdhcps0"},
+		AddressMap: map[string]*dhcp.Entry{
+			client.HardwareAddr.String(): {IP: "192.0.2.10/24", Gw: "192.0.2.1"},
+		},
+		BootzURL: "bootz://192.0.2.1:15006/grpc",
+	}
+	srv, err := dhcp.New(conf)
+	if err != nil {
+		t.Fatalf("dhcp.New() err = %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() err = %v", err)
+	}
+	defer srv.Stop()
+
+	ctx := context.Background()
+	v4, err := discoverV4(ctx, client.Name, "123A", defaultDHCPTimeout)
+	if err != nil {
+		t.Fatalf("discoverV4() err = %v", err)
+	}
+	if diff := cmp.Diff([]string{conf.BootzURL}, v4); diff != "" {
+		t.Errorf("discoverV4() diff (-want +got):\n%s", diff)
+	}
+	v6, err := discoverV6(ctx, client.Name, defaultDHCPTimeout)
+	if err != nil {
+		t.Fatalf("discoverV6() err = %v", err)
+	}
+	if diff := cmp.Diff([]string{conf.BootzURL}, v6); diff != "" {
+		t.Errorf("discoverV6() diff (-want +got):\n%s", diff)
+	}
+	got, err := DHCPDiscoverer(client.Name, "123A")(ctx)
+	if err != nil {
+		t.Fatalf("DHCPDiscoverer() err = %v", err)
+	}
+	if diff := cmp.Diff([]string{"192.0.2.1:15006"}, got); diff != "" {
+		t.Errorf("DHCPDiscoverer() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiscoverBootzServersSeveralDHCPServers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping DHCP end-to-end test in short mode")
+	}
+	// The second server only serves IPv6, on an interface without an IPv4 address.
+	clients := []*net.Interface{
+		setupVeth(t, "bzdhcps1", "bzdhcpc1", "192.0.2.1/24"),
+		setupVeth(t, "bzdhcps2", "bzdhcpc2", "2001:db8::1/64"),
+	}
+	confs := []*dhcp.Config{{
+		Interfaces: []string{"bzdhcps1"},
+		BootzURL:   "bootz://192.0.2.1:15006/grpc",
+	}, {
+		Interfaces: []string{"bzdhcps2"},
+		Families:   []dhcp.Family{dhcp.IPv6},
+		BootzURL:   "bootz://[2001:db8::1]:15006/grpc",
+	}}
+	for _, conf := range confs {
+		srv, err := dhcp.New(conf)
+		if err != nil {
+			t.Fatalf("dhcp.New() err = %v", err)
+		}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("Start() err = %v", err)
+		}
+		defer srv.Stop()
+	}
+
+	for i, client := range clients {
+		got, err := discoverV6(context.Background(), client.Name, defaultDHCPTimeout)
+		if err != nil {
+			t.Fatalf("discoverV6(%v) err = %v", client.Name, err)
+		}
+		if diff := cmp.Diff([]string{confs[i].BootzURL}, got); diff != "" {
+			t.Errorf("discoverV6(%v) diff (-want +got):\n%s", client.Name, diff)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// defaultImageMaxSize is the default maximum size of a downloaded image.
+	defaultImageMaxSize = 8 << 30
+	// defaultImageRetries is how many times a failed download is retried by default.
+	defaultImageRetries = 5
+	// defaultInitialBackoff is the delay before the first retry of a failed download.
+	defaultInitialBackoff = time.Second
+	// defaultMaxBackoff caps the delay between retries.
+	defaultMaxBackoff = 30 * time.Second
+)
+
+var errRangeMismatch = errors.New("server resumed the download at the wrong offset")
+
+// permanentError is a download failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// downloadedImage is an image which has been downloaded in full.
+type downloadedImage struct {
+	path   string
+	size   int64
+	digest []byte
+}
+
+// imageDownloader downloads images over HTTP(S), hashing them as they are written to disk.
+type imageDownloader struct {
+	client     *http.Client
+	dir        string
+	maxSize    int64
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// mirrors maps image URLs to local files.
+	mirrors map[string]string
+}
+
+// newImageDownloader returns a downloader with the default settings, which
+// downloads to a new temporary directory and trusts the system CAs.
+func newImageDownloader() *imageDownloader {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &imageDownloader{
+		client:     &http.Client{Transport: transport},
+		maxSize:    defaultImageMaxSize,
+		retries:    defaultImageRetries,
+		backoff:    defaultInitialBackoff,
+		maxBackoff: defaultMaxBackoff,
+		mirrors:    map[string]string{},
+	}
+}
+
+// imageFileName returns the name an image with the given URL is stored under.
+// The URL hash keeps images with the same base name apart.
+func imageFileName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return fmt.Sprintf("%s-%s", hex.EncodeToString(sum[:8]), path.Base(url))
+}
+
+// transfer holds the state of an image download across attempts.
+type transfer struct {
+	url  string
+	file *os.File
+	hash hash.Hash
+	// offset is the number of bytes written to file and hash.
+	offset int64
+	// validator is the ETag or Last-Modified value of the image, used to make sure a resumed
+	// download continues the same image.
+	validator string
+}
+
+// restart discards everything downloaded so far.
+func (t *transfer) restart() error {
+	if err := t.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	t.hash.Reset()
+	t.offset = 0
+	return nil
+}
+
+// download fetches the image at url and returns it along with its digest, computed with newHash.
+// Failed downloads are retried with exponential backoff and resumed with range requests. A partial
+// download left in the image directory by an earlier run is resumed too.
+func (d *imageDownloader) download(ctx context.Context, url string, newHash func() hash.Hash) (*downloadedImage, error) {
+	if local, ok := d.mirrors[url]; ok {
+		log.Infof("Reading image %q from local mirror %v", url, local)
+		return hashFile(local, newHash)
+	}
+	if d.dir == "" {
+		dir, err := os.MkdirTemp("", "bootz-images-")
+		if err != nil {
+			return nil, err
+		}
+		d.dir = dir
+	}
+	dest := filepath.Join(d.dir, imageFileName(url))
+	partial := dest + ".partial"
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v: %v", partial, err)
+	}
+	defer f.Close()
+	t := &transfer{url: url, file: f, hash: newHash()}
+	// Hash whatever an earlier, interrupted download left behind.
+	if t.offset, err = io.Copy(t.hash, f); err != nil {
+		return nil, fmt.Errorf("unable to read %v: %v", partial, err)
+	}
+	if t.offset > 0 {
+		log.Infof("Resuming download of %q at byte %d", url, t.offset)
+	}
+
+	backoff := d.backoff
+	for attempt := 0; ; attempt++ {
+		err = d.fetch(ctx, t)
+		if err == nil {
+			break
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= d.retries {
+			return nil, fmt.Errorf("unable to download image %q: %v", url, err)
+		}
+		log.Warningf("Download of %q failed at byte %d, retrying in %v: %v", url, t.offset, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return nil, err
+	}
+	log.Infof("Image %q downloaded to %v, size: %d", url, dest, t.offset)
+	return &downloadedImage{path: dest, size: t.offset, digest: t.hash.Sum(nil)}, nil
+}
+
+// fetch makes one attempt at downloading the rest of the image.
+func (d *imageDownloader) fetch(ctx context.Context, t *transfer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return &permanentError{err}
+	}
+	if t.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", t.offset))
+		if t.validator != "" {
+			req.Header.Set("If-Range", t.validator)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return &permanentError{err}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if t.offset > 0 {
+			log.Infof("Server does not support resuming %q, restarting the download", t.url)
+			if err := t.restart(); err != nil {
+				return &permanentError{err}
+			}
+		}
+		total = resp.ContentLength
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return &permanentError{err}
+		}
+		if start != t.offset {
+			if err := t.restart(); err != nil {
+				return &permanentError{err}
+			}
+			return errRangeMismatch
+		}
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download may already be complete, otherwise start over.
+		if _, size, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && size == t.offset {
+			return nil
+		}
+		if err := t.restart(); err != nil {
+			return &permanentError{err}
+		}
+		return fmt.Errorf("server rejected resuming at byte %d", t.offset)
+	case http.StatusTooManyRequests, http.StatusRequestTimeout:
+		return fmt.Errorf("server returned %v", resp.Status)
+	default:
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("server returned %v", resp.Status)
+		}
+		return &permanentError{fmt.Errorf("server returned %v", resp.Status)}
+	}
+	if total > d.maxSize {
+		return &permanentError{fmt.Errorf("image size %d exceeds the limit of %d bytes", total, d.maxSize)}
+	}
+	if v := resp.Header.Get("ETag"); v != "" {
+		t.validator = v
+	} else if v := resp.Header.Get("Last-Modified"); v != "" {
+		t.validator = v
+	}
+
+	// Read at most one byte past the limit so that oversized images without a length are caught.
+	n, err := io.Copy(io.MultiWriter(t.file, t.hash), io.LimitReader(resp.Body, d.maxSize-t.offset+1))
+	t.offset += n
+	if t.offset > d.maxSize {
+		return &permanentError{fmt.Errorf("image exceeds the limit of %d bytes", d.maxSize)}
+	}
+	if err != nil {
+		return fmt.Errorf("download interrupted: %v", err)
+	}
+	if total >= 0 && t.offset < total {
+		return fmt.Errorf("download interrupted after %d of %d bytes", t.offset, total)
+	}
+	return nil
+}
+
+// parseContentRange parses a Content-Range header of the form "bytes start-end/size"
+// or "bytes */size" and returns the start and size. The size is -1 if unknown.
+func parseContentRange(s string) (int64, int64, error) {
+	rng, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	span, sizeStr, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	size := int64(-1)
+	if sizeStr != "*" {
+		v, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q: %v", s, err)
+		}
+		size = v
+	}
+	if span == "*" {
+		return 0, size, nil
+	}
+	startStr, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q: %v", s, err)
+	}
+	return start, size, nil
+}
+
+// hashFile hashes a local image without reading it into memory.
+func hashFile(name string, newHash func() hash.Hash) (*downloadedImage, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image: %v", err)
+	}
+	defer f.Close()
+	h := newHash()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image: %v", err)
+	}
+	return &downloadedImage{path: name, size: n, digest: h.Sum(nil)}, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// faultyServer serves an image and injects faults into the first requests.
+type faultyServer struct {
+	image []byte
+	mu    sync.Mutex
+	// faults are applied to requests in order; "" serves the request normally.
+	faults []string
+	// ranges records the Range header of each request.
+	ranges []string
+}
+
+func (s *faultyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	fault := ""
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mu.Unlock()
+
+	switch fault {
+	case "unavailable":
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	case "not found":
+		http.NotFound(w, r)
+	case "truncate":
+		// Promise the whole image but hang up half way through.
+		w.Header().Set("Content-Length", fmt.Sprint(len(s.image)))
+		w.Write(s.image[:len(s.image)/2])
+		panic(http.ErrAbortHandler)
+	case "ignore range":
+		w.Write(s.image)
+	default:
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(s.image))
+	}
+}
+
+func newTestDownloader(t *testing.T, client *http.Client) *imageDownloader {
+	t.Helper()
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &imageDownloader{
+		client:     client,
+		dir:        t.TempDir(),
+		maxSize:    1 << 20,
+		retries:    3,
+		backoff:    time.Millisecond,
+		maxBackoff: time.Millisecond,
+		mirrors:    map[string]string{},
+	}
+}
+
+func testImage() []byte {
+	return bytes.Repeat([]byte("bootz image "), 10000)
+}
+
+func TestDownload(t *testing.T) {
+	image := testImage()
+	want := sha256.Sum256(image)
+
+	tests := []struct {
+		desc       string
+		faults     []string
+		partial    []byte
+		maxSize    int64
+		wantRanges []string
+		wantErr    string
+	}{{
+		desc:       "Clean download",
+		wantRanges: []string{""},
+	}, {
+		desc:       "Retries unavailable server",
+		faults:     []string{"unavailable", "unavailable"},
+		wantRanges: []string{"", "", ""},
+	}, {
+		desc:       "Resumes interrupted download",
+		faults:     []string{"truncate"},
+		wantRanges: []string{"", fmt.Sprintf("bytes=%d-", len(image)/2)},
+	}, {
+		desc:       "Restarts when server ignores range",
+		faults:     []string{"truncate", "ignore range"},
+		wantRanges: []string{"", fmt.Sprintf("bytes=%d-", len(image)/2)},
+	}, {
+		desc:       "Resumes partial download from an earlier run",
+		partial:    image[:1000],
+		wantRanges: []string{"bytes=1000-"},
+	}, {
+		desc:       "Completes when partial download is whole",
+		partial:    image,
+		wantRanges: []string{fmt.Sprintf("bytes=%d-", len(image))},
+	}, {
+		desc:       "Not found is not retried",
+		faults:     []string{"not found"},
+		wantRanges: []string{""},
+		wantErr:    "404",
+	}, {
+		desc:    "Gives up after retries",
+		faults:  []string{"unavailable", "unavailable", "unavailable", "unavailable"},
+		wantErr: "503",
+	}, {
+		desc:    "Image too large",
+		maxSize: 100,
+		wantErr: "exceeds the limit",
+	}, {
+		desc:    "Image too large without length",
+		faults:  []string{"truncate", "ignore range"},
+		maxSize: int64(len(image)) - 1,
+		wantErr: "exceeds the limit",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s := &faultyServer{image: image, faults: test.faults}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			d := newTestDownloader(t, nil)
+			if test.maxSize != 0 {
+				d.maxSize = test.maxSize
+			}
+			url := ts.URL + "/os/image.bin"
+			if test.partial != nil {
+				if err := os.WriteFile(filepath.Join(d.dir, imageFileName(url)+".partial"), test.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := d.download(context.Background(), url, sha256.New)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("download() %s", s)
+			}
+			if test.wantRanges != nil && strings.Join(s.ranges, ",") != strings.Join(test.wantRanges, ",") {
+				t.Errorf("download() made requests with ranges %q, want %q", s.ranges, test.wantRanges)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(got.digest, want[:]) {
+				t.Errorf("download() digest = %x, want %x", got.digest, want)
+			}
+			onDisk, err := os.ReadFile(got.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(onDisk, image) || got.size != int64(len(image)) {
+				t.Errorf("download() wrote %d bytes which do not match the image", len(onDisk))
+			}
+		})
+	}
+}
+
+func TestDownloadTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(&faultyServer{image: testImage()})
+	defer ts.Close()
+
+	if _, err := newTestDownloader(t, nil).download(context.Background(), ts.URL+"/image", sha256.New); err == nil {
+		t.Errorf("download() from untrusted server err = nil, want error")
+	}
+
+	// Trust the server via a CA bundle as the image_ca_cert flag does.
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	client := ts.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	if _, err := newTestDownloader(t, client).download(context.Background(), ts.URL+"/image", sha256.New); err != nil {
+		t.Errorf("download() from trusted server err = %v, want nil", err)
+	}
+}
+
+func TestVerifyImage(t *testing.T) {
+	tests := []struct {
+		desc      string
+		algorithm string
+		hash      string
+		wantErr   string
+	}{{
+		desc:      "Matching hash",
+		algorithm: "SHA256",
+		hash:      "e9c0f8b575cbfcb42ab3b78ecc87efa3b011d9a5d10b09fa4e96f240bf6a82f5",
+	}, {
+		desc:      "Matching SHA-512 hash",
+		algorithm: "SHA-512",
+		hash:      "569350085b223ba854dfc5d607643ceb85e4607e46e5a9ad3696f898e29d8a3fe22610956167cefb7e2ba769e740f94b31e4e3c52195ba65e64ba40d82343591",
+	}, {
+		desc:      "Matching SHA3-256 hash",
+		algorithm: "SHA3-256",
+		hash:      "C60C806C8F5C55FCCDFA08EA2257BCBB4A31BC55918FC9D8F2A11D836099887C",
+	}, {
+		desc:      "Wrong hash",
+		algorithm: "SHA256",
+		hash:      hex.EncodeToString(make([]byte, sha256.Size)),
+		wantErr:   "unmatched hash",
+	}, {
+		desc:      "Invalid hash",
+		algorithm: "SHA256",
+		hash:      "not hex",
+		wantErr:   "can not decode",
+	}, {
+		desc:      "Hash of another algorithm",
+		algorithm: "SHA384",
+		hash:      "e9c0f8b575cbfcb42ab3b78ecc87efa3b011d9a5d10b09fa4e96f240bf6a82f5",
+		wantErr:   "must be 48 bytes",
+	}, {
+		desc:      "Unsupported algorithm",
+		algorithm: "MD5",
+		hash:      "d41d8cd98f00b204e9800998ecf8427e",
+		wantErr:   "unsupported hash algorithm",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := VerifyImage("../../testdata/image.txt", &bpb.SoftwareImage{OsImageHash: test.hash, HashAlgorithm: test.algorithm})
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("VerifyImage() %s", s)
+			}
+			var verr *VerificationError
+			if err != nil && (!errors.As(err, &verr) || verr.Reason != ReasonImageHash) {
+				t.Errorf("VerifyImage() err = %v, want a %v VerificationError", err, ReasonImageHash)
+			}
+		})
+	}
+}
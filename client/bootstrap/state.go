@@ -0,0 +1,176 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/openconfig/bootz/common/hashalg"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// Name of the file the file state store writes the state to.
+const stateFile = "state.json"
+
+// ErrRebootRequired is returned by Bootstrap when the device must reboot before the
+// bootstrap can carry on, after a new image is installed or new configuration is applied.
+// The state is saved first, so running Bootstrap again after the reboot resumes from there.
+// Reboot points are only taken by clients with a state store.
+var ErrRebootRequired = errors.New("reboot required")
+
+// ImageState identifies the image running on a control card.
+type ImageState struct {
+	Name          string `json:"name,omitempty"`
+	Version       string `json:"version,omitempty"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Hash          string `json:"hash"`
+}
+
+// matches reports whether the image is the intended image. The hash algorithms
+// are compared by their canonical names, as they may be spelled differently.
+func (s *ImageState) matches(image *bpb.SoftwareImage) bool {
+	if s == nil || s.Hash != image.GetOsImageHash() {
+		return false
+	}
+	saved, err := hashalg.Lookup(s.HashAlgorithm)
+	if err != nil {
+		return false
+	}
+	intended, err := hashalg.Lookup(image.GetHashAlgorithm())
+	return err == nil && saved.Name == intended.Name
+}
+
+// CardState is what a control card remembers across reboots.
+type CardState struct {
+	// Status is the name of the control card status, e.g. CONTROL_CARD_STATUS_INITIALIZED.
+	Status string `json:"status,omitempty"`
+	// Image is the installed image, if any.
+	Image *ImageState `json:"image,omitempty"`
+	// ConfigHashes are the hex encoded SHA-256 hashes of the applied configuration, keyed by artifact.
+	ConfigHashes map[string]string `json:"config_hashes,omitempty"`
+	// ServerTrustCert is the server certificate pinned by the last verified bootstrap data.
+	ServerTrustCert string `json:"server_trust_cert,omitempty"`
+}
+
+// State is what a device remembers across reboots.
+type State struct {
+	// Cards holds the state of each control card, keyed by serial number.
+	Cards map[string]*CardState `json:"cards"`
+}
+
+// card returns the state of the control card, adding it if needed.
+func (s *State) card(serial string) *CardState {
+	if s.Cards == nil {
+		s.Cards = map[string]*CardState{}
+	}
+	c, ok := s.Cards[serial]
+	if !ok {
+		c = &CardState{}
+		s.Cards[serial] = c
+	}
+	if c.ConfigHashes == nil {
+		c.ConfigHashes = map[string]string{}
+	}
+	return c
+}
+
+// StateStore keeps the device state across reboots.
+type StateStore interface {
+	// Load returns the saved state, or an empty state if none was saved.
+	Load() (*State, error)
+	// Save replaces the saved state.
+	Save(*State) error
+}
+
+// fileStateStore saves the state as JSON in a file.
+type fileStateStore struct {
+	path string
+}
+
+// NewFileStateStore returns a state store keeping the state in a file in dir,
+// which is created if needed.
+func NewFileStateStore(dir string) (StateStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create state directory: %v", err)
+	}
+	return &fileStateStore{path: filepath.Join(dir, stateFile)}, nil
+}
+
+func (s *fileStateStore) Load() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Cards: map[string]*CardState{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read device state: %v", err)
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("unable to parse device state %v: %v", s.path, err)
+	}
+	if state.Cards == nil {
+		state.Cards = map[string]*CardState{}
+	}
+	return state, nil
+}
+
+// Save writes the state to a temporary file first, so a crash never leaves a partial state behind.
+func (s *fileStateStore) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("unable to save device state: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("unable to save device state: %v", err)
+	}
+	return nil
+}
+
+// restoreState sets the control card statuses saved in the state on the chassis.
+func restoreState(chassis *Chassis, state *State) error {
+	for serial, card := range state.Cards {
+		if card.Status == "" {
+			continue
+		}
+		status, ok := bpb.ControlCardState_ControlCardStatus_value[card.Status]
+		if !ok {
+			return fmt.Errorf("unknown control card status %q saved for control card %v", card.Status, serial)
+		}
+		if err := chassis.SetState(serial, bpb.ControlCardState_ControlCardStatus(status)); err != nil {
+			return fmt.Errorf("saved device state: %v", err)
+		}
+	}
+	return nil
+}
+
+// saveState records the control card statuses and saves the state, if the client has a store.
+func (c *Client) saveState() error {
+	if c.store == nil {
+		return nil
+	}
+	for _, serial := range c.chassis.CardSerials() {
+		c.state.card(serial).Status = c.chassis.States[serial].String()
+	}
+	return c.store.Save(c.state)
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"testing"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+func TestImageStateMatches(t *testing.T) {
+	image := &bpb.SoftwareImage{HashAlgorithm: "sha-256", OsImageHash: "abcd"}
+	tests := []struct {
+		desc  string
+		state *ImageState
+		want  bool
+	}{{
+		desc:  "Same algorithm spelled differently",
+		state: &ImageState{HashAlgorithm: "SHA256", Hash: "abcd"},
+		want:  true,
+	}, {
+		desc:  "Other algorithm",
+		state: &ImageState{HashAlgorithm: "SHA512", Hash: "abcd"},
+	}, {
+		desc:  "Unknown algorithm",
+		state: &ImageState{HashAlgorithm: "MD5", Hash: "abcd"},
+	}, {
+		desc:  "Other hash",
+		state: &ImageState{HashAlgorithm: "SHA256", Hash: "ef01"},
+	}, {
+		desc: "No image",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.state.matches(image); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/bootz/common/hashalg"
+	ownershipvoucher "github.com/openconfig/bootz/common/ownership_voucher"
+	"github.com/openconfig/bootz/common/revocation"
+	"go.mozilla.org/pkcs7"
+	"google.golang.org/protobuf/proto"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// Reason identifies the check that bootstrap data failed.
+type Reason int
+
+const (
+	// ReasonOwnershipVoucher means the ownership voucher is malformed or not signed by the vendor CA.
+	ReasonOwnershipVoucher Reason = iota + 1
+	// ReasonSerialMismatch means the ownership voucher is for another device.
+	ReasonSerialMismatch
+	// ReasonNonceMismatch means the ownership voucher or the signed response is not bound to the request's nonce.
+	ReasonNonceMismatch
+	// ReasonOwnershipCertificate means the ownership certificate is missing, malformed or not issued by the pinned domain cert.
+	ReasonOwnershipCertificate
+	// ReasonRevocation means a certificate in the ownership certificate chain is revoked or its status is unknown.
+	ReasonRevocation
+	// ReasonUnsigned means the response carries no signature.
+	ReasonUnsigned
+	// ReasonSignature means a response signature does not verify against the ownership certificate.
+	ReasonSignature
+	// ReasonImageHash means a downloaded image does not match the hash of the intended image.
+	ReasonImageHash
+	// ReasonServerIdentity means the TLS certificate of the server neither chains to the
+	// pinned domain cert nor is the ownership certificate.
+	ReasonServerIdentity
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonOwnershipVoucher:
+		return "invalid ownership voucher"
+	case ReasonSerialMismatch:
+		return "serial number mismatch"
+	case ReasonNonceMismatch:
+		return "nonce mismatch"
+	case ReasonOwnershipCertificate:
+		return "invalid ownership certificate"
+	case ReasonRevocation:
+		return "revocation check failed"
+	case ReasonUnsigned:
+		return "unsigned response"
+	case ReasonSignature:
+		return "invalid response signature"
+	case ReasonImageHash:
+		return "invalid image hash"
+	case ReasonServerIdentity:
+		return "server identity mismatch"
+	}
+	return fmt.Sprintf("Reason(%d)", int(r))
+}
+
+// VerificationError is returned when bootstrap data or an image fails verification.
+// Use errors.As to find out which check failed.
+type VerificationError struct {
+	Reason Reason
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Reason, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func verificationErrorf(r Reason, format string, args ...any) error {
+	return &VerificationError{Reason: r, Err: fmt.Errorf(format, args...)}
+}
+
+// pemEncodeCert adds the correct PEM headers and footers to a raw certificate block.
+func pemEncodeCert(contents string) string {
+	return strings.Join([]string{"-----BEGIN CERTIFICATE-----", contents, "-----END CERTIFICATE-----"}, "\n")
+}
+
+// Verify checks the signed artifacts in a GetBootstrapDataResponse to the request
+// made with nonce. Specifically, it:
+// - Checks that the OV in the response is signed by the manufacturer.
+// - Checks that the serial number in the OV matches the one in the original request.
+// - Checks that the nonce in the OV, if any, matches the one in the original request.
+// - Verifies that the Ownership Certificate is in the chain of signers of the Pinned Domain Cert.
+// - Checks that no certificate in that chain is revoked, if the OV requests revocation checks.
+// - Checks that the server's TLS certificate chains to the Pinned Domain Cert or is the OC, if enabled.
+// - Checks that the server's TLS certificate is or chains to the pinned server certificate, if any.
+// - Verifies the response signatures, PKCS#1 and/or CMS, with the Ownership Certificate.
+// - Checks that the signed response carries the nonce.
+// On success, resp.SignedResponse holds the data the signatures cover.
+// Failed checks are returned as a *VerificationError.
+func (c *Client) Verify(resp *bpb.GetBootstrapDataResponse, nonce string) error {
+	if c.vendorCAs == nil {
+		return fmt.Errorf("no vendor CA configured to verify the ownership voucher")
+	}
+	serialNumber := c.chassis.ActiveSerial()
+	var ovOpts []ownershipvoucher.Option
+	if c.legacyOV {
+		ovOpts = append(ovOpts, ownershipvoucher.WithLegacyParsing())
+	}
+	parsedOV, err := ownershipvoucher.VerifyAndUnmarshal(resp.GetOwnershipVoucher(), c.vendorCAs, ovOpts...)
+	if err != nil {
+		return &VerificationError{Reason: ReasonOwnershipVoucher, Err: err}
+	}
+	log.Infof("=============================================================================")
+	log.Infof("Validated ownership voucher signed by vendor")
+	log.Infof("=============================================================================")
+
+	oc := resp.GetOwnershipCertificate()
+	if len(oc) == 0 {
+		return verificationErrorf(ReasonOwnershipCertificate, "received empty ownership certificate from server")
+	}
+
+	// Verify the serial number for this OV
+	log.Infof("Verifying the serial number for this OV")
+	if parsedOV.OV.SerialNumber != serialNumber {
+		return verificationErrorf(ReasonSerialMismatch, "serial number from OV does not match request")
+	}
+	log.Infof("Verified serial number is %v", serialNumber)
+
+	if len(parsedOV.OV.Nonce) > 0 || c.requireOVNonce {
+		log.Infof("Verifying the nonce for this OV")
+		if !bytes.Equal(parsedOV.OV.Nonce, []byte(nonce)) {
+			return verificationErrorf(ReasonNonceMismatch, "nonce from OV does not match request")
+		}
+		log.Infof("Verified OV nonce")
+	}
+
+	log.Infof("Adding PEM headers and footers to OV")
+	pdCPEM := pemEncodeCert(parsedOV.OV.PinnedDomainCert)
+
+	// Create a new pool with this PDC.
+	log.Infof("Creating a new pool with the PDC")
+	pdcPool := x509.NewCertPool()
+	if !pdcPool.AppendCertsFromPEM([]byte(pdCPEM)) {
+		return verificationErrorf(ReasonOwnershipVoucher, "unable to parse pinned domain cert")
+	}
+
+	// Parse the Ownership Certificate.
+	log.Infof("Parsing the OC")
+	ocCert, err := certFromPemBlock(oc)
+	if err != nil {
+		return verificationErrorf(ReasonOwnershipCertificate, "failed to parse certificate: %v", err)
+	}
+
+	// Verify that the OC is signed by the PDC. Any further certificates bundled with the OC are intermediates.
+	log.Infof("Verifying that the OC is signed by the PDC")
+	intermediates := x509.NewCertPool()
+	_, rest := pem.Decode(oc)
+	intermediates.AppendCertsFromPEM(rest)
+	opts := x509.VerifyOptions{
+		Roots:         pdcPool,
+		Intermediates: intermediates,
+	}
+	chains, err := ocCert.Verify(opts)
+	if err != nil {
+		return &VerificationError{Reason: ReasonOwnershipCertificate, Err: err}
+	}
+	log.Infof("Validated ownership certificate with OV PDC")
+
+	if parsedOV.OV.DomainCertRevocationChecks {
+		log.Infof("Checking the ownership certificate chain for revocation")
+		stapled := &revocation.Info{
+			CRLs:          resp.GetOwnershipCertificateCrls(),
+			OCSPResponses: resp.GetOwnershipCertificateOcspResponses(),
+		}
+		if err := revocation.Check(chains[0], stapled, revocation.WithFetching(c.fetchRevocation)); err != nil {
+			return verificationErrorf(ReasonRevocation, "ownership certificate chain failed revocation checks: %v", err)
+		}
+		log.Infof("Verified ownership certificate chain is not revoked")
+	}
+
+	if c.verifyServerIdentity {
+		log.Infof("Verifying the TLS certificate of the server against the PDC and OC")
+		if err := verifyServerIdentity(c.serverCerts, pdcPool, ocCert); err != nil {
+			return &VerificationError{Reason: ReasonServerIdentity, Err: err}
+		}
+		log.Infof("Verified the identity of the server")
+	}
+
+	if err := c.checkPinnedServerCert(serialNumber); err != nil {
+		return &VerificationError{Reason: ReasonServerIdentity, Err: err}
+	}
+
+	// Validate the response signature.
+	log.Infof("=============================================================================")
+	log.Infof("===================== Validating the response signature =====================")
+	log.Infof("=============================================================================")
+	signedResponseBytes, err := signedResponseBytes(resp)
+	if err != nil {
+		return &VerificationError{Reason: ReasonSignature, Err: err}
+	}
+
+	if len(resp.GetResponseSignatureCms()) == 0 && resp.GetResponseSignature() == "" {
+		return verificationErrorf(ReasonUnsigned, "response is not signed")
+	}
+	if len(resp.GetResponseSignatureCms()) > 0 {
+		log.Infof("Verifying the CMS response signature...")
+		if err := verifyCMSSignature(resp.GetResponseSignatureCms(), signedResponseBytes, ocCert, pdcPool); err != nil {
+			return verificationErrorf(ReasonSignature, "CMS signature not verified: %v", err)
+		}
+		log.Infof("Verified CMS response signature")
+	}
+	if resp.GetResponseSignature() != "" {
+		if err := verifyPKCS1Signature(resp.GetResponseSignature(), signedResponseBytes, ocCert); err != nil {
+			return &VerificationError{Reason: ReasonSignature, Err: err}
+		}
+		log.Infof("Verified SignedResponse signature")
+	}
+
+	if resp.GetSignedResponse().GetNonce() != nonce {
+		return verificationErrorf(ReasonNonceMismatch, "GetBootstrapDataResponse nonce does not match")
+	}
+	return nil
+}
+
+// checkPinnedServerCert checks the TLS certificate chain the server presented against
+// the server certificate pinned for the control card by an earlier verified response.
+// A pinned value which is not a certificate is skipped, as some servers send placeholders.
+func (c *Client) checkPinnedServerCert(serial string) error {
+	card, ok := c.state.Cards[serial]
+	if !ok || card.ServerTrustCert == "" {
+		return nil
+	}
+	pinned, err := parseServerTrustCert(card.ServerTrustCert)
+	if err != nil {
+		log.Warningf("Not checking the server against the pinned server certificate of %v: %v", serial, err)
+		return nil
+	}
+	log.Infof("Verifying the TLS certificate of the server against the pinned server certificate")
+	pool := x509.NewCertPool()
+	pool.AddCert(pinned)
+	if err := verifyServerIdentity(c.serverCerts, pool, pinned); err != nil {
+		return fmt.Errorf("server does not match the pinned server certificate: %v", err)
+	}
+	log.Infof("Verified the server against the pinned server certificate")
+	return nil
+}
+
+// parseServerTrustCert parses a server trust cert, either PEM encoded or the base64
+// encoded DER certificate alone.
+func parseServerTrustCert(cert string) (*x509.Certificate, error) {
+	if !strings.HasPrefix(strings.TrimSpace(cert), "-----BEGIN") {
+		cert = pemEncodeCert(cert)
+	}
+	return certFromPemBlock([]byte(cert))
+}
+
+// verifyServerIdentity checks that the TLS certificate chain the server presented is
+// either the ownership certificate or chains to the pinned domain cert.
+func verifyServerIdentity(certs []*x509.Certificate, pdcPool *x509.CertPool, ocCert *x509.Certificate) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("the server presented no TLS certificate")
+	}
+	leaf := certs[0]
+	if leaf.Equal(ocCert) {
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pdcPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("TLS certificate %q neither chains to the pinned domain cert nor is the ownership certificate: %v", leaf.Subject, err)
+	}
+	return nil
+}
+
+// verifyPKCS1Signature checks the base64 encoded PKCS#1 v1.5 signature over content with
+// the ownership certificate's public key. Currently only RSA keys are supported.
+func verifyPKCS1Signature(signature string, content []byte, ocCert *x509.Certificate) error {
+	log.Infof("Calculating the sha256 sum to validate the response signature...")
+	hashed := sha256.Sum256(content)
+	log.Infof("Decoding the response...")
+	decodedSig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	log.Infof("Decoded the response string")
+
+	log.Infof("Using the ownership certificate's public key to verify the signature... Note only RSA keys are supported")
+	switch pub := ocCert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], decodedSig); err != nil {
+			return fmt.Errorf("signature not verified: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", pub)
+	}
+	return nil
+}
+
+// signedResponseBytes returns the bytes the response signatures were computed over.
+// If the server sent them, the signed response in resp is replaced with the message
+// decoded from those bytes, so that only signed data is used afterwards. Otherwise the
+// signed response is re-serialized, which older servers rely on.
+func signedResponseBytes(resp *bpb.GetBootstrapDataResponse) ([]byte, error) {
+	serialized := resp.GetSerializedSignedResponse()
+	if len(serialized) == 0 {
+		log.Infof("Marshalling the response...")
+		return proto.Marshal(resp.GetSignedResponse())
+	}
+	log.Infof("Decoding the serialized signed response...")
+	signed := &bpb.BootstrapDataSigned{}
+	if err := proto.Unmarshal(serialized, signed); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal serialized signed response: %v", err)
+	}
+	if resp.GetSignedResponse() != nil && !proto.Equal(signed, resp.GetSignedResponse()) {
+		return nil, fmt.Errorf("signed response does not match the serialized signed response")
+	}
+	resp.SignedResponse = signed
+	return serialized, nil
+}
+
+// verifyCMSSignature checks that cms is a CMS SignedData structure over content,
+// signed by the ownership certificate, which chains up to the PDC.
+func verifyCMSSignature(cms, content []byte, ocCert *x509.Certificate, pdcPool *x509.CertPool) error {
+	p7, err := pkcs7.Parse(cms)
+	if err != nil {
+		return fmt.Errorf("unable to parse CMS signature: %v", err)
+	}
+	if len(p7.Content) > 0 && !bytes.Equal(p7.Content, content) {
+		return fmt.Errorf("CMS content does not match the signed response")
+	}
+	p7.Content = content
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return fmt.Errorf("CMS signature must have exactly one signer")
+	}
+	if !signer.Equal(ocCert) {
+		return fmt.Errorf("CMS signer is not the ownership certificate")
+	}
+	return p7.VerifyWithChain(pdcPool)
+}
+
+// VerifyImage checks that the image file at path matches the hash of the intended image.
+// A mismatch is returned as a *VerificationError.
+func VerifyImage(path string, image *bpb.SoftwareImage) error {
+	alg, err := hashalg.Lookup(image.GetHashAlgorithm())
+	if err != nil {
+		return &VerificationError{Reason: ReasonImageHash, Err: err}
+	}
+	f, err := hashFile(path, alg.New)
+	if err != nil {
+		return err
+	}
+	return verifyDigest(f.digest, image)
+}
+
+// verifyDigest checks that the digest of a downloaded image matches the hash of the intended image.
+func verifyDigest(digest []byte, image *bpb.SoftwareImage) error {
+	log.Info("Start to validate the downloaded image")
+	alg, err := hashalg.Lookup(image.GetHashAlgorithm())
+	if err != nil {
+		return &VerificationError{Reason: ReasonImageHash, Err: err}
+	}
+	receivedHashed, err := alg.DecodeDigest(image.GetOsImageHash())
+	if err != nil {
+		return verificationErrorf(ReasonImageHash, "can not decode received hashed image to bytes: %v", err)
+	}
+	if !bytes.Equal(digest, receivedHashed) {
+		return verificationErrorf(ReasonImageHash, "unmatched hash, received hex string: %v, downloaded hex string: %v", image.GetOsImageHash(), hex.EncodeToString(digest))
+	}
+	log.Info("Verified image hash")
+	return nil
+}
+
+func certFromPemBlock(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
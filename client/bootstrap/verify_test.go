@@ -0,0 +1,271 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/openconfig/bootz/server/entitymanager"
+	"github.com/openconfig/bootz/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+const testNonce = "test-nonce"
+
+// signedTestResponse returns a response for control card 123A signed by the test inventory.
+func signedTestResponse(t *testing.T) *bpb.GetBootstrapDataResponse {
+	t.Helper()
+	em, err := entitymanager.New("../../testdata/inventory.prototxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &bpb.GetBootstrapDataResponse{
+		SignedResponse: &bpb.BootstrapDataSigned{
+			Responses: []*bpb.BootstrapDataResponse{{SerialNum: "123A", ServerTrustCert: "FakeTLSCert"}},
+			Nonce:     testNonce,
+		},
+	}
+	if err := em.Sign(resp, &service.EntityLookup{Manufacturer: "Cisco", SerialNumber: "123"}, "123A"); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// certPool returns a pool of the PEM encoded certificates in the test data file.
+func certPool(t *testing.T, name string) *x509.CertPool {
+	t.Helper()
+	data, err := os.ReadFile("../../testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatalf("no certificates in %v", name)
+	}
+	return pool
+}
+
+// readCert returns the PEM encoded certificate in the test data file.
+func readCert(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile("../../testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := certFromPemBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerify(t *testing.T) {
+	vendorCA, err := os.ReadFile("../../testdata/vendorca_pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc string
+		// modify changes the signed response before it is verified.
+		modify       func(*bpb.GetBootstrapDataResponse)
+		activeSerial string
+		nonce        string
+		opts         []Option
+		// serverCert is the test data file with the TLS certificate the server presented.
+		serverCert string
+		// pinnedCert is the test data file with the server certificate pinned by an earlier
+		// response, and pinned the pinned value if it is not a file.
+		pinnedCert string
+		pinned     string
+		wantReason Reason
+	}{{
+		desc: "Valid response",
+	}, {
+		desc:       "Voucher not signed by the vendor",
+		opts:       []Option{WithVendorCAs(certPool(t, "pdc_pub.pem"))},
+		wantReason: ReasonOwnershipVoucher,
+	}, {
+		desc:         "Voucher for another control card",
+		activeSerial: "123B",
+		wantReason:   ReasonSerialMismatch,
+	}, {
+		desc:       "Voucher without the required nonce",
+		opts:       []Option{WithRequireOVNonce()},
+		wantReason: ReasonNonceMismatch,
+	}, {
+		desc: "Ownership certificate not issued by the PDC",
+		modify: func(resp *bpb.GetBootstrapDataResponse) {
+			resp.OwnershipCertificate = vendorCA
+		},
+		wantReason: ReasonOwnershipCertificate,
+	}, {
+		desc: "Unsigned response",
+		modify: func(resp *bpb.GetBootstrapDataResponse) {
+			resp.ResponseSignature = ""
+			resp.ResponseSignatureCms = nil
+		},
+		wantReason: ReasonUnsigned,
+	}, {
+		desc: "Tampered response",
+		modify: func(resp *bpb.GetBootstrapDataResponse) {
+			resp.SerializedSignedResponse = append(resp.SerializedSignedResponse, 0x0a, 0x00)
+			resp.SignedResponse = nil
+		},
+		wantReason: ReasonSignature,
+	}, {
+		desc:       "Response for another request",
+		nonce:      "other-nonce",
+		wantReason: ReasonNonceMismatch,
+	}, {
+		desc:       "Server presenting the PDC",
+		opts:       []Option{WithServerIdentityVerification()},
+		serverCert: "pdc_pub.pem",
+	}, {
+		desc:       "Server presenting the OC",
+		opts:       []Option{WithServerIdentityVerification()},
+		serverCert: "oc_pub.pem",
+	}, {
+		desc:       "Server presenting a certificate not issued by the PDC",
+		opts:       []Option{WithServerIdentityVerification()},
+		serverCert: "vendorca_pub.pem",
+		wantReason: ReasonServerIdentity,
+	}, {
+		desc:       "Server presenting no certificate",
+		opts:       []Option{WithServerIdentityVerification()},
+		wantReason: ReasonServerIdentity,
+	}, {
+		desc:       "Server identity not verified",
+		serverCert: "vendorca_pub.pem",
+	}, {
+		desc:       "Server presenting the pinned certificate",
+		serverCert: "oc_pub.pem",
+		pinnedCert: "oc_pub.pem",
+	}, {
+		desc:       "Server presenting a certificate issued by the pinned certificate",
+		serverCert: "oc_pub.pem",
+		pinnedCert: "pdc_pub.pem",
+	}, {
+		desc:       "Server not presenting the pinned certificate",
+		serverCert: "vendorca_pub.pem",
+		pinnedCert: "oc_pub.pem",
+		wantReason: ReasonServerIdentity,
+	}, {
+		desc:       "Pinned certificate without PEM headers",
+		serverCert: "vendorca_pub.pem",
+		pinned:     base64.StdEncoding.EncodeToString(readCert(t, "oc_pub.pem").Raw),
+		wantReason: ReasonServerIdentity,
+	}, {
+		desc:       "Pinned placeholder",
+		serverCert: "vendorca_pub.pem",
+		pinned:     "FakeTLSCert",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := signedTestResponse(t)
+			if test.modify != nil {
+				test.modify(resp)
+			}
+			chassis, err := NewChassis(defaultTestChassis(), test.activeSerial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			device, err := NewFSDevice(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := append([]Option{WithVendorCAs(certPool(t, "vendorca_pub.pem")), WithImageDir(t.TempDir())}, test.opts...)
+			c, err := New(chassis, device, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.serverCert != "" {
+				c.serverCerts = []*x509.Certificate{readCert(t, test.serverCert)}
+			}
+			pinned := test.pinned
+			if test.pinnedCert != "" {
+				data, err := os.ReadFile("../../testdata/" + test.pinnedCert)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pinned = string(data)
+			}
+			c.state.card("123A").ServerTrustCert = pinned
+			nonce := test.nonce
+			if nonce == "" {
+				nonce = testNonce
+			}
+
+			err = c.Verify(resp, nonce)
+			if test.wantReason == 0 {
+				if err != nil {
+					t.Errorf("Verify() err = %v, want nil", err)
+				}
+				return
+			}
+			var verr *VerificationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Verify() err = %v, want a VerificationError", err)
+			}
+			if verr.Reason != test.wantReason {
+				t.Errorf("Verify() reason = %v, want %v (err: %v)", verr.Reason, test.wantReason, err)
+			}
+		})
+	}
+}
+
+func TestFetchRecordsServerCertificate(t *testing.T) {
+	serverCert, err := tls.LoadX509KeyPair("../../testdata/pdc_pub.pem", "../../testdata/pdc_priv.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&serverCert)))
+	bpb.RegisterBootstrapServer(srv, &fakeBootzServer{imageHash: testImageHash})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	chassis, err := NewChassis(defaultTestChassis(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(chassis, nil, WithVendorCAs(certPool(t, "vendorca_pub.pem")), WithServerIdentityVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(c.creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := c.Fetch(context.Background(), bpb.NewBootstrapClient(conn), testNonce); err != nil {
+		t.Fatalf("Fetch() err = %v, want nil", err)
+	}
+	if want := readCert(t, "pdc_pub.pem"); len(c.serverCerts) == 0 || !c.serverCerts[0].Equal(want) {
+		t.Errorf("Fetch() recorded server certificates %v, want the PDC", c.serverCerts)
+	}
+}
@@ -13,378 +13,241 @@
 // limitations under the License.
 
 // Bootz client reference implementation.
+//
+// The emulator is a thin wrapper around the bootstrap package, which device
+// implementers can import to run the Bootz process on their own devices.
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/base64"
-	"encoding/hex"
-	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	log "github.com/golang/glog"
-	ownershipvoucher "github.com/openconfig/bootz/common/ownership_voucher"
-
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/protobuf/proto"
 
-	bpb "github.com/openconfig/bootz/proto/bootz"
+	"github.com/openconfig/bootz/client/bootstrap"
 )
 
-// Represents a 128 bit nonce.
-const nonceLength = 16
-
 var (
-	verifyTLSCert = flag.Bool("verify_tls_cert", false, "Whether to verify the TLS certificate presented by the Bootz server. If false, all TLS connections are implicitly trusted.")
-	insecureBoot  = flag.Bool("insecure_boot", false, "Whether to start the emulated device in non-secure mode. This informs Bootz server to not provide ownership certificates or vouchers.")
-	port          = flag.String("port", "", "The port to listen to on localhost for the bootz server.")
-	rootCA        = flag.String("root_ca_cert_path", "../testdata/vendorca_pub.pem", "The relative path to a file containing a PEM encoded certificate for the manufacturer CA.")
-	urlImageMap   = map[string]string{
-		"https://path/to/image": "../testdata/image.txt",
-	}
+	verifyTLSCert   = flag.Bool("verify_tls_cert", false, "Whether to verify the TLS certificate presented by the Bootz server. If false, all TLS connections are implicitly trusted.")
+	insecureBoot    = flag.Bool("insecure_boot", false, "Whether to start the emulated device in non-secure mode. This informs Bootz server to not provide ownership certificates or vouchers.")
+	port            = flag.String("port", "", "The port to listen to on localhost for the bootz server. Used when dhcp_interface is not set.")
+	rootCA          = flag.String("root_ca_cert_path", "../testdata/vendorca_pub.pem", "The relative path to a file containing a PEM encoded certificate for the manufacturer CA.")
+	legacyOV        = flag.Bool("legacy_ov_parsing", false, "Whether to accept ownership vouchers issued in the pre-RFC 3339 timestamp format.")
+	requireOVNonce  = flag.Bool("require_ov_nonce", false, "Whether to require the ownership voucher to be bound to the nonce sent in the request. Vouchers that carry a nonce are always checked.")
+	verifyServerID  = flag.Bool("verify_server_identity", false, "Whether to check, once the ownership voucher and certificate are verified, that the TLS certificate of the Bootz server chains to the voucher's pinned domain cert or is the ownership certificate. The bootstrap data is not used otherwise. Requires secure mode.")
+	fetchRevocation = flag.Bool("fetch_revocation_info", true, "Whether to fetch CRLs and OCSP responses for the ownership certificate chain when the server did not include them. Only used when the ownership voucher requests revocation checks.")
+	deviceDir       = flag.String("device_dir", "", "Directory the emulated device writes the bootstrap data it applies to, one subdirectory per control card. Defaults to the device subdirectory of state_dir, or a new temporary directory.")
+	stateDir        = flag.String("state_dir", "", "Directory the emulated device keeps its state in across reboots: the control card states, installed images, applied config hashes and pinned server certificates. If empty, no state is kept and the device does not reboot.")
+	exitOnReboot    = flag.Bool("exit_on_reboot", false, "Whether to exit at each reboot point instead of simulating the reboot, so that the next run resumes from the saved state. Requires state_dir.")
+
+	dhcpInterface = flag.String("dhcp_interface", "", "Network interface to discover the Bootz server on with DHCP. If empty, the client connects to the Bootz server on localhost at the given port.")
+	dhcpIPv4      = flag.Bool("dhcp_ipv4", true, "Whether to send a DHCPv4 request during discovery.")
+	dhcpIPv6      = flag.Bool("dhcp_ipv6", true, "Whether to send a DHCPv6 request during discovery.")
+	dhcpTimeout   = flag.Duration("dhcp_timeout", 5*time.Second, "How long to wait for each DHCP reply before retransmitting.")
+
+	bootstrapAttempts   = flag.Int("bootstrap_attempts", bootstrap.DefaultRetryPolicy.MaxAttempts, "Maximum number of times to run the bootstrap process before giving up. 0 retries forever.")
+	bootstrapBackoff    = flag.Duration("bootstrap_backoff", bootstrap.DefaultRetryPolicy.Backoff, "Delay before the bootstrap process is restarted after the first failed attempt. The delay doubles after each further failure.")
+	bootstrapMaxBackoff = flag.Duration("bootstrap_max_backoff", bootstrap.DefaultRetryPolicy.MaxBackoff, "Maximum delay between bootstrap attempts, or 0 for no limit.")
+	bootstrapJitter     = flag.Float64("bootstrap_jitter", bootstrap.DefaultRetryPolicy.Jitter, "Fraction by which each delay between bootstrap attempts is randomly lengthened or shortened, so that devices do not retry in lockstep.")
+
+	imageDir     = flag.String("image_dir", "", "Directory to download images to. Interrupted downloads are resumed from here. Defaults to a new temporary directory.")
+	imageCACert  = flag.String("image_ca_cert", "", "Path to a PEM encoded CA bundle used to verify HTTPS image servers. Defaults to the system trust store.")
+	imageMaxSize = flag.Int64("image_max_size", 8<<30, "Maximum size in bytes of a downloaded image.")
+	imageRetries = flag.Int("image_download_retries", 5, "How many times to retry a failed image download before giving up.")
+	imageMirrors = flag.String("image_mirrors", "https://path/to/image=../testdata/image.txt", "Comma separated list of url=path pairs. Images with these URLs are read from the local path instead of being downloaded.")
 )
 
-// pemEncodeCert adds the correct PEM headers and footers to a raw certificate block.
-func pemEncodeCert(contents string) string {
-	return strings.Join([]string{"-----BEGIN CERTIFICATE-----", contents, "-----END CERTIFICATE-----"}, "\n")
-}
-
-// validateArtifacts checks the signed artifacts in a GetBootstrapDataResponse. Specifically, it:
-// - Checks that the OV in the response is signed by the manufacturer.
-// - Checks that the serial number in the OV matches the one in the original request.
-// - Verifies that the Ownership Certificate is in the chain of signers of the Pinned Domain Cert.
-func validateArtifacts(serialNumber string, resp *bpb.GetBootstrapDataResponse, rootCA []byte) error {
-	// Create a CA pool for the device to validate that the vendor has signed this OV.
-	log.Infof("Creating a CA pool for the device to validate the vendor has signed this OV")
-	vendorCAPool := x509.NewCertPool()
-	if !vendorCAPool.AppendCertsFromPEM(rootCA) {
-		return fmt.Errorf("unable to add vendor root CA to pool")
-	}
-
-	parsedOV, err := ownershipvoucher.VerifyAndUnmarshal(resp.GetOwnershipVoucher(), vendorCAPool)
-	log.Infof("=============================================================================")
-	log.Infof("Validated ownership voucher signed by vendor")
-	log.Infof("=============================================================================")
-
-	oc := resp.GetOwnershipCertificate()
-	if len(oc) == 0 {
-		return fmt.Errorf("received empty ownership certificate from server")
-	}
-
-	// Verify the serial number for this OV
-	log.Infof("Verifying the serial number for this OV")
-	if parsedOV.OV.SerialNumber != serialNumber {
-		return fmt.Errorf("serial number from OV does not match request")
-	}
-	log.Infof("Verified serial number is %v", serialNumber)
-
-	log.Infof("Adding PEM headers and footers to OV")
-	pdCPEM := pemEncodeCert(parsedOV.OV.PinnedDomainCert)
-
-	// Create a new pool with this PDC.
-	log.Infof("Creating a new pool with the PDC")
-	pdcPool := x509.NewCertPool()
-	if !pdcPool.AppendCertsFromPEM([]byte(pdCPEM)) {
-		return err
-	}
-
-	// Parse the Ownership Certificate.
-	log.Infof("Parsing the OC")
-	ocCert, err := certFromPemBlock(oc)
+// readCertPool reads a pool of PEM encoded certificates from a file.
+func readCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to parse certificate: %v", err)
-	}
-
-	// Verify that the OC is signed by the PDC.
-	log.Infof("Verifying that the OC is signed by the PDC")
-	opts := x509.VerifyOptions{
-		Roots:         pdcPool,
-		Intermediates: x509.NewCertPool(),
-	}
-	if _, err := ocCert.Verify(opts); err != nil {
-		return err
+		return nil, err
 	}
-	log.Infof("Validated ownership certificate with OV PDC")
-
-	// Validate the response signature.
-	log.Infof("=============================================================================")
-	log.Infof("===================== Validating the response signature =====================")
-	log.Infof("=============================================================================")
-	log.Infof("Marshalling the response...")
-	signedResponseBytes, err := proto.Marshal(resp.GetSignedResponse())
-	if err != nil {
-		return err
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %v", path)
 	}
-	log.Infof("Successfully serialized the response")
+	return pool, nil
+}
 
-	log.Infof("Calculating the sha256 sum to validate the response signature...")
-	hashed := sha256.Sum256(signedResponseBytes)
-	log.Infof("Decoding the response...")
-	decodedSig, err := base64.StdEncoding.DecodeString(resp.GetResponseSignature())
-	if err != nil {
-		return err
+// parseImageMirrors parses a comma separated list of url=path pairs.
+func parseImageMirrors(s string) (map[string]string, error) {
+	mirrors := map[string]string{}
+	if s == "" {
+		return mirrors, nil
 	}
-	log.Infof("Decoded the response string")
-
-	log.Infof("Using the ownership certificate's public key to verify the signature... Note only RSA keys are supported")
-	// Verify the signature with the ownership certificate's public key. Currently only RSA keys are supported.
-	switch pub := ocCert.PublicKey.(type) {
-	case *rsa.PublicKey:
-		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], decodedSig)
-		if err != nil {
-			return fmt.Errorf("signature not verified: %v", err)
+	for _, entry := range strings.Split(s, ",") {
+		url, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid image mirror %q, want url=path", entry)
 		}
-	default:
-		return fmt.Errorf("unsupported public key type: %T", pub)
+		mirrors[url] = path
 	}
-	log.Infof("Verified SignedResponse signature")
-	return nil
+	return mirrors, nil
 }
 
-// validateImage validates if the hash of the downloaded OS image matches the received image hash.
-func validateImage(image []byte, softwareImage *bpb.SoftwareImage) error {
-	log.Info("Start to validate the downloaded image")
-	var hashed [32]byte
-	if softwareImage.GetHashAlgorithm() == "SHA256" {
-		hashed = sha256.Sum256(image)
+// clientOptions returns the bootstrap options set by the flags.
+func clientOptions(device *emulatedDevice) ([]bootstrap.Option, error) {
+	opts := []bootstrap.Option{
+		bootstrap.WithRevocationFetching(*fetchRevocation),
+		bootstrap.WithRetryPolicy(bootstrap.RetryPolicy{
+			MaxAttempts: *bootstrapAttempts,
+			Backoff:     *bootstrapBackoff,
+			MaxBackoff:  *bootstrapMaxBackoff,
+			Jitter:      *bootstrapJitter,
+		}),
+		bootstrap.WithImageMaxSize(*imageMaxSize),
+		bootstrap.WithImageRetries(*imageRetries),
+	}
+	if *insecureBoot {
+		opts = append(opts, bootstrap.WithInsecureBoot())
 	} else {
-		return fmt.Errorf("unknown hash algorithm: %q", softwareImage.GetHashAlgorithm())
+		if *rootCA == "" {
+			return nil, fmt.Errorf("no Root CA certificate file specified")
+		}
+		log.Infof("Reading Root CA certificate file...")
+		pool, err := readCertPool(*rootCA)
+		if err != nil {
+			return nil, fmt.Errorf("error loading Root CA certificate: %v", err)
+		}
+		log.Infof("Successfully read Root CA certificate file")
+		opts = append(opts, bootstrap.WithVendorCAs(pool))
 	}
-
-	receivedHashed, err := hex.DecodeString(softwareImage.GetOsImageHash())
-	if err != nil {
-		return fmt.Errorf("can not decode received hashed image to bytes, received hash: %q", softwareImage.GetOsImageHash())
+	if *legacyOV {
+		opts = append(opts, bootstrap.WithLegacyOVParsing())
 	}
-	if !bytes.Equal(hashed[:], receivedHashed) {
-		return fmt.Errorf("unmatched hash, recevived: %v, downloaded: %v, received hex string: %v, downloaded hex string: %v", receivedHashed, hashed[:], softwareImage.OsImageHash, hex.EncodeToString(hashed[:]))
+	if *requireOVNonce {
+		opts = append(opts, bootstrap.WithRequireOVNonce())
 	}
-	log.Info("Verified image hash")
-	return nil
-}
-
-// downloadImage downloads image from the given URL.
-// This is a mock implementation.
-func downloadImage(url string) ([]byte, error) {
-	log.Infof("Start to download image from %q", url)
-	path := urlImageMap[url]
-
-	f, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("can not download image: %v", err)
+	if *verifyServerID {
+		opts = append(opts, bootstrap.WithServerIdentityVerification())
 	}
-	log.Infof("Image is successfully downloaded, content length: %v", len(f))
-	return f, nil
-}
 
-func certFromPemBlock(data []byte) (*x509.Certificate, error) {
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return nil, fmt.Errorf("failed to parse certificate PEM")
+	// 1. DHCP Discovery of Bootstrap Server
+	// The device asks DHCP for the sZTP redirect option, which lists the bootz servers
+	// to try. Without a DHCP interface the client connects to localhost.
+	if *dhcpInterface == "" {
+		if *port == "" {
+			return nil, fmt.Errorf("no port provided")
+		}
+		opts = append(opts, bootstrap.WithDiscoverer(bootstrap.StaticServers(fmt.Sprintf("localhost:%v", *port))))
+	} else {
+		opts = append(opts, bootstrap.WithDiscoverer(bootstrap.DHCPDiscoverer(*dhcpInterface, device.ActiveSerial(),
+			bootstrap.WithDHCPv4(*dhcpIPv4), bootstrap.WithDHCPv6(*dhcpIPv6), bootstrap.WithDHCPTimeout(*dhcpTimeout))))
 	}
-	return x509.ParseCertificate(block.Bytes)
-}
 
-// generateNonce() generates a fixed-length nonce.
-func generateNonce() (string, error) {
-	b := make([]byte, nonceLength)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", err
+	// 2. Bootstrapping Service
+	// Device initiates a TLS-secured gRPC connection with the Bootz server.
+	tlsConfig := &tls.Config{InsecureSkipVerify: !*verifyTLSCert}
+	if device.idevid != nil {
+		log.Infof("Presenting the IDevID certificate to the Bootz server")
+		tlsConfig.Certificates = []tls.Certificate{*device.idevid}
 	}
-	return base64.StdEncoding.EncodeToString(b), nil
-}
-
-func main() {
-	ctx := context.Background()
-	flag.Parse()
-	log.Infof("=============================================================================")
-	log.Infof("=========================== BootZ Client Emulator ===========================")
-	log.Infof("=============================================================================")
+	opts = append(opts, bootstrap.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 
-	log.Infof("=============================================================================")
-	log.Infof("======================== Loading Root CA Certificate ========================")
-	log.Infof("=============================================================================")
-	if *rootCA == "" {
-		log.Exitf("No Root CA certificate file specified")
+	if *imageDir != "" {
+		opts = append(opts, bootstrap.WithImageDir(*imageDir))
 	}
-	log.Infof("Reading Root CA certificate file...")
-	rootCABytes, err := os.ReadFile(*rootCA)
-	if err != nil {
-		log.Exitf("Error opening Root CA file: %v", err)
+	if *imageCACert != "" {
+		pool, err := readCertPool(*imageCACert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read image CA bundle: %v", err)
+		}
+		opts = append(opts, bootstrap.WithImageRootCAs(pool))
 	}
-	log.Infof("Successfully read Root CA certificate file")
-
-	// Verify the Root CA cert is valid.
-	log.Infof("Verifying Root CA certificate...")
-	caCert, err := certFromPemBlock(rootCABytes)
+	mirrors, err := parseImageMirrors(*imageMirrors)
 	if err != nil {
-		log.Exitf("Error parsing Root CA certificate")
+		return nil, err
 	}
-	log.Infof("Loaded Root CA certificate: %v", string(caCert.Subject.CommonName))
+	return append(opts, bootstrap.WithImageMirrors(mirrors)), nil
+}
 
+// bootDevice powers up the emulated device and runs the bootstrap process once.
+// It returns bootstrap.ErrRebootRequired when the device reaches a reboot point.
+func bootDevice(ctx context.Context, backend bootstrap.Device) error {
 	log.Infof("=============================================================================")
 	log.Infof("================== Constructing a fake device for testing ===================")
 	log.Infof("=============================================================================")
-	// Construct the fake device.
-	// TODO: Allow these values to be set e.g. via a flag.
-	chassis := bpb.ChassisDescriptor{
-		Manufacturer: "Cisco",
-		SerialNumber: "123",
-		ControlCards: []*bpb.ControlCard{
-			{
-				SerialNumber: "123A",
-				Slot:         1,
-				PartNumber:   "123A",
-			},
-			{
-				SerialNumber: "123B",
-				Slot:         2,
-				PartNumber:   "123B",
-			},
-		},
-	}
-
-	log.Infof("%v chassis %v starting with SecureOnly = %v", chassis.Manufacturer, chassis.SerialNumber, !*insecureBoot)
-
-	// 1. DHCP Discovery of Bootstrap Server
-	// This step emulates the retrieval of the bootz server IP
-	// address from a DHCP server. In this case we always connect to localhost.
-	log.Infof("=============================================================================")
-	log.Infof("================ Starting DHCP discovery of bootstrap server ================")
-	log.Infof("=============================================================================")
-	if *port == "" {
-		log.Exitf("No port provided.")
+	device, err := loadDevice()
+	if err != nil {
+		return fmt.Errorf("error constructing the emulated device: %v", err)
 	}
-	bootzAddress := fmt.Sprintf("localhost:%v", *port)
-	log.Infof("Connecting to bootz server at address %q", bootzAddress)
+	chassis := device.Descriptor
 
-	// 2. Bootstrapping Service
-	// Device initiates a TLS-secured gRPC connection with the Bootz server.
-	tlsConfig := &tls.Config{InsecureSkipVerify: !*verifyTLSCert}
-	conn, err := grpc.Dial(bootzAddress, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	opts, err := clientOptions(device)
 	if err != nil {
-		log.Exitf("Client unable to connect to Bootstrap Server: %v", err)
+		return fmt.Errorf("error configuring the client: %v", err)
 	}
-	defer conn.Close()
-	log.Infof("Creating a new bootstrap client")
-	c := bpb.NewBootstrapClient(conn)
-	log.Infof("Client connected to bootz server")
-
-	// This is the active control card making the bootz request.
-	log.Infof("=============================================================================")
-	log.Infof("Setting active control card with serial number: %v, slot: %v, part number: %v",
-		chassis.ControlCards[0].SerialNumber, chassis.ControlCards[0].Slot, chassis.ControlCards[0].PartNumber)
-	activeControlCard := chassis.ControlCards[0]
-
-	nonce := ""
-	if !*insecureBoot {
-		log.Infof("Device in secure boot mode, generating a nonce that the Bootz server will use to sign the response")
-		// Generate a nonce that the Bootz server will use to sign the response.
-		nonce, err = generateNonce()
+	if *stateDir != "" {
+		store, err := bootstrap.NewFileStateStore(*stateDir)
 		if err != nil {
-			log.Exitf("Error generating nonce: %v", err)
+			return err
 		}
-		log.Infof("Nonce of %v generated successfully", nonce)
+		opts = append(opts, bootstrap.WithStateStore(store))
+	}
+	client, err := bootstrap.New(device.Chassis, backend, opts...)
+	if err != nil {
+		return fmt.Errorf("error creating the client: %v", err)
+	}
+	for _, serial := range device.CardSerials() {
+		log.Infof("Control card %v starting in state %v", serial, device.States[serial])
 	}
+	log.Infof("%v chassis %v starting with SecureOnly = %v", chassis.Manufacturer, chassis.SerialNumber, !*insecureBoot)
+
+	// The bootstrap process fetches and verifies the bootstrap data, downloads and installs
+	// the images and reports the outcome. Failures are reported to the server and the process
+	// is restarted from Step 1.
+	return client.Bootstrap(ctx)
+}
 
+func main() {
+	ctx := context.Background()
+	flag.Parse()
 	log.Infof("=============================================================================")
-	log.Infof("======================== Retrieving bootstrap data ==========================")
+	log.Infof("=========================== BootZ Client Emulator ===========================")
 	log.Infof("=============================================================================")
-	log.Infof("Building bootstrap data request")
-	req := &bpb.GetBootstrapDataRequest{
-		ChassisDescriptor: &chassis,
-		// This is the active control card, e.g. the one making the bootz request.
-		ControlCardState: &bpb.ControlCardState{
-			SerialNumber: activeControlCard.GetSerialNumber(),
-			Status:       bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
-		},
-		Nonce: nonce,
+	if *exitOnReboot && *stateDir == "" {
+		log.Exitf("exit_on_reboot requires state_dir")
 	}
-	log.Infof("Built bootstrap data request with %v chassis %v and control card %v with status %v and nonce %v",
-		req.ChassisDescriptor.Manufacturer, req.ChassisDescriptor.SerialNumber, req.ControlCardState.SerialNumber, req.ControlCardState.Status, req.Nonce)
 
-	// Get bootstrapping data from Bootz server
-	// TODO: Extract and parse response.
-	log.Infof("Requesting Bootstrap Data from Bootz server")
-	resp, err := c.GetBootstrapData(ctx, req)
-	if err != nil {
-		log.Exitf("Error calling GetBootstrapData: %v", err)
+	dir := *deviceDir
+	if dir == "" && *stateDir != "" {
+		dir = filepath.Join(*stateDir, "device")
 	}
-	log.Infof("Successfully retrieved Bootstrap Data from server")
-
-	// Only check OC, OV and response signature if SecureOnly is set.
-	if !*insecureBoot {
-		log.Infof("=============================================================================")
-		log.Infof("====================== Validating response signature ========================")
-		log.Infof("=============================================================================")
-		if err := validateArtifacts(activeControlCard.GetSerialNumber(), resp, rootCABytes); err != nil {
-			log.Exitf("Error validating signed data: %v", err)
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "bootz-device-"); err != nil {
+			log.Exitf("Error creating device directory: %v", err)
 		}
 	}
-
-	signedResp := resp.GetSignedResponse()
-	if !*insecureBoot && signedResp.GetNonce() != nonce {
-		log.Exitf("GetBootstrapDataResponse nonce does not match")
+	backend, err := bootstrap.NewFSDevice(dir)
+	if err != nil {
+		log.Exitf("Error setting up the emulated device: %v", err)
 	}
+	log.Infof("Bootstrap data applied to the device is written to %v", dir)
 
-	// TODO: Verify the hash of the intended image.
-	// Simply print out the received configs we get. This section should actually contain the logic to verify and install the images and config.
-	log.Infof("=============================================================================")
-	log.Infof("===================== Processing control card configs =======================")
-	log.Infof("=============================================================================")
-	for _, data := range signedResp.GetResponses() {
-		log.Infof("Received config for control card %v", data.GetSerialNum())
-		log.Infof("Start to download and validate image, received: %+v...", data.GetIntendedImage())
-		image, err := downloadImage(data.GetIntendedImage().GetUrl())
-		if err != nil {
-			log.Exitf("unable to download image (url: %q): %v", data.GetIntendedImage().GetUrl(), err)
+	for {
+		err := bootDevice(ctx, backend)
+		if !errors.Is(err, bootstrap.ErrRebootRequired) {
+			if err != nil {
+				log.Exitf("%v", err)
+			}
+			break
 		}
-		err = validateImage(image, data.GetIntendedImage())
-		if err != nil {
-			log.Exitf("Error validating intended image: %v", err)
+		if *exitOnReboot {
+			log.Infof("%v, exiting. Run the client again to resume from %v", err, *stateDir)
+			return
 		}
-		time.Sleep(time.Second * 5)
-		log.Infof("Done")
-		log.Infof("Installing boot config %+v...", data.GetBootConfig())
-		time.Sleep(time.Second * 5)
-		log.Infof("Done")
-		log.Infof("=============================================================================")
-	}
-
-	// 6. ReportProgress
-	log.Infof("=========================== Sending Status Report ===========================")
-	log.Infof("=============================================================================")
-	statusReq := &bpb.ReportStatusRequest{
-		Status:        bpb.ReportStatusRequest_BOOTSTRAP_STATUS_SUCCESS,
-		StatusMessage: "Bootstrap Success",
-		States: []*bpb.ControlCardState{
-			{
-				Status:       bpb.ControlCardState_CONTROL_CARD_STATUS_INITIALIZED,
-				SerialNumber: chassis.GetControlCards()[0].GetSerialNumber(),
-			},
-			{
-				Status:       bpb.ControlCardState_CONTROL_CARD_STATUS_INITIALIZED,
-				SerialNumber: chassis.GetControlCards()[1].GetSerialNumber(),
-			},
-		},
-	}
-
-	_, err = c.ReportStatus(ctx, statusReq)
-	if err != nil {
-		log.Exitf("Error reporting status: %v", err)
+		log.Infof("%v, simulating a reboot", err)
 	}
-	log.Infof("Status report sent")
 	// At this point the device has minimal configuration and can receive further gRPC calls. After this, the TPM Enrollment and attestation occurs.
 }
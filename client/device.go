@@ -0,0 +1,193 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"github.com/openconfig/bootz/client/bootstrap"
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+var (
+	chassisDescriptor = flag.String("chassis_descriptor", "", "Path to a ChassisDescriptor in prototext or, with a .json extension, JSON format describing the emulated device. If neither this nor the identity flags are set, a Cisco chassis 123 with control cards 123A and 123B is emulated.")
+	manufacturer      = flag.String("manufacturer", "", "Manufacturer of the emulated device. Overrides the chassis descriptor.")
+	partNumber        = flag.String("part_number", "", "Part number of the emulated device. Overrides the chassis descriptor.")
+	serialNumber      = flag.String("serial_number", "", "Serial number of the emulated device. Overrides the chassis descriptor.")
+	controlCards      = flag.String("control_cards", "", "Comma separated list of control cards as serial[:slot[:part_number]]. Overrides the cards in the chassis descriptor. Leave empty for fixed form factor devices.")
+	activeControlCard = flag.String("active_control_card", "", "Serial number of the control card making the bootstrap request. Defaults to the first control card.")
+	controlCardStates = flag.String("control_card_states", "", "Comma separated list of serial=STATE giving the initial control card states, e.g. 123A=INITIALIZED. Cards default to NOT_INITIALIZED. States saved in state_dir take precedence.")
+	idevidCert        = flag.String("idevid_cert", "", "Path to a PEM encoded IDevID certificate which the device presents as its TLS client certificate. Requires idevid_key.")
+	idevidKey         = flag.String("idevid_key", "", "Path to the PEM encoded private key of the IDevID certificate.")
+)
+
+// defaultChassis is the device emulated when no identity is configured.
+func defaultChassis() *bpb.ChassisDescriptor {
+	return &bpb.ChassisDescriptor{
+		Manufacturer: "Cisco",
+		SerialNumber: "123",
+		ControlCards: []*bpb.ControlCard{
+			{
+				SerialNumber: "123A",
+				Slot:         1,
+				PartNumber:   "123A",
+			},
+			{
+				SerialNumber: "123B",
+				Slot:         2,
+				PartNumber:   "123B",
+			},
+		},
+	}
+}
+
+// emulatedDevice is the identity of the device the client emulates.
+type emulatedDevice struct {
+	*bootstrap.Chassis
+	// idevid, if set, is presented as the TLS client certificate.
+	idevid *tls.Certificate
+}
+
+// readChassisDescriptor reads a ChassisDescriptor from a prototext or JSON file.
+func readChassisDescriptor(path string) (*bpb.ChassisDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read chassis descriptor: %v", err)
+	}
+	chassis := &bpb.ChassisDescriptor{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = protojson.Unmarshal(data, chassis)
+	} else {
+		err = prototext.Unmarshal(data, chassis)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse chassis descriptor %v: %v", path, err)
+	}
+	return chassis, nil
+}
+
+// parseControlCards parses a comma separated list of serial[:slot[:part_number]].
+func parseControlCards(s string) ([]*bpb.ControlCard, error) {
+	var cards []*bpb.ControlCard
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid control card %q, want serial[:slot[:part_number]]", entry)
+		}
+		card := &bpb.ControlCard{SerialNumber: fields[0]}
+		if len(fields) > 1 {
+			slot, err := strconv.ParseInt(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slot in control card %q: %v", entry, err)
+			}
+			card.Slot = int32(slot)
+		}
+		if len(fields) > 2 {
+			card.PartNumber = fields[2]
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+// parseControlCardStates parses a comma separated list of serial=STATE. The
+// CONTROL_CARD_STATUS_ prefix of the state is optional.
+func parseControlCardStates(s string) (map[string]bpb.ControlCardState_ControlCardStatus, error) {
+	states := map[string]bpb.ControlCardState_ControlCardStatus{}
+	for _, entry := range strings.Split(s, ",") {
+		serial, state, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || serial == "" {
+			return nil, fmt.Errorf("invalid control card state %q, want serial=STATE", entry)
+		}
+		name := strings.ToUpper(state)
+		if !strings.HasPrefix(name, "CONTROL_CARD_STATUS_") {
+			name = "CONTROL_CARD_STATUS_" + name
+		}
+		v, ok := bpb.ControlCardState_ControlCardStatus_value[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown control card state %q", state)
+		}
+		states[serial] = bpb.ControlCardState_ControlCardStatus(v)
+	}
+	return states, nil
+}
+
+// loadDevice builds the emulated device from the chassis descriptor and identity flags.
+func loadDevice() (*emulatedDevice, error) {
+	var chassis *bpb.ChassisDescriptor
+	switch {
+	case *chassisDescriptor != "":
+		c, err := readChassisDescriptor(*chassisDescriptor)
+		if err != nil {
+			return nil, err
+		}
+		chassis = c
+	case *manufacturer == "" && *serialNumber == "" && *controlCards == "":
+		chassis = defaultChassis()
+	default:
+		chassis = &bpb.ChassisDescriptor{}
+	}
+	if *manufacturer != "" {
+		chassis.Manufacturer = *manufacturer
+	}
+	if *partNumber != "" {
+		chassis.PartNumber = *partNumber
+	}
+	if *serialNumber != "" {
+		chassis.SerialNumber = *serialNumber
+	}
+	if *controlCards != "" {
+		cards, err := parseControlCards(*controlCards)
+		if err != nil {
+			return nil, err
+		}
+		chassis.ControlCards = cards
+	}
+
+	c, err := bootstrap.NewChassis(chassis, *activeControlCard)
+	if err != nil {
+		return nil, err
+	}
+	d := &emulatedDevice{Chassis: c}
+	if *controlCardStates != "" {
+		states, err := parseControlCardStates(*controlCardStates)
+		if err != nil {
+			return nil, err
+		}
+		for serial, state := range states {
+			if err := d.SetState(serial, state); err != nil {
+				return nil, fmt.Errorf("control card state given for unknown card %q", serial)
+			}
+		}
+	}
+
+	if *idevidCert != "" || *idevidKey != "" {
+		cert, err := tls.LoadX509KeyPair(*idevidCert, *idevidKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load IDevID credentials: %v", err)
+		}
+		d.idevid = &cert
+	}
+	return d, nil
+}
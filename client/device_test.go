@@ -0,0 +1,169 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/h-fam/errdiff"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// setFlags sets the device flags for the duration of the test.
+func setFlags(t *testing.T, values map[*string]string) {
+	t.Helper()
+	for f, v := range values {
+		f, old := f, *f
+		*f = v
+		t.Cleanup(func() { *f = old })
+	}
+}
+
+func TestLoadDevice(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "fixed.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"manufacturer": "Arista", "serialNumber": "FX100"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		flags       map[*string]string
+		wantChassis *bpb.ChassisDescriptor
+		wantActive  string
+		wantStates  map[string]bpb.ControlCardState_ControlCardStatus
+		wantErr     string
+	}{{
+		desc:        "Default device",
+		wantChassis: defaultChassis(),
+		wantActive:  "123A",
+		wantStates: map[string]bpb.ControlCardState_ControlCardStatus{
+			"123A": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+			"123B": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+		},
+	}, {
+		desc: "Prototext descriptor with active card and states",
+		flags: map[*string]string{
+			chassisDescriptor: "../testdata/chassis_descriptor.prototxt",
+			activeControlCard: "123B",
+			controlCardStates: "123A=INITIALIZED",
+		},
+		wantChassis: defaultChassis(),
+		wantActive:  "123B",
+		wantStates: map[string]bpb.ControlCardState_ControlCardStatus{
+			"123A": bpb.ControlCardState_CONTROL_CARD_STATUS_INITIALIZED,
+			"123B": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+		},
+	}, {
+		desc:        "JSON fixed form factor descriptor",
+		flags:       map[*string]string{chassisDescriptor: jsonPath},
+		wantChassis: &bpb.ChassisDescriptor{Manufacturer: "Arista", SerialNumber: "FX100"},
+		wantActive:  "FX100",
+		wantStates: map[string]bpb.ControlCardState_ControlCardStatus{
+			"FX100": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+		},
+	}, {
+		desc: "Flags only",
+		flags: map[*string]string{
+			manufacturer: "Juniper",
+			partNumber:   "MX",
+			controlCards: "RE0:0:RE-S, RE1:1",
+		},
+		wantChassis: &bpb.ChassisDescriptor{
+			Manufacturer: "Juniper",
+			PartNumber:   "MX",
+			ControlCards: []*bpb.ControlCard{
+				{SerialNumber: "RE0", Slot: 0, PartNumber: "RE-S"},
+				{SerialNumber: "RE1", Slot: 1},
+			},
+		},
+		wantActive: "RE0",
+		wantStates: map[string]bpb.ControlCardState_ControlCardStatus{
+			"RE0": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+			"RE1": bpb.ControlCardState_CONTROL_CARD_STATUS_NOT_INITIALIZED,
+		},
+	}, {
+		desc:    "Missing descriptor file",
+		flags:   map[*string]string{chassisDescriptor: "../testdata/does_not_exist.prototxt"},
+		wantErr: "unable to read chassis descriptor",
+	}, {
+		desc:    "Fixed chassis without serial",
+		flags:   map[*string]string{manufacturer: "Arista", partNumber: "7280"},
+		wantErr: "must have a serial number",
+	}, {
+		desc:    "Duplicate control cards",
+		flags:   map[*string]string{manufacturer: "Cisco", controlCards: "A:1,A:2"},
+		wantErr: "duplicate control card",
+	}, {
+		desc:    "Invalid slot",
+		flags:   map[*string]string{manufacturer: "Cisco", controlCards: "A:one"},
+		wantErr: "invalid slot",
+	}, {
+		desc:    "Unknown active card",
+		flags:   map[*string]string{activeControlCard: "123C"},
+		wantErr: "not in the chassis",
+	}, {
+		desc:    "Unknown state",
+		flags:   map[*string]string{controlCardStates: "123A=BROKEN"},
+		wantErr: "unknown control card state",
+	}, {
+		desc:    "State for unknown card",
+		flags:   map[*string]string{controlCardStates: "123C=INITIALIZED"},
+		wantErr: "unknown card",
+	}, {
+		desc:    "IDevID key without cert",
+		flags:   map[*string]string{idevidKey: "../testdata/oc_priv.pem"},
+		wantErr: "unable to load IDevID credentials",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			setFlags(t, test.flags)
+			got, err := loadDevice()
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("loadDevice() %s", s)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.wantChassis, got.Descriptor, protocmp.Transform()); diff != "" {
+				t.Errorf("loadDevice() chassis diff (-want +got):\n%s", diff)
+			}
+			if got.ActiveSerial() != test.wantActive {
+				t.Errorf("loadDevice() active serial = %v, want %v", got.ActiveSerial(), test.wantActive)
+			}
+			if diff := cmp.Diff(test.wantStates, got.States); diff != "" {
+				t.Errorf("loadDevice() states diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadDeviceIDevID(t *testing.T) {
+	setFlags(t, map[*string]string{
+		idevidCert: "../testdata/oc_pub.pem",
+		idevidKey:  "../testdata/oc_priv.pem",
+	})
+	got, err := loadDevice()
+	if err != nil {
+		t.Fatalf("loadDevice() err = %v, want nil", err)
+	}
+	if got.idevid == nil {
+		t.Errorf("loadDevice() did not load the IDevID credentials")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Bootz fleet simulator.
+//
+// The simulator drives many emulated devices concurrently against a Bootz
+// server, e.g. to see how the server copes with a data center powering up
+// at once, and reports latencies, errors and status transitions at the end.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+	epb "github.com/openconfig/bootz/server/entitymanager/proto/entity"
+)
+
+var (
+	serverAddress    = flag.String("server_address", "localhost:15006", "Address of the Bootz server.")
+	inventoryConfig  = flag.String("inv_config", "../../testdata/inventory_local.prototxt", "Inventory file in the server's format which the device identities are taken from.")
+	rootCA           = flag.String("root_ca_cert_path", "../../testdata/vendorca_pub.pem", "Path to a file containing a PEM encoded certificate for the manufacturer CA, used to verify the responses of secure devices.")
+	deviceCount      = flag.Int("devices", 0, "Number of devices to simulate. The inventory's chassis are used in turn. Defaults to one device per chassis.")
+	arrivalRate      = flag.Float64("arrival_rate", 0, "Number of devices starting per second. 0 starts all devices at once.")
+	concurrency      = flag.Int("concurrency", 0, "Maximum number of devices bootstrapping at the same time. 0 is unlimited.")
+	insecureFraction = flag.Float64("insecure_fraction", 0, "Fraction of the devices which boot in insecure mode.")
+	failureMix       = flag.String("failures", "", "Comma separated list of failure=fraction giving the fraction of devices which inject each failure: abandon (disconnect after fetching, without reporting), report_failure (report BOOTSTRAP_STATUS_FAILURE) and unknown_device (use a serial number missing from the inventory).")
+	dialTimeout      = flag.Duration("dial_timeout", 10*time.Second, "How long each device waits for its connection to the server.")
+	requestTimeout   = flag.Duration("request_timeout", 30*time.Second, "How long each device waits for each RPC.")
+	seed             = flag.Int64("seed", 0, "Seed for the random choice of modes and failures. 0 uses the current time.")
+)
+
+// readInventory returns the chassis descriptors of the chassis in an inventory file.
+func readInventory(path string) ([]*bpb.ChassisDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory: %v", err)
+	}
+	entities := &epb.Entities{}
+	if err := prototext.Unmarshal(data, entities); err != nil {
+		return nil, fmt.Errorf("unable to parse inventory %v: %v", path, err)
+	}
+	var descriptors []*bpb.ChassisDescriptor
+	for _, ch := range entities.GetChassis() {
+		d := &bpb.ChassisDescriptor{
+			Manufacturer: ch.GetManufacturer(),
+			SerialNumber: ch.GetSerialNumber(),
+			PartNumber:   ch.GetPartNumber(),
+		}
+		for i, cc := range ch.GetControllerCards() {
+			d.ControlCards = append(d.ControlCards, &bpb.ControlCard{
+				SerialNumber: cc.GetSerialNumber(),
+				PartNumber:   cc.GetPartNumber(),
+				Slot:         int32(i + 1),
+			})
+		}
+		descriptors = append(descriptors, d)
+	}
+	if len(descriptors) == 0 {
+		return nil, fmt.Errorf("inventory %v has no chassis", path)
+	}
+	return descriptors, nil
+}
+
+// parseFailureMix parses a comma separated list of failure=fraction.
+func parseFailureMix(s string) ([]failureRate, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var rates []failureRate
+	total := 0.0
+	for _, entry := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid failure %q, want failure=fraction", entry)
+		}
+		f := failure(name)
+		switch f {
+		case failureAbandon, failureReportFailure, failureUnknownDevice:
+		default:
+			return nil, fmt.Errorf("unknown failure %q", name)
+		}
+		fraction, err := strconv.ParseFloat(value, 64)
+		if err != nil || fraction < 0 {
+			return nil, fmt.Errorf("invalid fraction for failure %q: %q", name, value)
+		}
+		total += fraction
+		rates = append(rates, failureRate{failure: f, fraction: fraction})
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("failure fractions add up to %v, more than 1", total)
+	}
+	return rates, nil
+}
+
+func main() {
+	flag.Parse()
+
+	identities, err := readInventory(*inventoryConfig)
+	if err != nil {
+		log.Exit(err)
+	}
+	failures, err := parseFailureMix(*failureMix)
+	if err != nil {
+		log.Exit(err)
+	}
+	var vendorCAs *x509.CertPool
+	if *insecureFraction < 1 {
+		data, err := os.ReadFile(*rootCA)
+		if err != nil {
+			log.Exitf("Error opening Root CA file: %v", err)
+		}
+		vendorCAs = x509.NewCertPool()
+		if !vendorCAs.AppendCertsFromPEM(data) {
+			log.Exitf("No certificates found in %v", *rootCA)
+		}
+	}
+	count := *deviceCount
+	if count == 0 {
+		count = len(identities)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	sim := &simulator{
+		server:           *serverAddress,
+		identities:       identities,
+		devices:          count,
+		arrivalRate:      *arrivalRate,
+		concurrency:      *concurrency,
+		insecureFraction: *insecureFraction,
+		failures:         failures,
+		vendorCAs:        vendorCAs,
+		// The server's TLS certificate is not verified, as the response is authenticated by its signature.
+		creds:          credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}),
+		dialTimeout:    *dialTimeout,
+		requestTimeout: *requestTimeout,
+		rand:           rand.New(rand.NewSource(*seed)),
+	}
+	log.Infof("Simulating %d devices against %v with seed %d", count, *serverAddress, *seed)
+	results := sim.run(context.Background())
+	results.print(os.Stdout)
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// results aggregates the outcomes of the simulated devices.
+type results struct {
+	mu       sync.Mutex
+	start    time.Time
+	elapsed  time.Duration
+	devices  int
+	insecure int
+	// unexpected counts the devices which did not end as planned.
+	unexpected int
+	// outcomes counts the devices by outcome.
+	outcomes map[string]int
+	// latencies holds the latency of each phase, per device which ran it.
+	latencies map[string][]time.Duration
+	// transitions counts the reported control card state changes.
+	transitions map[string]int
+}
+
+func newResults() *results {
+	return &results{
+		start:       time.Now(),
+		outcomes:    map[string]int{},
+		latencies:   map[string][]time.Duration{},
+		transitions: map[string]int{},
+	}
+}
+
+// add records the result of a device.
+func (r *results) add(p plan, res *deviceResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices++
+	if p.insecure {
+		r.insecure++
+	}
+	if !res.expected {
+		r.unexpected++
+	}
+	r.outcomes[res.outcome]++
+	for phase, d := range res.latencies {
+		r.latencies[phase] = append(r.latencies[phase], d)
+	}
+	for _, t := range res.transitions {
+		r.transitions[t]++
+	}
+}
+
+// percentile returns the latency below which the fraction p of the sorted latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// print writes a report of the results.
+func (r *results) print(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(w, "Devices: %d (%d secure, %d insecure) in %v\n", r.devices, r.devices-r.insecure, r.insecure, r.elapsed.Round(time.Millisecond))
+	rate := 0.0
+	if r.devices > 0 {
+		rate = 100 * float64(r.unexpected) / float64(r.devices)
+	}
+	fmt.Fprintf(w, "Error rate: %.2f%% (%d devices did not end as planned)\n", rate, r.unexpected)
+
+	fmt.Fprintf(w, "\nOutcomes:\n")
+	for _, o := range sortedKeys(r.outcomes) {
+		fmt.Fprintf(w, "  %-40s %6d\n", o, r.outcomes[o])
+	}
+
+	fmt.Fprintf(w, "\nLatency:\n")
+	fmt.Fprintf(w, "  %-8s %6s %10s %10s %10s %10s\n", "phase", "count", "p50", "p90", "p99", "max")
+	for _, phase := range []string{phaseConnect, phaseFetch, phaseVerify, phaseReport, phaseTotal} {
+		l := r.latencies[phase]
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(w, "  %-8s %6d %10v %10v %10v %10v\n", phase, len(l),
+			percentile(l, 0.5).Round(time.Microsecond), percentile(l, 0.9).Round(time.Microsecond),
+			percentile(l, 0.99).Round(time.Microsecond), l[len(l)-1].Round(time.Microsecond))
+	}
+
+	fmt.Fprintf(w, "\nStatus transitions:\n")
+	for _, t := range sortedKeys(r.transitions) {
+		fmt.Fprintf(w, "  %-40s %6d\n", t, r.transitions[t])
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/openconfig/bootz/client/bootstrap"
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+// failure is a failure a simulated device injects.
+type failure string
+
+const (
+	failureNone failure = ""
+	// failureAbandon disconnects after fetching the bootstrap data, without reporting.
+	failureAbandon failure = "abandon"
+	// failureReportFailure reports BOOTSTRAP_STATUS_FAILURE instead of success.
+	failureReportFailure failure = "report_failure"
+	// failureUnknownDevice requests bootstrap data for a serial number missing from the inventory.
+	failureUnknownDevice failure = "unknown_device"
+)
+
+// failureRate is the fraction of devices injecting a failure.
+type failureRate struct {
+	failure  failure
+	fraction float64
+}
+
+// Phases of a device's bootstrap whose latency is measured.
+const (
+	phaseConnect = "connect"
+	phaseFetch   = "fetch"
+	phaseVerify  = "verify"
+	phaseReport  = "report"
+	phaseTotal   = "total"
+)
+
+// plan is what a simulated device does.
+type plan struct {
+	id       int
+	chassis  *bpb.ChassisDescriptor
+	insecure bool
+	failure  failure
+}
+
+// simulator drives a fleet of emulated devices against a Bootz server.
+type simulator struct {
+	server string
+	// identities are the chassis the devices are, in turn.
+	identities []*bpb.ChassisDescriptor
+	devices    int
+	// arrivalRate is the number of devices starting per second, or 0 to start all at once.
+	arrivalRate float64
+	// concurrency limits the devices bootstrapping at once, if not 0.
+	concurrency      int
+	insecureFraction float64
+	failures         []failureRate
+	vendorCAs        *x509.CertPool
+	creds            credentials.TransportCredentials
+	dialTimeout      time.Duration
+	requestTimeout   time.Duration
+	rand             *rand.Rand
+}
+
+// plans decides the identity, mode and failure of every device.
+func (s *simulator) plans() []plan {
+	var plans []plan
+	for i := 0; i < s.devices; i++ {
+		p := plan{
+			id:       i,
+			chassis:  proto.Clone(s.identities[i%len(s.identities)]).(*bpb.ChassisDescriptor),
+			insecure: s.rand.Float64() < s.insecureFraction,
+		}
+		r := s.rand.Float64()
+		for _, f := range s.failures {
+			if r < f.fraction {
+				p.failure = f.failure
+				break
+			}
+			r -= f.fraction
+		}
+		if p.failure == failureUnknownDevice {
+			p.chassis.SerialNumber = fmt.Sprintf("%v-unknown-%d", p.chassis.GetSerialNumber(), i)
+			for _, c := range p.chassis.GetControlCards() {
+				c.SerialNumber = fmt.Sprintf("%v-unknown-%d", c.GetSerialNumber(), i)
+			}
+		}
+		plans = append(plans, p)
+	}
+	return plans
+}
+
+// run starts the devices at the arrival rate and waits for all of them to finish.
+func (s *simulator) run(ctx context.Context) *results {
+	r := newResults()
+	var sem chan struct{}
+	if s.concurrency > 0 {
+		sem = make(chan struct{}, s.concurrency)
+	}
+	var interval time.Duration
+	if s.arrivalRate > 0 {
+		interval = time.Duration(float64(time.Second) / s.arrivalRate)
+	}
+	var wg sync.WaitGroup
+	for i, p := range s.plans() {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(p plan) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			r.add(p, s.runDevice(ctx, p))
+		}(p)
+	}
+	wg.Wait()
+	r.elapsed = time.Since(r.start)
+	return r
+}
+
+// deviceResult is the outcome of a simulated device.
+type deviceResult struct {
+	// outcome is "success", the injected failure or the phase and cause of an error.
+	outcome string
+	// expected is whether the device ended as planned, including injected failures.
+	expected  bool
+	latencies map[string]time.Duration
+	// transitions are the control card state changes the device reported, e.g. "NOT_INITIALIZED -> INITIALIZED".
+	transitions []string
+}
+
+// errorOutcome describes an error in a phase by its gRPC code or the failed verification check.
+func errorOutcome(phase string, err error) string {
+	var verr *bootstrap.VerificationError
+	if errors.As(err, &verr) {
+		return fmt.Sprintf("%v error: %v", phase, verr.Reason)
+	}
+	if s, ok := status.FromError(err); ok {
+		return fmt.Sprintf("%v error: %v", phase, s.Code())
+	}
+	return fmt.Sprintf("%v error", phase)
+}
+
+// runDevice bootstraps one device: it connects, fetches and verifies the bootstrap data and reports the outcome.
+func (s *simulator) runDevice(ctx context.Context, p plan) *deviceResult {
+	res := &deviceResult{latencies: map[string]time.Duration{}}
+	start := time.Now()
+	defer func() { res.latencies[phaseTotal] = time.Since(start) }()
+	fail := func(phase string, err error) *deviceResult {
+		res.outcome = errorOutcome(phase, err)
+		res.expected = p.failure == failureUnknownDevice && phase == phaseFetch
+		log.V(1).Infof("Device %d (%v): %v: %v", p.id, p.chassis.GetSerialNumber(), res.outcome, err)
+		return res
+	}
+	// timed runs a phase and records its latency.
+	timed := func(phase string, f func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+		t := time.Now()
+		err := f(ctx)
+		res.latencies[phase] = time.Since(t)
+		return err
+	}
+
+	chassis, err := bootstrap.NewChassis(p.chassis, "")
+	if err != nil {
+		return fail("setup", err)
+	}
+	opts := []bootstrap.Option{bootstrap.WithVendorCAs(s.vendorCAs)}
+	if p.insecure {
+		opts = append(opts, bootstrap.WithInsecureBoot())
+	}
+	client, err := bootstrap.New(chassis, nil, opts...)
+	if err != nil {
+		return fail("setup", err)
+	}
+
+	var conn *grpc.ClientConn
+	t := time.Now()
+	dialCtx, cancel := context.WithTimeout(ctx, s.dialTimeout)
+	conn, err = grpc.DialContext(dialCtx, s.server, grpc.WithTransportCredentials(s.creds), grpc.WithBlock())
+	cancel()
+	res.latencies[phaseConnect] = time.Since(t)
+	if err != nil {
+		return fail(phaseConnect, err)
+	}
+	defer conn.Close()
+	bc := bpb.NewBootstrapClient(conn)
+
+	var nonce string
+	if !p.insecure {
+		if nonce, err = bootstrap.NewNonce(); err != nil {
+			return fail("setup", err)
+		}
+	}
+	var resp *bpb.GetBootstrapDataResponse
+	if err := timed(phaseFetch, func(ctx context.Context) error {
+		resp, err = client.Fetch(ctx, bc, nonce)
+		return err
+	}); err != nil {
+		return fail(phaseFetch, err)
+	}
+	if p.failure == failureUnknownDevice {
+		res.outcome = "unknown device accepted"
+		return res
+	}
+	if !p.insecure {
+		if err := timed(phaseVerify, func(context.Context) error {
+			return client.Verify(resp, nonce)
+		}); err != nil {
+			return fail(phaseVerify, err)
+		}
+	}
+	if p.failure == failureAbandon {
+		res.outcome, res.expected = string(failureAbandon), true
+		return res
+	}
+
+	reportStatus, message := bpb.ReportStatusRequest_BOOTSTRAP_STATUS_SUCCESS, "Bootstrap Success"
+	if p.failure == failureReportFailure {
+		reportStatus, message = bpb.ReportStatusRequest_BOOTSTRAP_STATUS_FAILURE, "injected failure"
+	}
+	before := map[string]bpb.ControlCardState_ControlCardStatus{}
+	for serial, state := range chassis.States {
+		before[serial] = state
+	}
+	if err := timed(phaseReport, func(ctx context.Context) error {
+		return client.Report(ctx, bc, reportStatus, message)
+	}); err != nil {
+		return fail(phaseReport, err)
+	}
+	for _, serial := range chassis.CardSerials() {
+		res.transitions = append(res.transitions, fmt.Sprintf("%v -> %v", stateName(before[serial]), stateName(chassis.States[serial])))
+	}
+	res.outcome, res.expected = "success", true
+	if p.failure == failureReportFailure {
+		res.outcome = string(failureReportFailure)
+	}
+	return res
+}
+
+// stateName returns the control card state without the CONTROL_CARD_STATUS_ prefix.
+func stateName(s bpb.ControlCardState_ControlCardStatus) string {
+	return strings.TrimPrefix(s.String(), "CONTROL_CARD_STATUS_")
+}
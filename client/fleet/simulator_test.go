@@ -0,0 +1,184 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/h-fam/errdiff"
+	"github.com/openconfig/bootz/server/entitymanager"
+	"github.com/openconfig/bootz/server/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	bpb "github.com/openconfig/bootz/proto/bootz"
+)
+
+const testInventory = "../../testdata/inventory.prototxt"
+
+// startBootzServer starts a Bootz server for the test inventory and returns its address.
+func startBootzServer(t *testing.T) string {
+	t.Helper()
+	em, err := entitymanager.New(testInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	bpb.RegisterBootstrapServer(srv, service.New(em))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestSimulator(t *testing.T) {
+	addr := startBootzServer(t)
+	identities, err := readInventory(testInventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendorCA, err := os.ReadFile("../../testdata/vendorca_pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendorCAs := x509.NewCertPool()
+	vendorCAs.AppendCertsFromPEM(vendorCA)
+
+	tests := []struct {
+		desc             string
+		insecureFraction float64
+		failures         []failureRate
+		wantOutcomes     map[string]int
+		wantUnexpected   int
+		wantTransitions  map[string]int
+	}{{
+		desc:            "Secure devices",
+		wantOutcomes:    map[string]int{"success": 10},
+		wantTransitions: map[string]int{"NOT_INITIALIZED -> INITIALIZED": 20},
+	}, {
+		desc:             "Insecure devices",
+		insecureFraction: 1,
+		wantOutcomes:     map[string]int{"success": 10},
+		wantTransitions:  map[string]int{"NOT_INITIALIZED -> INITIALIZED": 20},
+	}, {
+		desc:            "Injected failure reports",
+		failures:        []failureRate{{failure: failureReportFailure, fraction: 1}},
+		wantOutcomes:    map[string]int{"report_failure": 10},
+		wantTransitions: map[string]int{"NOT_INITIALIZED -> NOT_INITIALIZED": 20},
+	}, {
+		desc:         "Abandoned bootstraps",
+		failures:     []failureRate{{failure: failureAbandon, fraction: 1}},
+		wantOutcomes: map[string]int{"abandon": 10},
+	}, {
+		desc:         "Unknown devices",
+		failures:     []failureRate{{failure: failureUnknownDevice, fraction: 1}},
+		wantOutcomes: map[string]int{"fetch error: InvalidArgument": 10},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			sim := &simulator{
+				server:           addr,
+				identities:       identities,
+				devices:          10,
+				arrivalRate:      1000,
+				concurrency:      4,
+				insecureFraction: test.insecureFraction,
+				failures:         test.failures,
+				vendorCAs:        vendorCAs,
+				creds:            insecure.NewCredentials(),
+				dialTimeout:      5 * time.Second,
+				requestTimeout:   5 * time.Second,
+				rand:             rand.New(rand.NewSource(1)),
+			}
+			r := sim.run(context.Background())
+			if diff := cmp.Diff(test.wantOutcomes, r.outcomes); diff != "" {
+				t.Errorf("run() outcomes diff (-want +got):\n%s", diff)
+			}
+			if r.unexpected != test.wantUnexpected {
+				t.Errorf("run() unexpected = %d, want %d", r.unexpected, test.wantUnexpected)
+			}
+			if diff := cmp.Diff(test.wantTransitions, r.transitions, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("run() transitions diff (-want +got):\n%s", diff)
+			}
+			if got := len(r.latencies[phaseTotal]); got != 10 {
+				t.Errorf("run() recorded %d total latencies, want 10", got)
+			}
+			var out bytes.Buffer
+			r.print(&out)
+			for _, want := range []string{"Devices: 10", "Latency:", "total"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("print() = %q, want it to contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFailureMix(t *testing.T) {
+	tests := []struct {
+		desc    string
+		in      string
+		want    []failureRate
+		wantErr string
+	}{{
+		desc: "Mix",
+		in:   "abandon=0.1, report_failure=0.2",
+		want: []failureRate{{failure: failureAbandon, fraction: 0.1}, {failure: failureReportFailure, fraction: 0.2}},
+	}, {
+		desc:    "Unknown failure",
+		in:      "explode=0.1",
+		wantErr: "unknown failure",
+	}, {
+		desc:    "Too many failures",
+		in:      "abandon=0.6,unknown_device=0.6",
+		wantErr: "more than 1",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := parseFailureMix(test.in)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("parseFailureMix() %s", s)
+			}
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(failureRate{})); diff != "" {
+				t.Errorf("parseFailureMix() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{0.5: 50 * time.Millisecond, 0.9: 90 * time.Millisecond, 0.99: 99 * time.Millisecond, 1: 100 * time.Millisecond} {
+		if got := percentile(latencies, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hashalg is a registry of the hash algorithms supported for image digests,
+// e.g. in SoftwareImage.hash_algorithm.
+package hashalg
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Canonical algorithm names.
+const (
+	SHA256   = "SHA256"
+	SHA384   = "SHA384"
+	SHA512   = "SHA512"
+	SHA3_256 = "SHA3-256"
+	SHA3_384 = "SHA3-384"
+	SHA3_512 = "SHA3-512"
+)
+
+// Algorithm is a supported hash algorithm.
+type Algorithm struct {
+	// Name is the canonical name of the algorithm.
+	Name string
+	// Aliases are other names the algorithm is known by.
+	Aliases []string
+	// New returns a new hash.
+	New func() hash.Hash
+	// Size is the length of a digest in bytes.
+	Size int
+}
+
+var (
+	algorithms = []*Algorithm{
+		{Name: SHA256, Aliases: []string{"SHA-256", "SHA2-256"}, New: sha256.New, Size: sha256.Size},
+		{Name: SHA384, Aliases: []string{"SHA-384", "SHA2-384"}, New: sha512.New384, Size: sha512.Size384},
+		{Name: SHA512, Aliases: []string{"SHA-512", "SHA2-512"}, New: sha512.New, Size: sha512.Size},
+		{Name: SHA3_256, Aliases: []string{"SHA3_256"}, New: sha3.New256, Size: 32},
+		{Name: SHA3_384, Aliases: []string{"SHA3_384"}, New: sha3.New384, Size: 48},
+		{Name: SHA3_512, Aliases: []string{"SHA3_512"}, New: sha3.New512, Size: 64},
+	}
+	// byName maps upper case canonical names and aliases to algorithms.
+	byName = map[string]*Algorithm{}
+)
+
+func init() {
+	for _, a := range algorithms {
+		byName[a.Name] = a
+		for _, alias := range a.Aliases {
+			byName[alias] = a
+		}
+	}
+}
+
+// Lookup returns the algorithm with the given canonical name or alias. Names are case insensitive.
+func Lookup(name string) (*Algorithm, error) {
+	a, ok := byName[strings.ToUpper(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q, supported algorithms are %v", name, strings.Join(Supported(), ", "))
+	}
+	return a, nil
+}
+
+// Supported returns the canonical names of the supported algorithms.
+func Supported() []string {
+	var names []string
+	for _, a := range algorithms {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// DecodeDigest decodes a hex encoded digest and checks that it has the length of the algorithm's digests.
+func (a *Algorithm) DecodeDigest(digest string) ([]byte, error) {
+	b, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("digest %q is not hex encoded: %v", digest, err)
+	}
+	if len(b) != a.Size {
+		return nil, fmt.Errorf("%v digest must be %d bytes, got %d", a.Name, a.Size, len(b))
+	}
+	return b, nil
+}
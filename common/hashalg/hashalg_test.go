@@ -0,0 +1,120 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashalg
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/h-fam/errdiff"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "SHA256", want: SHA256},
+		{name: "sha256", want: SHA256},
+		{name: "SHA-256", want: SHA256},
+		{name: " sha2-384 ", want: SHA384},
+		{name: "sha-512", want: SHA512},
+		{name: "SHA3-256", want: SHA3_256},
+		{name: "sha3_384", want: SHA3_384},
+		{name: "Sha3-512", want: SHA3_512},
+		{name: "MD5", wantErr: "unsupported hash algorithm"},
+		{name: "", wantErr: "unsupported hash algorithm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Lookup(test.name)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("Lookup(%q) %s", test.name, s)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != test.want {
+				t.Errorf("Lookup(%q) = %v, want %v", test.name, got.Name, test.want)
+			}
+		})
+	}
+}
+
+// TestAlgorithms checks each algorithm against the digest of "abc" from its specification.
+func TestAlgorithms(t *testing.T) {
+	want := map[string]string{
+		SHA256:   "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		SHA384:   "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7",
+		SHA512:   "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		SHA3_256: "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+		SHA3_384: "ec01498288516fc926459f58e2c6ad8df9b473cb0fc08c2596da7cf0e49be4b298d88cea927ac7f539f1edf228376d25",
+		SHA3_512: "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0",
+	}
+	if len(want) != len(Supported()) {
+		t.Fatalf("Supported() = %v, want test vectors for every algorithm", Supported())
+	}
+	for _, name := range Supported() {
+		a, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := a.New()
+		h.Write([]byte("abc"))
+		got := h.Sum(nil)
+		if hex.EncodeToString(got) != want[name] {
+			t.Errorf("%v(abc) = %x, want %v", name, got, want[name])
+		}
+		if len(got) != a.Size {
+			t.Errorf("%v digest is %d bytes, want Size %d", name, len(got), a.Size)
+		}
+	}
+}
+
+func TestDecodeDigest(t *testing.T) {
+	a, err := Lookup(SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc    string
+		digest  string
+		wantErr string
+	}{{
+		desc:   "Valid",
+		digest: strings.Repeat("ab", 48),
+	}, {
+		desc:   "Upper case",
+		digest: strings.Repeat("AB", 48),
+	}, {
+		desc:    "Too short",
+		digest:  strings.Repeat("ab", 32),
+		wantErr: "must be 48 bytes",
+	}, {
+		desc:    "Not hex",
+		digest:  "xyz",
+		wantErr: "not hex encoded",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := a.DecodeDigest(test.digest)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Errorf("DecodeDigest(%q) %s", test.digest, s)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@
 package ownershipvoucher
 
 import (
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
@@ -28,6 +29,16 @@ import (
 
 const (
 	ovExpiry = time.Hour * 24 * 365
+	// legacyTimeFormat is the layout produced by time.Time.String(), which older
+	// versions of this package used for the created-on and expires-on leaves.
+	legacyTimeFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
+)
+
+// Assertion values defined by RFC 8366 section 5.3.
+const (
+	AssertionVerified  = "verified"
+	AssertionLogged    = "logged"
+	AssertionProximity = "proximity"
 )
 
 // OwnershipVoucher wraps Inner.
@@ -36,13 +47,83 @@ type OwnershipVoucher struct {
 }
 
 // Inner defines the Ownership Voucher format. See https://www.rfc-editor.org/rfc/rfc8366.html.
+// Binary leaves are carried as base64 strings in JSON, which encoding/json does for []byte fields.
 type Inner struct {
-	CreatedOn                  string `json:"created-on"`
-	ExpiresOn                  string `json:"expires-on"`
-	SerialNumber               string `json:"serial-number"`
-	Assertion                  string `json:"assertion"`
-	PinnedDomainCert           string `json:"pinned-domain-cert"`
-	DomainCertRevocationChecks bool   `json:"domain-cert-revocation-checks"`
+	CreatedOn                        string `json:"created-on"`
+	ExpiresOn                        string `json:"expires-on,omitempty"`
+	SerialNumber                     string `json:"serial-number"`
+	Assertion                        string `json:"assertion"`
+	IDevIDIssuer                     []byte `json:"idevid-issuer,omitempty"`
+	PinnedDomainCert                 string `json:"pinned-domain-cert,omitempty"`
+	DomainCertRevocationChecks       bool   `json:"domain-cert-revocation-checks"`
+	Nonce                            []byte `json:"nonce,omitempty"`
+	LastRenewalDate                  string `json:"last-renewal-date,omitempty"`
+	PinnedDomainSubjectPublicKeyInfo []byte `json:"pinned-domain-subject-public-key-info,omitempty"`
+}
+
+// options holds the settings applied by Option values.
+type options struct {
+	now              func() time.Time
+	expiry           time.Duration
+	assertion        string
+	nonce            []byte
+	idevidIssuer     []byte
+	revocationChecks bool
+	legacyParsing    bool
+}
+
+// Option configures New and VerifyAndUnmarshal.
+type Option func(*options)
+
+// WithAssertion sets the assertion of a new voucher. Defaults to AssertionVerified.
+func WithAssertion(assertion string) Option {
+	return func(o *options) { o.assertion = assertion }
+}
+
+// WithExpiry sets how long a new voucher is valid for. A non-positive duration
+// omits expires-on, which RFC 8366 allows for nonceful vouchers.
+func WithExpiry(d time.Duration) Option {
+	return func(o *options) { o.expiry = d }
+}
+
+// WithNonce sets the nonce of a new voucher. When passed to VerifyAndUnmarshal,
+// the voucher must carry exactly this nonce.
+func WithNonce(nonce []byte) Option {
+	return func(o *options) { o.nonce = nonce }
+}
+
+// WithIDevIDIssuer sets the idevid-issuer of a new voucher.
+func WithIDevIDIssuer(issuer []byte) Option {
+	return func(o *options) { o.idevidIssuer = issuer }
+}
+
+// WithDomainCertRevocationChecks sets domain-cert-revocation-checks in a new voucher.
+func WithDomainCertRevocationChecks(enabled bool) Option {
+	return func(o *options) { o.revocationChecks = enabled }
+}
+
+// WithTime overrides the clock used to stamp new vouchers and to check the validity window.
+func WithTime(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithLegacyParsing makes VerifyAndUnmarshal accept timestamps written by time.Time.String()
+// in addition to RFC 3339, and an empty assertion, so vouchers issued by older versions of
+// this package still load.
+func WithLegacyParsing() Option {
+	return func(o *options) { o.legacyParsing = true }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		now:       time.Now,
+		expiry:    ovExpiry,
+		assertion: AssertionVerified,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
 }
 
 // RemovePemHeaders strips the PEM headers from a certificate so it can be used in an Ownership Voucher.
@@ -52,9 +133,72 @@ func RemovePemHeaders(pemBlock string) string {
 	return pemBlock
 }
 
+// parseTimestamp parses a yang:date-and-time value, which is RFC 3339.
+func parseTimestamp(s string, legacy bool) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil || !legacy {
+		return t, err
+	}
+	// time.Time.String() appends the monotonic clock reading, e.g. " m=+0.93".
+	if i := strings.Index(s, " m="); i != -1 {
+		s = s[:i]
+	}
+	t, legacyErr := time.Parse(legacyTimeFormat, s)
+	if legacyErr != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+// validate checks the mandatory leaves and the validity window of the voucher.
+func (i *Inner) validate(o *options) error {
+	if i.SerialNumber == "" {
+		return fmt.Errorf("serial-number is missing")
+	}
+	switch i.Assertion {
+	case AssertionVerified, AssertionLogged, AssertionProximity:
+	case "":
+		if !o.legacyParsing {
+			return fmt.Errorf("assertion is missing")
+		}
+	default:
+		return fmt.Errorf("unknown assertion %q", i.Assertion)
+	}
+	if i.PinnedDomainCert == "" && len(i.PinnedDomainSubjectPublicKeyInfo) == 0 {
+		return fmt.Errorf("one of pinned-domain-cert or pinned-domain-subject-public-key-info must be set")
+	}
+	now := o.now()
+	createdOn, err := parseTimestamp(i.CreatedOn, o.legacyParsing)
+	if err != nil {
+		return fmt.Errorf("invalid created-on %q: %v", i.CreatedOn, err)
+	}
+	if now.Before(createdOn) {
+		return fmt.Errorf("voucher is not valid until %v", createdOn)
+	}
+	if i.ExpiresOn != "" {
+		expiresOn, err := parseTimestamp(i.ExpiresOn, o.legacyParsing)
+		if err != nil {
+			return fmt.Errorf("invalid expires-on %q: %v", i.ExpiresOn, err)
+		}
+		if !now.Before(expiresOn) {
+			return fmt.Errorf("voucher expired on %v", expiresOn)
+		}
+	}
+	if i.LastRenewalDate != "" {
+		if _, err := parseTimestamp(i.LastRenewalDate, o.legacyParsing); err != nil {
+			return fmt.Errorf("invalid last-renewal-date %q: %v", i.LastRenewalDate, err)
+		}
+	}
+	if o.nonce != nil && !bytes.Equal(i.Nonce, o.nonce) {
+		return fmt.Errorf("voucher nonce does not match")
+	}
+	return nil
+}
+
 // VerifyAndUnmarshal unmarshals the contents of an Ownership Voucher
 // and verifies that it has been signed by a signer in the given cert pool.
-func VerifyAndUnmarshal(in []byte, certPool *x509.CertPool) (*OwnershipVoucher, error) {
+// It also checks that the voucher is within its validity window.
+func VerifyAndUnmarshal(in []byte, certPool *x509.CertPool, opts ...Option) (*OwnershipVoucher, error) {
 	if len(in) == 0 {
 		return nil, fmt.Errorf("ownership voucher is empty")
 	}
@@ -67,23 +211,34 @@ func VerifyAndUnmarshal(in []byte, certPool *x509.CertPool) (*OwnershipVoucher,
 	if err != nil {
 		return nil, fmt.Errorf("failed unmarshaling ownership voucher: %v", err)
 	}
-	if err = p7.VerifyWithChain(certPool); err != nil {
+	o := newOptions(opts)
+	if err = p7.VerifyWithChainAtTime(certPool, o.now()); err != nil {
 		return nil, fmt.Errorf("failed to verify OV: %v", err)
 	}
+	if err := ov.OV.validate(o); err != nil {
+		return nil, fmt.Errorf("invalid OV: %v", err)
+	}
 	return &ov, nil
 }
 
 // New generates an Ownership Voucher which is signed by the vendor's CA.
-func New(serial string, pdcPem []byte, vendorCACert *x509.Certificate, vendorCAPriv *rsa.PrivateKey) ([]byte, error) {
-	currentTime := time.Now()
+func New(serial string, pdcPem []byte, vendorCACert *x509.Certificate, vendorCAPriv *rsa.PrivateKey, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	currentTime := o.now().UTC()
 	ov := OwnershipVoucher{
 		OV: Inner{
-			CreatedOn:        currentTime.String(),
-			ExpiresOn:        currentTime.Add(ovExpiry).String(),
-			SerialNumber:     serial,
-			PinnedDomainCert: RemovePemHeaders(string(pdcPem)),
+			CreatedOn:                  currentTime.Format(time.RFC3339),
+			SerialNumber:               serial,
+			Assertion:                  o.assertion,
+			IDevIDIssuer:               o.idevidIssuer,
+			PinnedDomainCert:           RemovePemHeaders(string(pdcPem)),
+			DomainCertRevocationChecks: o.revocationChecks,
+			Nonce:                      o.nonce,
 		},
 	}
+	if o.expiry > 0 {
+		ov.OV.ExpiresOn = currentTime.Add(o.expiry).Format(time.RFC3339)
+	}
 
 	ovBytes, err := json.Marshal(ov)
 	if err != nil {
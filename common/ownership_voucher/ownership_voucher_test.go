@@ -15,18 +15,27 @@
 package ownershipvoucher
 
 import (
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+	"go.mozilla.org/pkcs7"
 
 	_ "embed"
 )
 
 var (
 	wantSerial = "123A"
+	// testOV was issued before RFC 3339 timestamps were adopted and is only
+	// valid between 2023-08-09 and 2024-08-08.
 	//go:embed testdata/ov_123A.txt
-	testOV string
+	testOV     string
+	testOVTime = time.Date(2023, time.September, 1, 0, 0, 0, 0, time.UTC)
 	//go:embed testdata/pdc_pub.pem
 	pdcPub []byte
 	//go:embed testdata/vendorca_pub.pem
@@ -35,8 +44,8 @@ var (
 	vendorCAPriv []byte
 )
 
-// Tests that a new OV can be created and it can be unpacked and verified.
-func TestNew(t *testing.T) {
+func vendorCA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
 	pubPEM, _ := pem.Decode(vendorCAPub)
 	if pubPEM == nil {
 		t.Fatal("could not decode Vendor CA Public key")
@@ -53,8 +62,46 @@ func TestNew(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	return pubCert, privKey
+}
+
+// signInner signs an arbitrary voucher body with the vendor CA.
+func signInner(t *testing.T, inner Inner) []byte {
+	t.Helper()
+	pubCert, privKey := vendorCA(t)
+	ovBytes, err := json.Marshal(OwnershipVoucher{OV: inner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedMessage, err := pkcs7.NewSignedData(ovBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedMessage.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	signedMessage.SetEncryptionAlgorithm(pkcs7.OIDEncryptionAlgorithmRSA)
+	if err := signedMessage.AddSigner(pubCert, privKey, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := signedMessage.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
 
-	got, err := New(wantSerial, pdcPub, pubCert, privKey)
+// Tests that a new OV can be created and it can be unpacked and verified.
+func TestNew(t *testing.T) {
+	pubCert, privKey := vendorCA(t)
+	issued := time.Date(2025, time.January, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time { return issued }
+	nonce := []byte("some-nonce")
+
+	got, err := New(wantSerial, pdcPub, pubCert, privKey,
+		WithTime(clock),
+		WithExpiry(time.Hour),
+		WithAssertion(AssertionProximity),
+		WithNonce(nonce),
+		WithDomainCertRevocationChecks(true))
 	if err != nil {
 		t.Errorf("New err = %v, want nil", err)
 	}
@@ -64,9 +111,93 @@ func TestNew(t *testing.T) {
 		t.Fatalf("unable to add vendor root CA to pool")
 	}
 
-	_, err = VerifyAndUnmarshal(got, vendorCAPool)
+	ov, err := VerifyAndUnmarshal(got, vendorCAPool, WithTime(clock), WithNonce(nonce))
 	if err != nil {
-		t.Errorf("VerifyAndUnmarshal err = %v, want nil", err)
+		t.Fatalf("VerifyAndUnmarshal err = %v, want nil", err)
+	}
+	if got, want := ov.OV.CreatedOn, "2025-01-02T03:04:05Z"; got != want {
+		t.Errorf("got created-on = %v, want %v", got, want)
+	}
+	if got, want := ov.OV.ExpiresOn, "2025-01-02T04:04:05Z"; got != want {
+		t.Errorf("got expires-on = %v, want %v", got, want)
+	}
+	if got, want := ov.OV.Assertion, AssertionProximity; got != want {
+		t.Errorf("got assertion = %v, want %v", got, want)
+	}
+	if got, want := string(ov.OV.Nonce), string(nonce); got != want {
+		t.Errorf("got nonce = %v, want %v", got, want)
+	}
+	if !ov.OV.DomainCertRevocationChecks {
+		t.Errorf("got domain-cert-revocation-checks = false, want true")
+	}
+}
+
+// Tests that VerifyAndUnmarshal enforces timestamps, the validity window and the nonce.
+func TestVerifyAndUnmarshalValidity(t *testing.T) {
+	vendorCAPool := x509.NewCertPool()
+	if !vendorCAPool.AppendCertsFromPEM(vendorCAPub) {
+		t.Fatalf("unable to add vendor root CA to pool")
+	}
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	valid := Inner{
+		CreatedOn:        "2025-01-01T00:00:00Z",
+		ExpiresOn:        "2026-01-01T00:00:00Z",
+		SerialNumber:     wantSerial,
+		Assertion:        AssertionVerified,
+		PinnedDomainCert: RemovePemHeaders(string(pdcPub)),
+	}
+	tests := []struct {
+		desc    string
+		modify  func(*Inner)
+		opts    []Option
+		wantErr string
+	}{{
+		desc: "valid",
+	}, {
+		desc:   "valid without expiry",
+		modify: func(i *Inner) { i.ExpiresOn = "" },
+	}, {
+		desc:    "expired",
+		modify:  func(i *Inner) { i.ExpiresOn = "2025-05-01T00:00:00Z" },
+		wantErr: "expired",
+	}, {
+		desc:    "not yet valid",
+		modify:  func(i *Inner) { i.CreatedOn = "2025-07-01T00:00:00Z" },
+		wantErr: "not valid until",
+	}, {
+		desc:    "legacy timestamp rejected",
+		modify:  func(i *Inner) { i.CreatedOn = "2025-01-01 00:00:00.1 +0000 UTC m=+0.93" },
+		wantErr: "invalid created-on",
+	}, {
+		desc:   "legacy timestamp accepted with option",
+		modify: func(i *Inner) { i.CreatedOn = "2025-01-01 00:00:00.1 +0000 UTC m=+0.93" },
+		opts:   []Option{WithLegacyParsing()},
+	}, {
+		desc:    "unknown assertion",
+		modify:  func(i *Inner) { i.Assertion = "trusted" },
+		wantErr: "unknown assertion",
+	}, {
+		desc:    "missing pinned domain cert",
+		modify:  func(i *Inner) { i.PinnedDomainCert = "" },
+		wantErr: "pinned-domain-cert",
+	}, {
+		desc:    "nonce mismatch",
+		modify:  func(i *Inner) { i.Nonce = []byte("other") },
+		opts:    []Option{WithNonce([]byte("expected"))},
+		wantErr: "nonce does not match",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			inner := valid
+			if test.modify != nil {
+				test.modify(&inner)
+			}
+			opts := append([]Option{WithTime(func() time.Time { return now })}, test.opts...)
+			_, err := VerifyAndUnmarshal(signInner(t, inner), vendorCAPool, opts...)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Errorf("VerifyAndUnmarshal() %s", s)
+			}
+		})
 	}
 }
 
@@ -81,9 +212,12 @@ func TestVerifyAndUnmarshal(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unable to decode ownership voucher to bytes: %v", err)
 	}
-	got, err := VerifyAndUnmarshal(decodedOV, vendorCAPool)
+	if _, err := VerifyAndUnmarshal(decodedOV, vendorCAPool); err == nil {
+		t.Errorf("VerifyAndUnmarshal of a legacy OV without WithLegacyParsing err = nil, want error")
+	}
+	got, err := VerifyAndUnmarshal(decodedOV, vendorCAPool, WithLegacyParsing(), WithTime(func() time.Time { return testOVTime }))
 	if err != nil {
-		t.Errorf("VerifyAndUnmarshal err = %v, want nil", err)
+		t.Fatalf("VerifyAndUnmarshal err = %v, want nil", err)
 	}
 	if gotPDC, wantPDC := got.OV.PinnedDomainCert, RemovePemHeaders(string(pdcPub)); gotPDC != wantPDC {
 		t.Errorf("got PDC = %v, want %v", gotPDC, wantPDC)
@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revocation
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// CRLPath is the path the Responder serves its CRL on.
+	CRLPath = "/crl"
+	// validity is how long responses and CRLs issued by the Responder are valid for.
+	validity = time.Hour
+)
+
+// Responder is a local stand-in for a CA's OCSP responder and CRL
+// distribution point. It answers for certificates issued by a single CA and
+// reports every certificate as good unless it has been revoked.
+type Responder struct {
+	issuer *x509.Certificate
+	key    crypto.Signer
+	mu     sync.Mutex
+	// revoked maps the serial number of a revoked certificate to its revocation time.
+	revoked   map[string]time.Time
+	crlNumber int64
+	now       func() time.Time
+}
+
+// NewResponder returns a responder which signs with the key of issuer.
+// As an http.Handler it serves the CRL on CRLPath and answers OCSP requests on any other path.
+func NewResponder(issuer *x509.Certificate, key crypto.Signer) *Responder {
+	return &Responder{
+		issuer:  issuer,
+		key:     key,
+		revoked: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// Revoke marks the certificate with the given serial number as revoked.
+func (r *Responder) Revoke(serial *big.Int) *Responder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[serial.String()] = r.now()
+	return r
+}
+
+// CRL returns a freshly signed DER-encoded CRL listing every revoked certificate.
+func (r *Responder) CRL() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.crlNumber++
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(r.crlNumber),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	}
+	for serial, at := range r.revoked {
+		n, _ := new(big.Int).SetString(serial, 10)
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   n,
+			RevocationTime: at,
+		})
+	}
+	return x509.CreateRevocationList(rand.Reader, tmpl, r.issuer, r.key)
+}
+
+// OCSPResponse returns a signed DER-encoded OCSP response for the certificate with the given serial number.
+func (r *Responder) OCSPResponse(serial *big.Int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	tmpl := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+	}
+	if at, ok := r.revoked[serial.String()]; ok {
+		tmpl.Status = ocsp.Revoked
+		tmpl.RevokedAt = at
+		tmpl.RevocationReason = ocsp.Unspecified
+	}
+	return ocsp.CreateResponse(r.issuer, r.issuer, tmpl, r.key)
+}
+
+// ServeHTTP implements http.Handler. OCSP requests are accepted both as POST
+// bodies and base64-encoded in the path of a GET, see RFC 6960 appendix A.1.
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var (
+		der []byte
+		err error
+	)
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == CRLPath:
+		crl, err := r.CRL()
+		if err != nil {
+			log.Errorf("Unable to create CRL: %v", err)
+			http.Error(w, "unable to create CRL", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", CRLContentType)
+		w.Write(crl)
+		return
+	case req.Method == http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(req.Body, maxResponseSize))
+	case req.Method == http.MethodGet:
+		der, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(req.URL.Path, "/"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to read OCSP request", http.StatusBadRequest)
+		return
+	}
+	ocspReq, err := ocsp.ParseRequest(der)
+	if err != nil {
+		http.Error(w, "malformed OCSP request", http.StatusBadRequest)
+		return
+	}
+	resp, err := r.OCSPResponse(ocspReq.SerialNumber)
+	if err != nil {
+		log.Errorf("Unable to create OCSP response: %v", err)
+		http.Error(w, "unable to create OCSP response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", OCSPResponseContentType)
+	w.Write(resp)
+}
@@ -0,0 +1,361 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revocation checks certificate chains against CRLs and OCSP responses.
+//
+// Revocation information can be supplied up front (stapled), e.g. in a bootstrap
+// response, or fetched from the CRL distribution points and OCSP responders
+// listed in the certificates.
+package revocation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// OCSPRequestContentType is the media type of an OCSP request, see RFC 6960 appendix A.
+	OCSPRequestContentType = "application/ocsp-request"
+	// OCSPResponseContentType is the media type of an OCSP response.
+	OCSPResponseContentType = "application/ocsp-response"
+	// CRLContentType is the media type of a DER-encoded CRL, see RFC 5280 section 4.2.1.13.
+	CRLContentType = "application/pkix-crl"
+	// maxResponseSize limits the size of a fetched CRL or OCSP response.
+	maxResponseSize = 10 * 1024 * 1024
+)
+
+// Info holds DER-encoded revocation information for a certificate chain.
+type Info struct {
+	CRLs          [][]byte
+	OCSPResponses [][]byte
+}
+
+// options holds the settings applied by Option values.
+type options struct {
+	now        func() time.Time
+	httpClient *http.Client
+	fetch      bool
+}
+
+// Option configures Check and Fetch.
+type Option func(*options)
+
+// WithTime overrides the clock used to check the freshness of revocation information.
+func WithTime(now func() time.Time) Option {
+	return func(o *options) { o.now = now }
+}
+
+// WithHTTPClient sets the client used to fetch CRLs and OCSP responses.
+// Defaults to a client with a 10 second timeout.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+// WithFetching sets whether Check may fetch revocation information that was
+// not stapled. Defaults to true.
+func WithFetching(enabled bool) Option {
+	return func(o *options) { o.fetch = enabled }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		now:        time.Now,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		fetch:      true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Check verifies that no certificate in chain has been revoked. The chain
+// must be ordered from the leaf to the trust anchor, as returned by
+// x509.Certificate.Verify. The trust anchor itself is not checked.
+// Stapled information is preferred; anything missing is fetched unless
+// fetching has been disabled. A certificate whose status cannot be
+// determined is treated as an error.
+func Check(chain []*x509.Certificate, stapled *Info, opts ...Option) error {
+	o := newOptions(opts)
+	if stapled == nil {
+		stapled = &Info{}
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		if err := checkCert(chain[i], chain[i+1], stapled, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch retrieves revocation information for every certificate in chain
+// except the trust anchor, so that it can be stapled to a response.
+// OCSP is preferred over CRLs for each certificate.
+func Fetch(chain []*x509.Certificate, opts ...Option) (*Info, error) {
+	o := newOptions(opts)
+	info := &Info{}
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		der, err := fetchOCSP(cert, issuer, o)
+		if err == nil {
+			info.OCSPResponses = append(info.OCSPResponses, der)
+			continue
+		}
+		if len(cert.CRLDistributionPoints) == 0 {
+			return nil, err
+		}
+		der, err = fetchCRL(cert, o)
+		if err != nil {
+			return nil, err
+		}
+		info.CRLs = append(info.CRLs, der)
+	}
+	return info, nil
+}
+
+// errUnknown is returned by the evaluators when a piece of revocation
+// information does not cover the certificate.
+var errUnknown = fmt.Errorf("revocation status unknown")
+
+func checkCert(cert, issuer *x509.Certificate, stapled *Info, o *options) error {
+	now := o.now()
+	for _, der := range stapled.OCSPResponses {
+		if err := evalOCSP(der, cert, issuer, now); err != errUnknown {
+			return err
+		}
+	}
+	for _, der := range stapled.CRLs {
+		if err := evalCRL(der, cert, issuer, now); err != errUnknown {
+			return err
+		}
+	}
+	if !o.fetch {
+		return fmt.Errorf("no revocation information for certificate %q and fetching is disabled", cert.Subject)
+	}
+	lastErr := fmt.Errorf("certificate lists no OCSP responder or CRL distribution point")
+	if len(cert.OCSPServer) > 0 {
+		der, err := fetchOCSP(cert, issuer, o)
+		if err == nil {
+			if err = evalOCSP(der, cert, issuer, now); err != errUnknown {
+				return err
+			}
+		}
+		lastErr = err
+	}
+	if len(cert.CRLDistributionPoints) > 0 {
+		der, err := fetchCRL(cert, o)
+		if err == nil {
+			if err = evalCRL(der, cert, issuer, now); err != errUnknown {
+				return err
+			}
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("unable to determine revocation status of certificate %q: %v", cert.Subject, lastErr)
+}
+
+// evalOCSP returns nil if der is a current OCSP response that reports cert as
+// good, an error if it reports cert as revoked, and errUnknown otherwise.
+func evalOCSP(der []byte, cert, issuer *x509.Certificate, now time.Time) error {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return errUnknown
+	}
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && !now.Before(resp.NextUpdate)) {
+		log.Warningf("Ignoring stale OCSP response for certificate %q", cert.Subject)
+		return errUnknown
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("certificate %q was revoked at %v", cert.Subject, resp.RevokedAt)
+	}
+	return errUnknown
+}
+
+// evalCRL returns nil if der is a current CRL from issuer that does not list
+// cert, an error if it lists cert, and errUnknown otherwise.
+func evalCRL(der []byte, cert, issuer *x509.Certificate, now time.Time) error {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return errUnknown
+	}
+	if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+		return errUnknown
+	}
+	if now.Before(crl.ThisUpdate) || (!crl.NextUpdate.IsZero() && !now.Before(crl.NextUpdate)) {
+		log.Warningf("Ignoring stale CRL for certificate %q", cert.Subject)
+		return errUnknown
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("certificate %q was revoked at %v", cert.Subject, entry.RevocationTime)
+		}
+	}
+	return nil
+}
+
+func fetchOCSP(cert, issuer *x509.Certificate, o *options) ([]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate %q lists no OCSP responder", cert.Subject)
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OCSP request: %v", err)
+	}
+	var lastErr error
+	for _, url := range cert.OCSPServer {
+		der, err := post(o.httpClient, url, OCSPRequestContentType, req)
+		if err == nil {
+			return der, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func fetchCRL(cert *x509.Certificate, o *options) ([]byte, error) {
+	var lastErr error
+	for _, url := range cert.CRLDistributionPoints {
+		der, err := get(o.httpClient, url)
+		if err == nil {
+			return der, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func post(c *http.Client, url, contentType string, body []byte) ([]byte, error) {
+	resp, err := c.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to query %v: %v", url, err)
+	}
+	return readResponse(url, resp)
+}
+
+func get(c *http.Client, url string) ([]byte, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %v: %v", url, err)
+	}
+	return readResponse(url, resp)
+}
+
+func readResponse(url string, resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %v failed with status %v", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response from %v: %v", url, err)
+	}
+	return data, nil
+}
+
+// Stapler fetches revocation information for a certificate chain so that a
+// server can staple it to its responses. The information is cached until the
+// earliest next update of its CRLs and OCSP responses.
+type Stapler struct {
+	opts []Option
+
+	mu sync.Mutex
+	// chain identifies the chain the cached information is for.
+	chain  string
+	cached *Info
+	expiry time.Time
+}
+
+// NewStapler returns a Stapler which passes the options to Fetch.
+func NewStapler(opts ...Option) *Stapler {
+	return &Stapler{opts: opts}
+}
+
+// Staple returns revocation information for chain, see Fetch. The returned
+// Info may be shared with other callers and must not be modified.
+func (s *Stapler) Staple(chain []*x509.Certificate) (*Info, error) {
+	key := chainKey(chain)
+	now := newOptions(s.opts).now()
+	s.mu.Lock()
+	if s.cached != nil && s.chain == key && now.Before(s.expiry) {
+		info := s.cached
+		s.mu.Unlock()
+		return info, nil
+	}
+	s.mu.Unlock()
+
+	info, err := Fetch(chain, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	expiry, ok := nextUpdate(info)
+	if !ok {
+		return info, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chain, s.cached, s.expiry = key, info, expiry
+	return info, nil
+}
+
+// chainKey identifies a certificate chain.
+func chainKey(chain []*x509.Certificate) string {
+	var b bytes.Buffer
+	for _, c := range chain {
+		fmt.Fprintf(&b, "%d:", len(c.Raw))
+		b.Write(c.Raw)
+	}
+	return b.String()
+}
+
+// nextUpdate returns the earliest next update of the CRLs and OCSP responses
+// in info. It returns false if any of them cannot be parsed or has no next
+// update, in which case info must not be cached.
+func nextUpdate(info *Info) (time.Time, bool) {
+	var updates []time.Time
+	for _, der := range info.CRLs {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return time.Time{}, false
+		}
+		updates = append(updates, crl.NextUpdate)
+	}
+	for _, der := range info.OCSPResponses {
+		resp, err := ocsp.ParseResponse(der, nil)
+		if err != nil {
+			return time.Time{}, false
+		}
+		updates = append(updates, resp.NextUpdate)
+	}
+	var earliest time.Time
+	for _, u := range updates {
+		if u.IsZero() {
+			return time.Time{}, false
+		}
+		if earliest.IsZero() || u.Before(earliest) {
+			earliest = u
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
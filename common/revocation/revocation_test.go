@@ -0,0 +1,287 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revocation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+)
+
+type testPKI struct {
+	ca        *x509.Certificate
+	responder *Responder
+	url       string
+	// good and revoked list both an OCSP responder and a CRL distribution point.
+	good, revoked *x509.Certificate
+	// ocspOnly and crlOnly are revoked and list a single source.
+	ocspOnly, crlOnly *x509.Certificate
+}
+
+func newCert(t *testing.T, tmpl, parent *x509.Certificate, pub, priv any) *x509.Certificate {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PDC"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca := newCert(t, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+
+	p := &testPKI{ca: ca, responder: NewResponder(ca, caKey)}
+	ts := httptest.NewServer(p.responder)
+	t.Cleanup(ts.Close)
+	p.url = ts.URL
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := func(serial int64, name string, ocsp, crl bool) *x509.Certificate {
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(24 * time.Hour),
+		}
+		if ocsp {
+			tmpl.OCSPServer = []string{p.url}
+		}
+		if crl {
+			tmpl.CRLDistributionPoints = []string{p.url + CRLPath}
+		}
+		return newCert(t, tmpl, ca, &leafKey.PublicKey, caKey)
+	}
+	p.good = leaf(100, "good", true, true)
+	p.revoked = leaf(101, "revoked", true, true)
+	p.ocspOnly = leaf(102, "ocsp only", true, false)
+	p.crlOnly = leaf(103, "crl only", false, true)
+	for _, c := range []*x509.Certificate{p.revoked, p.ocspOnly, p.crlOnly} {
+		p.responder.Revoke(c.SerialNumber)
+	}
+	return p
+}
+
+func (p *testPKI) ocspResponse(t *testing.T, c *x509.Certificate) []byte {
+	t.Helper()
+	der, err := p.responder.OCSPResponse(c.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func (p *testPKI) crl(t *testing.T) []byte {
+	t.Helper()
+	der, err := p.responder.CRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCheck(t *testing.T) {
+	p := newTestPKI(t)
+	noFetch := []Option{WithFetching(false)}
+
+	tests := []struct {
+		desc    string
+		leaf    *x509.Certificate
+		stapled *Info
+		opts    []Option
+		wantErr string
+	}{{
+		desc:    "Stapled OCSP good",
+		leaf:    p.good,
+		stapled: &Info{OCSPResponses: [][]byte{p.ocspResponse(t, p.good)}},
+		opts:    noFetch,
+	}, {
+		desc:    "Stapled OCSP revoked",
+		leaf:    p.revoked,
+		stapled: &Info{OCSPResponses: [][]byte{p.ocspResponse(t, p.revoked)}},
+		opts:    noFetch,
+		wantErr: "was revoked",
+	}, {
+		desc:    "Stapled OCSP for another certificate",
+		leaf:    p.good,
+		stapled: &Info{OCSPResponses: [][]byte{p.ocspResponse(t, p.revoked)}},
+		opts:    noFetch,
+		wantErr: "fetching is disabled",
+	}, {
+		desc:    "Stale stapled OCSP",
+		leaf:    p.good,
+		stapled: &Info{OCSPResponses: [][]byte{p.ocspResponse(t, p.good)}},
+		opts:    []Option{WithFetching(false), WithTime(func() time.Time { return time.Now().Add(2 * time.Hour) })},
+		wantErr: "fetching is disabled",
+	}, {
+		desc:    "Stapled CRL good",
+		leaf:    p.good,
+		stapled: &Info{CRLs: [][]byte{p.crl(t)}},
+		opts:    noFetch,
+	}, {
+		desc:    "Stapled CRL revoked",
+		leaf:    p.revoked,
+		stapled: &Info{CRLs: [][]byte{p.crl(t)}},
+		opts:    noFetch,
+		wantErr: "was revoked",
+	}, {
+		desc:    "Nothing stapled and fetching disabled",
+		leaf:    p.good,
+		opts:    noFetch,
+		wantErr: "fetching is disabled",
+	}, {
+		desc: "Fetched good",
+		leaf: p.good,
+	}, {
+		desc:    "Fetched OCSP revoked",
+		leaf:    p.ocspOnly,
+		wantErr: "was revoked",
+	}, {
+		desc:    "Fetched CRL revoked",
+		leaf:    p.crlOnly,
+		wantErr: "was revoked",
+	}, {
+		desc:    "Stapled info takes precedence",
+		leaf:    p.revoked,
+		stapled: &Info{OCSPResponses: [][]byte{p.ocspResponse(t, p.good), p.ocspResponse(t, p.revoked)}},
+		wantErr: "was revoked",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := Check([]*x509.Certificate{test.leaf, p.ca}, test.stapled, test.opts...)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Errorf("Check() %s", s)
+			}
+		})
+	}
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	p := newTestPKI(t)
+	leaf := newCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(200),
+		Subject:      pkix.Name{CommonName: "unreachable"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		// Nothing listens on port 1.
+		OCSPServer: []string{"http://127.0.0.1:1"},
+	}, p.ca, p.ca.PublicKey, p.responder.key)
+	err := Check([]*x509.Certificate{leaf, p.ca}, nil)
+	if s := errdiff.Substring(err, "unable to determine revocation status"); s != "" {
+		t.Errorf("Check() %s", s)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	p := newTestPKI(t)
+	tests := []struct {
+		desc     string
+		leaf     *x509.Certificate
+		wantOCSP int
+		wantCRLs int
+		wantErr  string
+	}{{
+		desc:     "Prefers OCSP",
+		leaf:     p.good,
+		wantOCSP: 1,
+	}, {
+		desc:     "Falls back to CRL",
+		leaf:     p.crlOnly,
+		wantCRLs: 1,
+	}, {
+		desc:    "No sources",
+		leaf:    p.ca,
+		wantErr: "lists no OCSP responder",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			info, err := Fetch([]*x509.Certificate{test.leaf, p.ca})
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("Fetch() %s", s)
+			}
+			if err != nil {
+				return
+			}
+			if len(info.OCSPResponses) != test.wantOCSP || len(info.CRLs) != test.wantCRLs {
+				t.Errorf("Fetch() got %d OCSP responses and %d CRLs, want %d and %d", len(info.OCSPResponses), len(info.CRLs), test.wantOCSP, test.wantCRLs)
+			}
+		})
+	}
+}
+
+func TestStaplerCaches(t *testing.T) {
+	p := newTestPKI(t)
+	now := time.Now()
+	s := NewStapler(WithTime(func() time.Time { return now }))
+	chain := []*x509.Certificate{p.good, p.ca}
+
+	first, err := s.Staple(chain)
+	if err != nil {
+		t.Fatalf("Staple() err = %v, want nil", err)
+	}
+	got, err := s.Staple(chain)
+	if err != nil {
+		t.Fatalf("Staple() err = %v, want nil", err)
+	}
+	if got != first {
+		t.Errorf("Staple() refetched before the next update")
+	}
+
+	other, err := s.Staple([]*x509.Certificate{p.crlOnly, p.ca})
+	if err != nil {
+		t.Fatalf("Staple() err = %v, want nil", err)
+	}
+	if other == first || len(other.CRLs) != 1 {
+		t.Errorf("Staple() of another chain got %d CRLs, want a fresh staple with 1", len(other.CRLs))
+	}
+
+	now = now.Add(2 * time.Hour)
+	got, err = s.Staple([]*x509.Certificate{p.crlOnly, p.ca})
+	if err != nil {
+		t.Fatalf("Staple() err = %v, want nil", err)
+	}
+	if got == other {
+		t.Errorf("Staple() reused a staple past its next update")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// LeasesPath is the path the leases are served on by the admin handler.
+const LeasesPath = "/leases"
+
+// AdminHandler serves the state of the dhcp server over HTTP.
+//
+// A GET of LeasesPath returns the leases as a JSON list. The mac query parameter
+// restricts the list to the leases of a hardware address.
+type AdminHandler struct {
+	server *Server
+}
+
+// NewAdminHandler returns the admin handler of the dhcp server.
+func NewAdminHandler(s *Server) *AdminHandler {
+	return &AdminHandler{server: s}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != LeasesPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	leases := h.server.Leases()
+	if mac := r.URL.Query().Get("mac"); mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			http.Error(w, "invalid mac address", http.StatusBadRequest)
+			return
+		}
+		filtered := []*Lease{}
+		for _, l := range leases {
+			if strings.EqualFold(l.MAC, hw.String()) {
+				filtered = append(filtered, l)
+			}
+		}
+		leases = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(leases)
+}
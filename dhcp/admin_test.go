@@ -0,0 +1,114 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s, err := New(&Config{
+		Interfaces: []string{"eth0"},
+		Families:   []Family{IPv4},
+		Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+	offer4(t, s, net.ParseIP("192.0.2.1").To4())
+	offer4(t, s, net.ParseIP("192.0.2.1").To4(), dhcpv4.WithHwAddr(other))
+	ts := httptest.NewServer(NewAdminHandler(s))
+	defer ts.Close()
+
+	tests := []struct {
+		desc     string
+		method   string
+		path     string
+		wantCode int
+		// wantIPs are the addresses of the leases listed, in order.
+		wantIPs []string
+	}{{
+		desc:     "All leases",
+		method:   http.MethodGet,
+		path:     LeasesPath,
+		wantCode: http.StatusOK,
+		wantIPs:  []string{"192.0.2.100", "192.0.2.101"},
+	}, {
+		desc:     "Leases of a mac address",
+		method:   http.MethodGet,
+		path:     LeasesPath + "?mac=00-00-00-00-00-0B",
+		wantCode: http.StatusOK,
+		wantIPs:  []string{"192.0.2.101"},
+	}, {
+		desc:     "Mac address without leases",
+		method:   http.MethodGet,
+		path:     LeasesPath + "?mac=00:00:00:00:00:0c",
+		wantCode: http.StatusOK,
+		wantIPs:  []string{},
+	}, {
+		desc:     "Invalid mac address",
+		method:   http.MethodGet,
+		path:     LeasesPath + "?mac=router",
+		wantCode: http.StatusBadRequest,
+	}, {
+		desc:     "Not a GET",
+		method:   http.MethodPost,
+		path:     LeasesPath,
+		wantCode: http.StatusMethodNotAllowed,
+	}, {
+		desc:     "Unknown path",
+		method:   http.MethodGet,
+		path:     "/other",
+		wantCode: http.StatusNotFound,
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.wantCode {
+				t.Fatalf("%v %v status = %v, want %v", test.method, test.path, resp.StatusCode, test.wantCode)
+			}
+			if test.wantIPs == nil {
+				return
+			}
+			var leases []*Lease
+			if err := json.NewDecoder(resp.Body).Decode(&leases); err != nil {
+				t.Fatalf("unable to decode the leases: %v", err)
+			}
+			got := []string{}
+			for _, l := range leases {
+				got = append(got, l.IP)
+			}
+			if diff := cmp.Diff(test.wantIPs, got); diff != "" {
+				t.Errorf("%v %v leases diff (-want +got):\n%s", test.method, test.path, diff)
+			}
+		})
+	}
+}
@@ -17,194 +17,439 @@ package dhcp
 
 import (
 	"fmt"
-	"html/template"
 	"net"
-	"os"
+	"net/netip"
 	"strings"
 	"sync"
+	"time"
 
 	cdconfig "github.com/coredhcp/coredhcp/config"
-	cdplugins "github.com/coredhcp/coredhcp/plugins"
+	cdhandler "github.com/coredhcp/coredhcp/handler"
 	cdserver "github.com/coredhcp/coredhcp/server"
+	log "github.com/golang/glog"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 
-	plDNS "github.com/coredhcp/coredhcp/plugins/dns"
-	plleasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
-	plserverid "github.com/coredhcp/coredhcp/plugins/serverid"
 	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+	plleases "github.com/openconfig/bootz/dhcp/plugins/leases"
+	plpool "github.com/openconfig/bootz/dhcp/plugins/pool"
 	plslease "github.com/openconfig/bootz/dhcp/plugins/slease"
 )
 
-const confTemplate = `
-# CoreDHCP configuration (yaml)
-server6:
-   plugins:
-     - server_id: LL {{ .IntfMacAddr }}
-     {{ if .BootzURL }}
-     - bootz: {{ .BootzURL }}
-     {{ end }}
-     {{ if .DNSv6 }}
-     - DNS: {{ .DNSv6 }}
-     {{ end }}
-     {{ if .IPv6Leases }}
-     - slease: {{ .IPv6Leases }}
-     {{ end }}
-server4:
-  plugins:
-    - lease_time: 3600s
-    - server_id: {{ .IntfIPAddr }}
-    {{ if .BootzURL }}
-    - bootz: {{ .BootzURL }}
-    {{ end }}
-    {{ if .DNSv4 }}
-    - DNS: {{ .DNSv4 }}
-    {{ end }}
-    {{ if .IPv4Leases }}
-    - slease: {{ .IPv4Leases }}
-    {{ end }}
-`
-
-var desiredPlugins = []*cdplugins.Plugin{
-	&plserverid.Plugin,
-	&plleasetime.Plugin,
-	&plDNS.Plugin,
-	&plbootz.Plugin,
-	&plslease.Plugin,
-}
+// defaultLeaseTime is the lease time of DHCPv4 responses whose plugins did not set one.
+const defaultLeaseTime = time.Hour
+
+// Family is an address family served by the dhcp server.
+type Family string
+
+// Address families.
+const (
+	IPv4 Family = "ipv4"
+	IPv6 Family = "ipv6"
+)
 
 // Config contains the dhcp server configuration.
 type Config struct {
-	Interface  string
-	DNS        []string
+	// Interfaces are the network interfaces the server listens on.
+	Interfaces []string
+	// Families are the address families served on the interfaces, both if empty.
+	// Serving IPv4 needs an IPv4 address on every interface, the server
+	// identifier of the responses sent on it.
+	Families []Family
+	DNS      []string
+	// AddressMap holds the records keyed by mac address, serial number or relay
+	// identifier, see relay.CircuitIDKey and relay.RemoteIDKey. Relayed devices
+	// are matched by their relay identifiers first.
 	AddressMap map[string]*Entry
-	BootzURL   string
+	// Pools are the ranges addresses are assigned from to devices without an entry in AddressMap.
+	Pools []*Pool
+	// BootzURL is the bootstrap server URI advertised in the sZTP redirect options.
+	// Several URIs separated by spaces are advertised in priority order.
+	BootzURL string
+	// VendorClasses, if set, are the vendor classes of the devices the bootz
+	// server is advertised to, matched against the manufacturer or the start of
+	// the vendor class the devices send. Other devices still get an address.
+	VendorClasses []string
+	// LeaseFile is the file the leases are persisted to, so that they survive
+	// restarts. The leases are only kept in memory if it is empty.
+	LeaseFile string
 }
 
+// Pool is a range of addresses for devices without a static entry, e.g.
+// devices which are not in the inventory yet.
+type Pool struct {
+	// Start is the first address of the range with the prefix length of its subnet, e.g. 192.0.2.100/24.
+	Start string
+	// End is the last address of the range.
+	End string
+	// Gw is the gateway of IPv4 ranges.
+	Gw string
+	// LeaseTime is how long the addresses are leased for. Defaults to a short quarantine lease.
+	LeaseTime time.Duration
+	// Interface, if set, is the only interface the range is served on. Otherwise
+	// it is served on every interface. Either way, devices which are not relayed
+	// only get addresses from the ranges of the subnets of their interface.
+	Interface string
+}
+
+// Lease is an address handed out by the dhcp server.
+type Lease = plleases.Lease
+
 // Entry represents a dhcp record.
 type Entry struct {
+	// IP is the address of the device in CIDR notation. Without one, the device
+	// gets its address from the pools, with the options of the record.
 	IP string
 	Gw string
+	// BootzURL, if set, is advertised to the device instead of Config.BootzURL.
+	// Several URIs separated by spaces are advertised in priority order.
+	BootzURL string
+	// NTP are the ntp servers of the device, which it needs to check the validity
+	// of the ownership voucher and certificate: IPv4 addresses for IPv4 records,
+	// IPv6 addresses or names for IPv6 records.
+	NTP        []string
+	DomainName string
+	Hostname   string
+	// MTU is the MTU of the interface of the device. It is only sent to IPv4
+	// devices, as DHCPv6 has no MTU option.
+	MTU uint16
+	// LeaseTime is how long the address is leased for, an hour if zero.
+	LeaseTime time.Duration
+	// Options are sent to the device as is, keyed by their DHCPv4 code for IPv4
+	// records and their DHCPv6 code for IPv6 records.
+	Options map[uint16][]byte
 }
 
+// Server is a dhcp server. Each server has its own records, pools and leases,
+// so that several servers can run in the same process.
 type Server struct {
-	server *cdserver.Servers
-}
+	interfaces []string
+	v4, v6     bool
+	dns4, dns6 []net.IP
+	// redirect advertises the bootz server, if there is one.
+	redirect *plbootz.Redirect
+	// gate stops advertising the bootz server to other vendors, if set.
+	gate    *plbootz.Gate
+	records *plslease.Records
+	pools   *plpool.Pools
+	// poolInterfaces are the interfaces of the pools, in order.
+	poolInterfaces []string
+	leases         *plleases.Store
 
-var instance *Server = nil
-var lock = &sync.Mutex{}
+	mu sync.Mutex
+	// listeners serve the interfaces while the server runs.
+	listeners []*listener
+}
 
-// Start starts the dhcp server with the given configuration.
-func Start(conf *Config) error {
-	lock.Lock()
-	defer lock.Unlock()
+// New returns a dhcp server with the given configuration. It does not serve
+// requests until it is started.
+func New(conf *Config) (*Server, error) {
+	if len(conf.Interfaces) == 0 {
+		return nil, fmt.Errorf("no interface to serve")
+	}
+	s := &Server{
+		interfaces: conf.Interfaces,
+		records:    plslease.NewRecords(),
+	}
+	families := conf.Families
+	if len(families) == 0 {
+		families = []Family{IPv4, IPv6}
+	}
+	for _, f := range families {
+		switch f {
+		case IPv4:
+			s.v4 = true
+		case IPv6:
+			s.v6 = true
+		default:
+			return nil, fmt.Errorf("unknown address family %q", f)
+		}
+	}
 
-	if instance != nil {
-		return fmt.Errorf("dhcp server already started")
+	for _, v := range conf.DNS {
+		if v == "" {
+			continue
+		}
+		ip := net.ParseIP(v)
+		switch {
+		case ip == nil:
+			return nil, fmt.Errorf("invalid dns server %q", v)
+		case ip.To4() != nil:
+			s.dns4 = append(s.dns4, ip)
+		default:
+			s.dns6 = append(s.dns6, ip)
+		}
 	}
 
-	configFile, err := generateConfigFile(conf)
-	if err != nil {
-		return err
+	var err error
+	if conf.BootzURL != "" {
+		if s.redirect, err = plbootz.NewRedirect(strings.Fields(conf.BootzURL)...); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.VendorClasses) > 0 {
+		s.gate = plbootz.NewGate(conf.VendorClasses...)
+	}
+	for k, e := range conf.AddressMap {
+		if err := s.AddEntry(k, e); err != nil {
+			return nil, err
+		}
 	}
 
-	c, err := cdconfig.Load(configFile)
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %v", err)
+	var pools []string
+	for _, p := range conf.Pools {
+		if p.Interface != "" && !contains(conf.Interfaces, p.Interface) {
+			return nil, fmt.Errorf("pool %v-%v is on interface %v, which is not served", p.Start, p.End, p.Interface)
+		}
+		s.poolInterfaces = append(s.poolInterfaces, p.Interface)
+		var leaseTime string
+		if p.LeaseTime > 0 {
+			leaseTime = p.LeaseTime.String()
+		}
+		if isIPv6(p.Start) {
+			pools = append(pools, strings.TrimSuffix(fmt.Sprintf("%s,%s,%s", p.Start, p.End, leaseTime), ","))
+		} else {
+			pools = append(pools, strings.TrimSuffix(fmt.Sprintf("%s,%s,%s,%s", p.Start, p.End, p.Gw, leaseTime), ","))
+		}
+	}
+	if s.pools, err = plpool.New(pools...); err != nil {
+		return nil, err
 	}
 
-	for _, plugin := range desiredPlugins {
-		if err := cdplugins.RegisterPlugin(plugin); err != nil {
-			return fmt.Errorf("failed to register plugin '%s': %v", plugin.Name, err)
+	if s.leases, err = plleases.Open(conf.LeaseFile); err != nil {
+		return nil, err
+	}
+	// The devices keep the addresses the pools leased them before a restart.
+	now := time.Now()
+	for _, l := range s.leases.List() {
+		addr, err := netip.ParseAddr(l.IP)
+		if err != nil || l.State == plleases.StateReleased || !now.Before(l.Expires) {
+			continue
 		}
+		s.pools.Reserve(poolClient(l), addr, l.Expires)
 	}
+	return s, nil
+}
 
-	srv, err := cdserver.Start(c)
-	if err != nil {
-		return fmt.Errorf("error starting DHCP server: %v", err)
+// poolClient returns how the pools identify the client of the lease.
+func poolClient(l *Lease) string {
+	switch {
+	case l.DUID != "":
+		return l.DUID
+	case l.ClientID != "":
+		return "id:" + l.ClientID
 	}
-	os.Remove(configFile)
+	return l.MAC
+}
 
-	instance = &Server{
-		server: srv,
+// Start starts serving requests on the interfaces.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners != nil {
+		return fmt.Errorf("dhcp server already started")
+	}
+	var listeners []*listener
+	for _, name := range s.interfaces {
+		l, err := s.listen(name)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		listeners = append(listeners, l)
 	}
+	s.listeners = listeners
 	return nil
 }
 
-// Stop stops the DHCP server.
-func Stop() {
-	lock.Lock()
-	defer lock.Unlock()
-	if instance != nil {
-		instance.server.Close()
-		instance.server.Wait()
+// Stop stops serving requests, and saves the leases which are not saved yet.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	closeAll(s.listeners)
+	s.listeners = nil
+	if err := s.leases.Flush(); err != nil {
+		log.Errorf("Unable to save leases: %v", err)
+	}
+}
+
+func closeAll(listeners []*listener) {
+	for _, l := range listeners {
+		l.close()
+	}
+}
+
+// listener serves an interface. DHCPv6 is served by coredhcp, and DHCPv4 by
+// listener4.
+type listener struct {
+	v6 *cdserver.Servers
+	v4 *listener4
+}
+
+func (l *listener) close() {
+	if l.v6 != nil {
+		l.v6.Close()
+		l.v6.Wait()
+	}
+	if l.v4 != nil {
+		l.v4.close()
 	}
-	instance = nil
 }
 
-func generateConfigFile(conf *Config) (string, error) {
-	configFile, err := os.CreateTemp("", "coredhcp_conf_*.yml")
+// listen starts serving requests on the interface. Each interface has its own
+// handlers, as the server identifiers of the responses are those of the interface.
+func (s *Server) listen(name string) (*listener, error) {
+	intf, err := net.InterfaceByName(name)
 	if err != nil {
-		return "", fmt.Errorf("error creating configuration file: %v", err)
+		return nil, fmt.Errorf("unknown interface %v: %v", name, err)
+	}
+	pools := s.interfacePools(name, interfaceAddrs(intf))
+	l := &listener{}
+	if s.v6 {
+		if l.v6, err = s.listen6(name, &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: intf.HardwareAddr}, pools); err != nil {
+			return nil, err
+		}
+	}
+	if s.v4 {
+		if l.v4, err = s.listen4(intf, pools); err != nil {
+			l.close()
+			return nil, err
+		}
 	}
+	return l, nil
+}
 
-	confTmpl, err := template.New("coredhcp").Parse(confTemplate)
+func (s *Server) listen6(name string, duid dhcpv6.DUID, pools *plpool.Pools) (*cdserver.Servers, error) {
+	key, err := addChain(&chain{h6: s.handlers6(duid, pools)})
 	if err != nil {
-		return "", fmt.Errorf("error parsing configuration template: %v", err)
+		return nil, err
+	}
+	defer removeChain(key)
+	conf := &cdconfig.Config{
+		Server6: &cdconfig.ServerConfig{
+			Addresses: []net.UDPAddr{{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort, Zone: name}},
+			Plugins:   []cdconfig.PluginConfig{{Name: chainPlugin.Name, Args: []string{key}}},
+		},
 	}
+	srv, err := cdserver.Start(conf)
+	if err != nil {
+		return nil, fmt.Errorf("error starting DHCP server on %v: %v", name, err)
+	}
+	return srv, nil
+}
 
-	intf, err := net.InterfaceByName(conf.Interface)
+func (s *Server) listen4(intf *net.Interface, pools *plpool.Pools) (*listener4, error) {
+	ip := getIPv4Address(intf)
+	if ip == nil {
+		return nil, fmt.Errorf("unable to find IPv4 address for interface %v", intf.Name)
+	}
+	conn, err := server4.NewIPv4UDPConn(intf.Name, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort})
+	if err != nil {
+		return nil, fmt.Errorf("error starting DHCP server on %v: %v", intf.Name, err)
+	}
+	l, err := serve4(conn, s.handlers4(ip, pools))
 	if err != nil {
-		return "", fmt.Errorf("unknown interface %v", *intf)
+		conn.Close()
+		return nil, fmt.Errorf("error starting DHCP server on %v: %v", intf.Name, err)
 	}
+	return l, nil
+}
+
+// interfacePools returns the pools served on the interface with the given addresses.
+func (s *Server) interfacePools(name string, addrs []net.IP) *plpool.Pools {
+	return s.pools.OnInterface(addrs, func(i int) bool {
+		return s.poolInterfaces[i] == "" || s.poolInterfaces[i] == name
+	})
+}
 
-	IPv4Addr := getIPv4Address(intf)
-	if IPv4Addr == nil {
-		return "", fmt.Errorf("unable to find IPv4 address for interface %v", conf.Interface)
+// handlers4 returns the DHCPv4 handlers of an interface, in order.
+func (s *Server) handlers4(serverID net.IP, pools *plpool.Pools) []cdhandler.Handler4 {
+	hs := []cdhandler.Handler4{serverID4(serverID), leaseTime4(defaultLeaseTime)}
+	if s.redirect != nil {
+		hs = append(hs, s.redirect.Handler4)
 	}
+	if len(s.dns4) > 0 {
+		hs = append(hs, dns4(s.dns4))
+	}
+	hs = append(hs, s.records.Handler4, pools.Handler4)
+	if s.gate != nil {
+		hs = append(hs, s.gate.Handler4)
+	}
+	return append(hs, s.leases.Handler4)
+}
 
-	DNSv4, DNSv6 := []string{}, []string{}
-	for _, v := range conf.DNS {
-		if isIPv6(v) {
-			DNSv6 = append(DNSv6, v)
-		} else {
-			DNSv4 = append(DNSv4, v)
-		}
+// handlers6 returns the DHCPv6 handlers of an interface, in order.
+func (s *Server) handlers6(serverID dhcpv6.DUID, pools *plpool.Pools) []cdhandler.Handler6 {
+	hs := []cdhandler.Handler6{serverID6(serverID)}
+	if s.redirect != nil {
+		hs = append(hs, s.redirect.Handler6)
+	}
+	if len(s.dns6) > 0 {
+		hs = append(hs, dns6(s.dns6))
+	}
+	hs = append(hs, s.records.Handler6, pools.Handler6)
+	if s.gate != nil {
+		hs = append(hs, s.gate.Handler6)
 	}
+	return append(hs, s.leases.Handler6)
+}
 
-	v6Records, v4Records := []string{}, []string{}
-	for k, a := range conf.AddressMap {
-		if isIPv6(a.IP) {
-			v6Records = append(v6Records, fmt.Sprintf("%s,%s", k, a.IP))
-		} else {
-			v4Records = append(v4Records, fmt.Sprintf("%s,%s,%s", k, a.IP, a.Gw))
-		}
+// AddEntry adds a record for the key to the server, replacing any record it
+// already has. The server may be running.
+func (s *Server) AddEntry(key string, e *Entry) error {
+	opts := &plslease.Options{
+		NTP:        e.NTP,
+		DomainName: e.DomainName,
+		Hostname:   e.Hostname,
+		MTU:        e.MTU,
+		LeaseTime:  e.LeaseTime,
+		Raw:        e.Options,
 	}
+	if err := s.records.SetWithOptions(key, e.IP, e.Gw, opts, strings.Fields(e.BootzURL)...); err != nil {
+		return fmt.Errorf("invalid dhcp record for %v: %v", key, err)
+	}
+	return nil
+}
+
+// RemoveEntry removes the record of the key from the server, if it has one.
+func (s *Server) RemoveEntry(key string) {
+	s.records.Remove(key)
+}
 
-	if err := confTmpl.Execute(configFile, struct {
-		IntfIPAddr  string
-		IntfMacAddr string
-		DNSv4       string
-		DNSv6       string
-		IPv4Leases  string
-		IPv6Leases  string
-		BootzURL    string
-	}{
-		IntfIPAddr:  IPv4Addr.String(),
-		IntfMacAddr: intf.HardwareAddr.String(),
-		DNSv4:       strings.Join(DNSv4, " "),
-		DNSv6:       strings.Join(DNSv6, " "),
-		IPv4Leases:  strings.Join(v4Records, " "),
-		IPv6Leases:  strings.Join(v6Records, " "),
-		BootzURL:    conf.BootzURL,
-	}); err != nil {
-		return "", fmt.Errorf("error generating configuration template: %v", err)
-	}
-	return configFile.Name(), nil
+// Leases returns the leases handed out by the server, including those loaded
+// from the lease file, sorted by IP address.
+func (s *Server) Leases() []*Lease {
+	return s.leases.List()
 }
 
 func isIPv6(address string) bool {
 	return strings.Count(address, ":") >= 2
 }
 
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceAddrs returns the addresses of the interface.
+func interfaceAddrs(i *net.Interface) []net.IP {
+	var ips []net.IP
+	if addrs, err := i.Addrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+	return ips
+}
+
 func getIPv4Address(i *net.Interface) net.IP {
 	if addrs, err := i.Addrs(); err == nil {
 		for _, a := range addrs {
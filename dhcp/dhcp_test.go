@@ -0,0 +1,405 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+	plleases "github.com/openconfig/bootz/dhcp/plugins/leases"
+	plpool "github.com/openconfig/bootz/dhcp/plugins/pool"
+)
+
+var testMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		desc    string
+		conf    *Config
+		wantErr string
+	}{{
+		desc: "Valid",
+		conf: &Config{
+			Interfaces: []string{"eth0", "eth1"},
+			Families:   []Family{IPv6},
+			DNS:        []string{"192.0.2.53", "2001:db8::53", ""},
+			AddressMap: map[string]*Entry{testMAC.String(): {IP: "2001:db8::10/64", NTP: []string{"2001:db8::123"}, Hostname: "r1", LeaseTime: 24 * time.Hour}},
+			Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1"}},
+			BootzURL:   "bootz://192.0.2.1:15006",
+		},
+	}, {
+		desc:    "No interface",
+		conf:    &Config{},
+		wantErr: "no interface",
+	}, {
+		desc:    "Unknown family",
+		conf:    &Config{Interfaces: []string{"eth0"}, Families: []Family{"ipx"}},
+		wantErr: "unknown address family",
+	}, {
+		desc:    "Invalid dns server",
+		conf:    &Config{Interfaces: []string{"eth0"}, DNS: []string{"dns.example.com"}},
+		wantErr: "invalid dns server",
+	}, {
+		desc: "Invalid entry",
+		conf: &Config{
+			Interfaces: []string{"eth0"},
+			AddressMap: map[string]*Entry{testMAC.String(): {IP: "192.0.2.10"}},
+		},
+		wantErr: "invalid dhcp record",
+	}, {
+		desc: "Invalid entry option",
+		conf: &Config{
+			Interfaces: []string{"eth0"},
+			AddressMap: map[string]*Entry{testMAC.String(): {IP: "192.0.2.10/24", Gw: "192.0.2.1", NTP: []string{"2001:db8::123"}}},
+		},
+		wantErr: "not an IPv4 address",
+	}, {
+		desc: "Invalid pool",
+		conf: &Config{
+			Interfaces: []string{"eth0"},
+			Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.3.1", Gw: "192.0.2.1"}},
+		},
+		wantErr: "not in the subnet",
+	}, {
+		desc: "Pool on an interface which is not served",
+		conf: &Config{
+			Interfaces: []string{"eth0"},
+			Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1", Interface: "eth1"}},
+		},
+		wantErr: "not served",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(test.conf)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Errorf("New() %s", s)
+			}
+		})
+	}
+}
+
+func TestStartUnknownInterface(t *testing.T) {
+	s, err := New(&Config{Interfaces: []string{"bzdhcp-none0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Errorf("Start() on an unknown interface err = nil, want an error")
+	}
+}
+
+// offer4 runs the DHCPv4 handlers of the server on a discover from testMAC,
+// with all the pools of the server.
+func offer4(t *testing.T, s *Server, serverID net.IP, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	return offerWithPools4(t, s, serverID, s.pools, mods...)
+}
+
+// offerWithPools4 runs the DHCPv4 handlers of an interface on a discover from testMAC.
+func offerWithPools4(t *testing.T, s *Server, serverID net.IP, pools *plpool.Pools, mods ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	mods = append(mods, dhcpv4.WithRequestedOptions(dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT)))
+	req, err := dhcpv4.NewDiscovery(testMAC, mods...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range s.handlers4(serverID, pools) {
+		var stop bool
+		if resp, stop = h(req, resp); stop {
+			break
+		}
+	}
+	return resp
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ip       string
+		bootzURL string
+		serverID string
+	}{{
+		desc:     "First server",
+		ip:       "192.0.2.10/24",
+		bootzURL: "bootz://192.0.2.1:15006",
+		serverID: "192.0.2.1",
+	}, {
+		desc:     "Second server",
+		ip:       "198.51.100.10/24",
+		bootzURL: "bootz://198.51.100.1:15006",
+		serverID: "198.51.100.1",
+	}}
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+			s, err := New(&Config{Interfaces: []string{"eth0"}, BootzURL: test.bootzURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddEntry(testMAC.String(), &Entry{IP: test.ip, Gw: test.serverID}); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				resp := offer4(t, s, net.ParseIP(test.serverID).To4())
+				if got := resp.YourIPAddr.String(); got+"/24" != test.ip {
+					t.Fatalf("offer = %v, want the address of %v", got, test.ip)
+				}
+				if got := resp.ServerIdentifier().String(); got != test.serverID {
+					t.Fatalf("offer server identifier = %v, want %v", got, test.serverID)
+				}
+				if got, err := plbootz.BootstrapServersV4(resp); err != nil || len(got) != 1 || got[0] != test.bootzURL {
+					t.Fatalf("BootstrapServersV4() = %v, %v, want %v", got, err, test.bootzURL)
+				}
+			}
+			if got := s.Leases(); len(got) != 1 || got[0].IP+"/24" != test.ip {
+				t.Errorf("Leases() = %+v, want only the lease of %v", got, test.ip)
+			}
+		})
+	}
+}
+
+func TestVendorClasses(t *testing.T) {
+	s, err := New(&Config{
+		Interfaces:    []string{"eth0"},
+		BootzURL:      "bootz://192.0.2.1:15006",
+		VendorClasses: []string{"Cisco"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEntry("FOX2506P2QT", &Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1", BootzURL: "bootz://192.0.2.2:15006"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc      string
+		class     string
+		wantIP    string
+		wantBootz bool
+	}{{
+		desc:      "Allowed vendor matched by serial",
+		class:     "Cisco:8201-32FH:FOX2506P2QT",
+		wantIP:    "192.0.2.10",
+		wantBootz: true,
+	}, {
+		desc:   "Other vendor",
+		class:  "Arista:DCS-7280:FOX2506P2QT",
+		wantIP: "192.0.2.10",
+	}, {
+		desc:   "No vendor class",
+		wantIP: "0.0.0.0",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var mods []dhcpv4.Modifier
+			if test.class != "" {
+				mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(test.class)))
+			}
+			resp := offer4(t, s, net.ParseIP("192.0.2.1").To4(), mods...)
+			if got := resp.YourIPAddr.String(); got != test.wantIP {
+				t.Errorf("offer = %v, want %v", got, test.wantIP)
+			}
+			if _, err := plbootz.BootstrapServersV4(resp); (err == nil) != test.wantBootz {
+				t.Errorf("sZTP redirect option sent = %v, want %v", err == nil, test.wantBootz)
+			}
+			if got := s.Leases(); test.wantIP != "0.0.0.0" && (len(got) != 1 || got[0].BootzOptionSent != test.wantBootz) {
+				t.Errorf("Leases() = %+v, want one lease with the bootz option sent = %v", got, test.wantBootz)
+			}
+		})
+	}
+}
+
+func TestInterfacePools(t *testing.T) {
+	s, err := New(&Config{
+		Interfaces: []string{"eth0", "eth1"},
+		Families:   []Family{IPv4},
+		Pools: []*Pool{
+			{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1"},
+			{Start: "198.51.100.100/24", End: "198.51.100.199", Gw: "198.51.100.1"},
+			{Start: "203.0.113.100/24", End: "203.0.113.199", Gw: "203.0.113.1", Interface: "eth1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc   string
+		intf   string
+		addr   string
+		relay  string
+		wantIP string
+	}{{
+		desc:   "Pool of the interface subnet",
+		intf:   "eth0",
+		addr:   "198.51.100.1",
+		wantIP: "198.51.100.100",
+	}, {
+		desc:   "No pool in the interface subnet",
+		intf:   "eth0",
+		addr:   "10.0.0.1",
+		wantIP: "0.0.0.0",
+	}, {
+		desc:   "Relayed to a pool of another interface",
+		intf:   "eth0",
+		addr:   "192.0.2.1",
+		relay:  "203.0.113.1",
+		wantIP: "0.0.0.0",
+	}, {
+		desc:   "Relayed to a pool of the interface",
+		intf:   "eth1",
+		addr:   "192.0.2.1",
+		relay:  "203.0.113.1",
+		wantIP: "203.0.113.100",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			addr := net.ParseIP(test.addr).To4()
+			var mods []dhcpv4.Modifier
+			if test.relay != "" {
+				mods = append(mods, dhcpv4.WithGatewayIP(net.ParseIP(test.relay)))
+			}
+			resp := offerWithPools4(t, s, addr, s.interfacePools(test.intf, []net.IP{addr}), mods...)
+			if got := resp.YourIPAddr.String(); got != test.wantIP {
+				t.Errorf("offer = %v, want %v", got, test.wantIP)
+			}
+		})
+	}
+}
+
+func TestPooledDeviceOptions(t *testing.T) {
+	s, err := New(&Config{
+		Interfaces: []string{"eth0"},
+		Families:   []Family{IPv4},
+		AddressMap: map[string]*Entry{testMAC.String(): {NTP: []string{"192.0.2.123"}, Hostname: "r1"}},
+		Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := offer4(t, s, net.ParseIP("192.0.2.1").To4())
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" {
+		t.Errorf("offer = %v, want 192.0.2.100", got)
+	}
+	if got := resp.NTPServers(); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.123")) {
+		t.Errorf("NTPServers() = %v, want 192.0.2.123", got)
+	}
+	if got := resp.HostName(); got != "r1" {
+		t.Errorf("HostName() = %q, want r1", got)
+	}
+}
+
+func TestPoolLeasesSurviveRestart(t *testing.T) {
+	conf := &Config{
+		Interfaces: []string{"eth0"},
+		Families:   []Family{IPv4},
+		Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1", LeaseTime: time.Hour}},
+		LeaseFile:  filepath.Join(t.TempDir(), "leases.json"),
+	}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+	resp := offer4(t, s, net.ParseIP("192.0.2.1").To4(), dhcpv4.WithHwAddr(other))
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" {
+		t.Fatalf("offer = %v, want 192.0.2.100", got)
+	}
+	s.Stop()
+
+	s, err = New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another device does not get the address leased before the restart.
+	if got := offer4(t, s, net.ParseIP("192.0.2.1").To4()).YourIPAddr.String(); got != "192.0.2.101" {
+		t.Errorf("offer after a restart = %v, want 192.0.2.101", got)
+	}
+}
+
+// waitLease waits until the lease of the mac is in the state, and returns it.
+func waitLease(t *testing.T, s *Server, mac net.HardwareAddr, state string) *Lease {
+	t.Helper()
+	var got []*Lease
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		got = s.Leases()
+		for _, l := range got {
+			if l.MAC == mac.String() && l.State == state {
+				return l
+			}
+		}
+	}
+	t.Fatalf("no %v lease for %v, got %+v", state, mac, got)
+	return nil
+}
+
+func TestServe4Release(t *testing.T) {
+	s, err := New(&Config{
+		Interfaces: []string{"eth0"},
+		Families:   []Family{IPv4},
+		Pools:      []*Pool{{Start: "192.0.2.100/24", End: "192.0.2.199", Gw: "192.0.2.1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := serve4(conn, s.handlers4(net.ParseIP("192.0.2.1").To4(), s.pools))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	send := func(m *dhcpv4.DHCPv4, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.WriteTo(m.ToBytes(), conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(dhcpv4.NewDiscovery(testMAC))
+	if got := waitLease(t, s, testMAC, plleases.StateOffered).IP; got != "192.0.2.100" {
+		t.Fatalf("offer = %v, want 192.0.2.100", got)
+	}
+	send(dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease), dhcpv4.WithHwAddr(testMAC),
+		dhcpv4.WithClientIP(net.ParseIP("192.0.2.100"))))
+	waitLease(t, s, testMAC, plleases.StateReleased)
+
+	// The released address goes to the next device.
+	other := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+	send(dhcpv4.NewDiscovery(other))
+	if got := waitLease(t, s, other, plleases.StateOffered).IP; got != "192.0.2.100" {
+		t.Errorf("offer after a release = %v, want 192.0.2.100", got)
+	}
+}
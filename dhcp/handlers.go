@@ -0,0 +1,180 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	cdhandler "github.com/coredhcp/coredhcp/handler"
+	cdplugins "github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// chainPlugin is the coredhcp plugin running the handlers of a listener.
+// Coredhcp only sets up registered plugins, which are global, so the handlers
+// of every listener are added under a key, the argument of the plugin.
+var chainPlugin = cdplugins.Plugin{
+	Name:   "bootz_chain",
+	Setup6: setupChain6,
+}
+
+// chain holds the handlers of a listener, in order.
+type chain struct {
+	h6 []cdhandler.Handler6
+}
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+
+	chainsMu  sync.Mutex
+	chains    = map[string]*chain{}
+	lastChain int
+)
+
+// addChain adds the handlers of a listener and returns their key.
+func addChain(c *chain) (string, error) {
+	registerOnce.Do(func() {
+		registerErr = cdplugins.RegisterPlugin(&chainPlugin)
+	})
+	if registerErr != nil {
+		return "", fmt.Errorf("failed to register plugin '%s': %v", chainPlugin.Name, registerErr)
+	}
+	chainsMu.Lock()
+	defer chainsMu.Unlock()
+	lastChain++
+	key := strconv.Itoa(lastChain)
+	chains[key] = c
+	return key, nil
+}
+
+// removeChain removes the handlers of the key, once the listener is set up.
+func removeChain(key string) {
+	chainsMu.Lock()
+	defer chainsMu.Unlock()
+	delete(chains, key)
+}
+
+func lookupChain(args []string) (*chain, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("want the key of a chain, got %v", args)
+	}
+	chainsMu.Lock()
+	defer chainsMu.Unlock()
+	c, ok := chains[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %v", args[0])
+	}
+	return c, nil
+}
+
+func setupChain6(args ...string) (cdhandler.Handler6, error) {
+	c, err := lookupChain(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		for _, h := range c.h6 {
+			var stop bool
+			if resp, stop = h(req, resp); stop {
+				return resp, true
+			}
+		}
+		return resp, false
+	}, nil
+}
+
+// The handlers below do what the coredhcp server_id, lease_time and dns plugins
+// do, without their global state.
+
+// serverID4 returns a handler which sets the server identifier of DHCPv4
+// responses, and drops the requests for other servers.
+func serverID4(id net.IP) cdhandler.Handler4 {
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		if req.ServerIPAddr != nil && !req.ServerIPAddr.IsUnspecified() && !req.ServerIPAddr.Equal(id) {
+			return nil, true
+		}
+		resp.ServerIPAddr = id
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(id))
+		return resp, false
+	}
+}
+
+// serverID6 returns a handler which sets the server identifier of DHCPv6
+// responses, and drops the requests RFC 8415 section 16 says to discard.
+func serverID6(id dhcpv6.DUID) cdhandler.Handler6 {
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		m, err := req.GetInnerMessage()
+		if err != nil {
+			return nil, true
+		}
+		if sid := m.Options.ServerID(); sid != nil {
+			switch m.MessageType {
+			case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRebind:
+				return nil, true
+			}
+			if !sid.Equal(id) {
+				return nil, true
+			}
+		} else {
+			switch m.MessageType {
+			case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeDecline, dhcpv6.MessageTypeRelease:
+				return nil, true
+			}
+		}
+		dhcpv6.WithServerID(id)(resp)
+		return resp, false
+	}
+}
+
+// leaseTime4 returns a handler which sets the lease time of DHCPv4 responses,
+// unless it is already set.
+func leaseTime4(d time.Duration) cdhandler.Handler4 {
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+			resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(d))
+		}
+		return resp, false
+	}
+}
+
+// dns4 returns a handler which sends the dns servers to the DHCPv4 clients asking for them.
+func dns4(servers []net.IP) cdhandler.Handler4 {
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
+			resp.Options.Update(dhcpv4.OptDNS(servers...))
+		}
+		return resp, false
+	}
+}
+
+// dns6 returns a handler which sends the dns servers to the DHCPv6 clients asking for them.
+func dns6(servers []net.IP) cdhandler.Handler6 {
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		m, err := req.GetInnerMessage()
+		if err != nil {
+			return nil, true
+		}
+		if m.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+			resp.UpdateOption(dhcpv6.OptDNS(servers...))
+		}
+		return resp, false
+	}
+}
@@ -17,21 +17,59 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	log "github.com/golang/glog"
 	"github.com/openconfig/bootz/dhcp"
 )
 
 var (
-	intf    = flag.String("i", "eth7", "Network interface to use for dhcp server.")
-	records = flag.String("records", "4c:5d:3c:ef:de:60,5.78.26.27/16,5.78.0.1;FOX2506P2QT,5::10/64", "List of dhcp records separated by a semi-colon.")
-	dns     = flag.String("dns", "5.38.4.124", "List of dns servers separated by a semi-colon.")
-	bootz   = flag.String("bootz_url", "bootz://dev-mgbl-lnx6.cisco.com:50052/grpc", "Bootz server URL.")
+	intf     = flag.String("i", "eth7", "Network interfaces to use for dhcp server, separated by commas.")
+	families = flag.String("families", "", "Address families to serve, ipv4 and/or ipv6 separated by commas. Both if empty.")
+	records  = flag.String("records", "4c:5d:3c:ef:de:60,5.78.26.27/16,5.78.0.1;FOX2506P2QT,5::10/64", "List of dhcp records separated by a semi-colon. Records are mac|serial|circuit-id:<id>|remote-id:<id>,ip/prefix[,gw] followed by the bootz URLs of the device, if any.")
+	dns      = flag.String("dns", "5.38.4.124", "List of dns servers separated by a semi-colon.")
+	pools    = flag.String("pools", "", "List of address pools for devices without a record, separated by a semi-colon. IPv4 pools are start/prefix,end,gw[,lease_time] and IPv6 pools start/prefix,end[,lease_time], followed by @interface to serve the pool on that interface only.")
+	classes  = flag.String("vendor_classes", "", "Vendor classes of the devices the bootz server is advertised to, separated by commas. All devices if empty.")
+	leases   = flag.String("lease_file", "", "File the leases are persisted to. If empty, the leases are only kept in memory.")
+	admin    = flag.String("admin_port", "", "If set, the leases are served as JSON on http://localhost:<admin_port>/leases.")
+	bootz    = flag.String("bootz_url", "bootz://dev-mgbl-lnx6.cisco.com:50052/grpc", "Bootz server URL. Several URLs separated by spaces are advertised in priority order.")
 )
 
+// parsePool parses an address pool given as start/prefix,end,gw[,lease_time] for
+// IPv4 or start/prefix,end[,lease_time] for IPv6, followed by @interface if the
+// pool is only served on one interface.
+func parsePool(s string) (*dhcp.Pool, error) {
+	s, intf, _ := strings.Cut(s, "@")
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("incorrect pool format: %v", s)
+	}
+	p := &dhcp.Pool{Start: parts[0], End: parts[1], Interface: intf}
+	rest := parts[2:]
+	if !strings.Contains(p.Start, ":") {
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("IPv4 pool %v has no gateway", s)
+		}
+		p.Gw, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 1 {
+		return nil, fmt.Errorf("incorrect pool format: %v", s)
+	}
+	if len(rest) == 1 {
+		d, err := time.ParseDuration(rest[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease time in pool %v: %v", s, err)
+		}
+		p.LeaseTime = d
+	}
+	return p, nil
+}
+
 func main() {
 	flag.Parse()
 	if *intf == "" {
@@ -47,30 +85,68 @@ func main() {
 		e := &dhcp.Entry{
 			IP: parts[1],
 		}
-		if len(parts) > 2 {
-			e.Gw = parts[2]
+		uris := parts[2:]
+		if strings.Count(e.IP, ":") < 2 && len(uris) > 0 {
+			e.Gw, uris = uris[0], uris[1:]
 		}
+		e.BootzURL = strings.Join(uris, " ")
 		addressMap[parts[0]] = e
 	}
 
+	var addressPools []*dhcp.Pool
+	if *pools != "" {
+		for _, r := range strings.Split(*pools, ";") {
+			p, err := parsePool(r)
+			if err != nil {
+				log.Exit(err)
+			}
+			addressPools = append(addressPools, p)
+		}
+	}
+
 	conf := &dhcp.Config{
-		Interface:  *intf,
+		Interfaces: strings.Split(*intf, ","),
 		DNS:        strings.Split(*dns, ";"),
 		AddressMap: addressMap,
+		Pools:      addressPools,
 		BootzURL:   *bootz,
+		LeaseFile:  *leases,
+	}
+	if *classes != "" {
+		conf.VendorClasses = strings.Split(*classes, ",")
+	}
+	if *families != "" {
+		for _, f := range strings.Split(*families, ",") {
+			conf.Families = append(conf.Families, dhcp.Family(f))
+		}
+	}
+
+	srv, err := dhcp.New(conf)
+	if err != nil {
+		log.Exitf("invalid dhcp server configuration: %v", err)
 	}
 
 	go func() {
 		sigchan := make(chan os.Signal, 1)
 		signal.Notify(sigchan, os.Interrupt)
 		<-sigchan
-		dhcp.Stop()
+		srv.Stop()
 		os.Exit(0)
 	}()
 
-	if err := dhcp.Start(conf); err != nil {
+	if err := srv.Start(); err != nil {
 		log.Exitf("error starting dhcp server: %v", err)
 	}
 
+	if *admin != "" {
+		addr := fmt.Sprintf("localhost:%v", *admin)
+		log.Infof("Serving leases on http://%v%v", addr, dhcp.LeasesPath)
+		go func() {
+			if err := http.ListenAndServe(addr, dhcp.NewAdminHandler(srv)); err != nil {
+				log.Exitf("error serving admin endpoint: %v", err)
+			}
+		}()
+	}
+
 	select {}
 }
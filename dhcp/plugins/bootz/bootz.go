@@ -16,6 +16,7 @@
 package bootz
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net/url"
 
@@ -24,6 +25,7 @@ import (
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/openconfig/bootz/dhcp/vendorclass"
 )
 
 var log = logger.GetLogger("plugins/bootz")
@@ -34,70 +36,248 @@ var Plugin = plugins.Plugin{
 	Setup6: setup6,
 }
 
+// Option codes of the sZTP redirect options defined in RFC 8572.
 const (
-	OPTION_V4_SZTP_REDIRECT uint8 = 143
-	OPTION_V6_SZTP_REDIRECT uint8 = 136
+	OPTION_V4_SZTP_REDIRECT uint8 = 136
+	OPTION_V6_SZTP_REDIRECT uint8 = 143
 )
 
-var (
-	ztpV4Opt *dhcpv4.Option
-	ztpV6Opt dhcpv6.Option
-)
+// maxURILength is the longest URI the 16 bit uri-length field can describe.
+const maxURILength = 1<<16 - 1
 
-func parseArgs(args ...string) (*url.URL, error) {
-	if len(args) != 1 {
-		return nil, fmt.Errorf("exactly one argument must be passed to BootZ plugin, got %d", len(args))
+// EncodeBootstrapServerList encodes URIs as the bootstrap-server-list of an sZTP
+// redirect option: each URI is preceded by its length as a 16 bit big endian integer.
+// The URIs are listed in the order the device should try them.
+func EncodeBootstrapServerList(uris ...string) ([]byte, error) {
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("the bootstrap server list must have at least one URI")
+	}
+	var data []byte
+	for _, uri := range uris {
+		if uri == "" || len(uri) > maxURILength {
+			return nil, fmt.Errorf("invalid bootstrap server URI length %d for %q", len(uri), uri)
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(uri)))
+		data = append(data, uri...)
 	}
-	return url.Parse(args[0])
+	return data, nil
 }
 
-func setup4(args ...string) (handler.Handler4, error) {
-	url, err := parseArgs(args...)
+// ParseBootstrapServerList decodes the bootstrap-server-list of an sZTP redirect
+// option and returns its URIs in priority order.
+func ParseBootstrapServerList(data []byte) ([]string, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty bootstrap server list")
+	}
+	var uris []string
+	for rest := data; len(rest) > 0; {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("truncated uri-length at offset %d", len(data)-len(rest))
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		if n == 0 {
+			return nil, fmt.Errorf("empty URI at offset %d", len(data)-len(rest))
+		}
+		if len(rest) < 2+n {
+			return nil, fmt.Errorf("URI at offset %d has length %d, only %d bytes left", len(data)-len(rest), n, len(rest)-2)
+		}
+		uris = append(uris, string(rest[2:2+n]))
+		rest = rest[2+n:]
+	}
+	return uris, nil
+}
+
+// OptV4SZTPRedirect returns the DHCPv4 sZTP redirect option listing the URIs.
+// Values longer than 255 bytes are split over several options when the packet
+// is serialized, as RFC 3396 describes.
+func OptV4SZTPRedirect(uris ...string) (dhcpv4.Option, error) {
+	data, err := EncodeBootstrapServerList(uris...)
 	if err != nil {
-		return nil, err
+		return dhcpv4.Option{}, err
 	}
-	ztpV4Opt = &dhcpv4.Option{
+	return dhcpv4.Option{
 		Code:  dhcpv4.GenericOptionCode(OPTION_V4_SZTP_REDIRECT),
-		Value: dhcpv4.String(url.String()),
-	}
-	return handler4, nil
+		Value: dhcpv4.OptionGeneric{Data: data},
+	}, nil
 }
 
-func setup6(args ...string) (handler.Handler6, error) {
-	url, err := parseArgs(args...)
+// OptV6SZTPRedirect returns the DHCPv6 sZTP redirect option listing the URIs.
+func OptV6SZTPRedirect(uris ...string) (dhcpv6.Option, error) {
+	data, err := EncodeBootstrapServerList(uris...)
 	if err != nil {
 		return nil, err
 	}
-	ztpV6Opt = &dhcpv6.OptionGeneric{
+	return &dhcpv6.OptionGeneric{
 		OptionCode: dhcpv6.OptionCode(OPTION_V6_SZTP_REDIRECT),
-		OptionData: []byte(url.String()),
+		OptionData: data,
+	}, nil
+}
+
+// BootstrapServersV4 returns the URIs in the sZTP redirect option of a DHCPv4 message.
+func BootstrapServersV4(m *dhcpv4.DHCPv4) ([]string, error) {
+	data := m.Options.Get(dhcpv4.GenericOptionCode(OPTION_V4_SZTP_REDIRECT))
+	if data == nil {
+		return nil, fmt.Errorf("no sZTP redirect option")
+	}
+	return ParseBootstrapServerList(data)
+}
+
+// BootstrapServersV6 returns the URIs in the sZTP redirect options of a DHCPv6
+// message, in order. A message may carry the option more than once.
+func BootstrapServersV6(m *dhcpv6.Message) ([]string, error) {
+	opts := m.Options.Get(dhcpv6.OptionCode(OPTION_V6_SZTP_REDIRECT))
+	if len(opts) == 0 {
+		return nil, fmt.Errorf("no sZTP redirect option")
+	}
+	var uris []string
+	for _, opt := range opts {
+		u, err := ParseBootstrapServerList(opt.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, u...)
+	}
+	return uris, nil
+}
+
+// parseArgs parses the plugin arguments: the bootstrap server URIs in priority order.
+func parseArgs(args ...string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("at least one bootstrap server URI must be passed to BootZ plugin")
+	}
+	var uris []string
+	for _, arg := range args {
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap server URI %q: %v", arg, err)
+		}
+		uris = append(uris, u.String())
+	}
+	return uris, nil
+}
+
+// Redirect adds the sZTP redirect options to the responses of the clients asking
+// for them. Each dhcp server has its own.
+type Redirect struct {
+	v4 dhcpv4.Option
+	v6 dhcpv6.Option
+}
+
+// NewRedirect returns a Redirect advertising the bootstrap server URIs in priority order.
+func NewRedirect(uris ...string) (*Redirect, error) {
+	uris, err := parseArgs(uris...)
+	if err != nil {
+		return nil, err
+	}
+	r := &Redirect{}
+	if r.v4, err = OptV4SZTPRedirect(uris...); err != nil {
+		return nil, err
+	}
+	if r.v6, err = OptV6SZTPRedirect(uris...); err != nil {
+		return nil, err
 	}
-	return handler6, nil
+	return r, nil
 }
 
-func handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func setup4(args ...string) (handler.Handler4, error) {
+	r, err := NewRedirect(args...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	r, err := NewRedirect(args...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Handler6, nil
+}
+
+// RequestedV4 reports whether a DHCPv4 client asked for the sZTP redirect option.
+func RequestedV4(req *dhcpv4.DHCPv4) bool {
 	for _, p := range req.ParameterRequestList() {
 		if p.Code() == OPTION_V4_SZTP_REDIRECT {
-			resp.Options.Update(*ztpV4Opt)
-			log.Debugf("Added ZTP option: %v", resp.Summary())
-			break
+			return true
 		}
 	}
+	return false
+}
+
+// RequestedV6 reports whether a DHCPv6 client asked for the sZTP redirect option.
+func RequestedV6(req *dhcpv6.Message) bool {
+	for _, code := range req.Options.RequestedOptions() {
+		if code == dhcpv6.OptionCode(OPTION_V6_SZTP_REDIRECT) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler4 handles DHCPv4 packets for the bootz plugin.
+func (r *Redirect) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if RequestedV4(req) {
+		resp.Options.Update(r.v4)
+		log.Debugf("Added ZTP option: %v", resp.Summary())
+	}
 	return resp, false
 }
 
-func handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+// Handler6 handles DHCPv6 packets for the bootz plugin.
+func (r *Redirect) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	decap, err := req.GetInnerMessage()
 	if err != nil {
 		log.Errorf("Could not decapsulate request: %v", err)
 		return nil, false
 	}
 
-	for _, code := range decap.Options.RequestedOptions() {
-		if code == ztpV6Opt.Code() {
-			resp.AddOption(ztpV6Opt)
-			log.Debugf("Added ZTP option: %v", resp.Summary())
+	if RequestedV6(decap) {
+		resp.AddOption(r.v6)
+		log.Debugf("Added ZTP option: %v", resp.Summary())
+	}
+	return resp, false
+}
+
+// Gate removes the sZTP redirect options from the responses to the clients
+// which are not of an allowed vendor class, so that only the devices of known
+// vendors are sent to the bootz server. It must follow the handlers adding the options.
+type Gate struct {
+	classes []string
+}
+
+// NewGate returns a Gate letting the redirect options through to the clients of
+// the vendor classes, see vendorclass.Info.Matches.
+func NewGate(classes ...string) *Gate {
+	return &Gate{classes: classes}
+}
+
+// Handler4 handles DHCPv4 packets for the gate.
+func (g *Gate) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	code := dhcpv4.GenericOptionCode(OPTION_V4_SZTP_REDIRECT)
+	if resp.Options.Has(code) && !vendorclass.FromV4(req).Matches(g.classes) {
+		resp.Options.Del(code)
+		log.Infof("Not advertising bootz to %v of vendor class %q", req.ClientHWAddr, req.ClassIdentifier())
+	}
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the gate.
+func (g *Gate) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	code := dhcpv6.OptionCode(OPTION_V6_SZTP_REDIRECT)
+	if resp.GetOneOption(code) == nil {
+		return resp, false
+	}
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	if vc := vendorclass.FromV6(decap); !vc.Matches(g.classes) {
+		if m, ok := resp.(*dhcpv6.Message); ok {
+			m.Options.Del(code)
 		}
+		log.Infof("Not advertising bootz to %v of vendor class %+v", decap.Options.ClientID(), vc)
 	}
 	return resp, false
 }
@@ -0,0 +1,318 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootz
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/h-fam/errdiff"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// The bootstrap servers of the examples in RFC 8572, in priority order.
+var rfcURIs = []string{"https://sztp1.example.com:8443", "https://sztp2.example.com:8443"}
+
+// rfcList is the bootstrap-server-list encoding rfcURIs.
+const rfcList = "\x00\x1ehttps://sztp1.example.com:8443\x00\x1ehttps://sztp2.example.com:8443"
+
+func TestEncodeBootstrapServerList(t *testing.T) {
+	tests := []struct {
+		desc    string
+		uris    []string
+		want    []byte
+		wantErr string
+	}{{
+		desc: "Single URI",
+		uris: []string{"bootz://192.0.2.1:15006"},
+		want: []byte("\x00\x17bootz://192.0.2.1:15006"),
+	}, {
+		desc: "URIs in priority order",
+		uris: rfcURIs,
+		want: []byte(rfcList),
+	}, {
+		desc:    "No URI",
+		wantErr: "at least one URI",
+	}, {
+		desc:    "Empty URI",
+		uris:    []string{"bootz://a:1", ""},
+		wantErr: "invalid bootstrap server URI length",
+	}, {
+		desc:    "URI too long",
+		uris:    []string{strings.Repeat("a", maxURILength+1)},
+		wantErr: "invalid bootstrap server URI length",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := EncodeBootstrapServerList(test.uris...)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("EncodeBootstrapServerList() %s", s)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("EncodeBootstrapServerList() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBootstrapServerList(t *testing.T) {
+	tests := []struct {
+		desc    string
+		data    []byte
+		want    []string
+		wantErr string
+	}{{
+		desc: "URIs in priority order",
+		data: []byte(rfcList),
+		want: rfcURIs,
+	}, {
+		desc:    "Empty list",
+		wantErr: "empty bootstrap server list",
+	}, {
+		desc:    "Truncated length",
+		data:    []byte("\x00\x0bbootz://a:1\x00"),
+		wantErr: "truncated uri-length at offset 13",
+	}, {
+		desc:    "Truncated URI",
+		data:    []byte("\x00\x20bootz://a:1"),
+		wantErr: "has length 32",
+	}, {
+		desc:    "Zero length URI",
+		data:    []byte("\x00\x00"),
+		wantErr: "empty URI",
+	}, {
+		desc:    "Bare URI",
+		data:    []byte("bootz://a:1"),
+		wantErr: "has length",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := ParseBootstrapServerList(test.data)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("ParseBootstrapServerList() %s", s)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ParseBootstrapServerList() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	// Two URIs of 210 bytes, so the list does not fit in one option.
+	long := []string{"bootz://" + strings.Repeat("a", 200) + ":1", "bootz://" + strings.Repeat("b", 200) + ":2"}
+	longList := "\x00\xd2" + long[0] + "\x00\xd2" + long[1]
+	tests := []struct {
+		desc    string
+		uris    []string
+		request []dhcpv4.OptionCode
+		// wantRaw is the option as it must appear in the packet, if sent.
+		wantRaw []byte
+		want    []string
+	}{{
+		desc:    "Requested",
+		uris:    rfcURIs,
+		request: []dhcpv4.OptionCode{dhcpv4.OptionRouter, dhcpv4.GenericOptionCode(136)},
+		wantRaw: append([]byte{136, byte(len(rfcList))}, rfcList...),
+		want:    rfcURIs,
+	}, {
+		desc:    "Not requested",
+		uris:    rfcURIs,
+		request: []dhcpv4.OptionCode{dhcpv4.OptionRouter},
+	}, {
+		desc:    "Longer than one option",
+		uris:    long,
+		request: []dhcpv4.OptionCode{dhcpv4.GenericOptionCode(136)},
+		// RFC 3396 splits the value into options of at most 255 bytes.
+		wantRaw: append([]byte{136, 255}, longList[:255]...),
+		want:    long,
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			h, err := setup4(test.uris...)
+			if err != nil {
+				t.Fatalf("setup4() err = %v", err)
+			}
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithRequestedOptions(test.request...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, stop := h(req, resp)
+			if stop {
+				t.Errorf("Handler4() stopped the plugin chain")
+			}
+			raw := resp.ToBytes()
+			if test.wantRaw != nil && !bytes.Contains(raw, test.wantRaw) {
+				t.Errorf("Handler4() reply %x does not contain option %x", raw, test.wantRaw)
+			}
+			parsed, err := dhcpv4.FromBytes(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := BootstrapServersV4(parsed)
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("BootstrapServersV4() = %v, want no sZTP redirect option", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BootstrapServersV4() err = %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("BootstrapServersV4() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandler6(t *testing.T) {
+	tests := []struct {
+		desc    string
+		request []dhcpv6.OptionCode
+		want    []string
+	}{{
+		desc:    "Requested",
+		request: []dhcpv6.OptionCode{dhcpv6.OptionDNSRecursiveNameServer, dhcpv6.OptionCode(143)},
+		want:    rfcURIs,
+	}, {
+		desc:    "Not requested",
+		request: []dhcpv6.OptionCode{dhcpv6.OptionDNSRecursiveNameServer},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			h, err := setup6(rfcURIs...)
+			if err != nil {
+				t.Fatalf("setup6() err = %v", err)
+			}
+			req, err := dhcpv6.NewSolicit(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv6.WithRequestedOptions(test.request...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, stop := h(req, resp)
+			if stop {
+				t.Errorf("Handler6() stopped the plugin chain")
+			}
+			raw := reply.ToBytes()
+			wantRaw := append([]byte{0, 143, 0, byte(len(rfcList))}, rfcList...)
+			if sent := bytes.Contains(raw, wantRaw); sent != (test.want != nil) {
+				t.Errorf("Handler6() reply %x contains option %x = %v, want %v", raw, wantRaw, sent, test.want != nil)
+			}
+			parsed, err := dhcpv6.MessageFromBytes(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := BootstrapServersV6(parsed)
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("BootstrapServersV6() = %v, want no sZTP redirect option", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BootstrapServersV6() err = %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("BootstrapServersV6() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	if _, err := parseArgs(); err == nil {
+		t.Errorf("parseArgs() err = nil, want an error")
+	}
+	if _, err := parseArgs("bootz://a:1", "://bad"); err == nil {
+		t.Errorf("parseArgs() with an invalid URI err = nil, want an error")
+	}
+}
+
+func TestGate(t *testing.T) {
+	r, err := NewRedirect(rfcURIs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGate("Arista", "Cisco")
+	tests := []struct {
+		desc  string
+		class string
+		want  bool
+	}{{
+		desc:  "Allowed manufacturer",
+		class: "Arista:DCS-7280",
+		want:  true,
+	}, {
+		desc:  "Allowed class prefix",
+		class: "cisco-8201",
+		want:  true,
+	}, {
+		desc:  "Other vendor",
+		class: "Juniper:ptx10001",
+	}, {
+		desc: "No vendor class",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+			mods4 := []dhcpv4.Modifier{dhcpv4.WithRequestedOptions(dhcpv4.GenericOptionCode(136))}
+			mods6 := []dhcpv6.Modifier{dhcpv6.WithRequestedOptions(dhcpv6.OptionCode(143))}
+			if test.class != "" {
+				mods4 = append(mods4, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(test.class)))
+				mods6 = append(mods6, dhcpv6.WithOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 1, Data: [][]byte{[]byte(test.class)}}))
+			}
+
+			req4, err := dhcpv4.NewDiscovery(mac, mods4...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp4, err := dhcpv4.NewReplyFromRequest(req4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp4, _ = r.Handler4(req4, resp4)
+			resp4, _ = g.Handler4(req4, resp4)
+			if _, err := BootstrapServersV4(resp4); (err == nil) != test.want {
+				t.Errorf("DHCPv4 redirect sent = %v, want %v", err == nil, test.want)
+			}
+
+			req6, err := dhcpv6.NewSolicit(mac, mods6...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp6, err := dhcpv6.NewAdvertiseFromSolicit(req6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, _ := r.Handler6(req6, resp6)
+			reply, _ = g.Handler6(req6, reply)
+			if _, err := BootstrapServersV6(reply.(*dhcpv6.Message)); (err == nil) != test.want {
+				t.Errorf("DHCPv6 redirect sent = %v, want %v", err == nil, test.want)
+			}
+		})
+	}
+}
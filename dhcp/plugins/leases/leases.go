@@ -0,0 +1,422 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leases implements a dhcp server plugin which records the leases handed
+// out by the other plugins, and optionally persists them to a file so that they
+// survive restarts.
+//
+// The plugin must come last, so that it sees the final responses. Its only
+// argument is the path of the file the leases are kept in. Without it the
+// leases are only kept in memory.
+//
+// Releases mark the lease of the client released. The coredhcp server does not
+// pass DHCPv4 releases to plugins, unlike the bootz dhcp server, so the DHCPv4
+// leases coredhcp serves stay bound until they expire.
+package leases
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/openconfig/bootz/dhcp/relay"
+	"github.com/openconfig/bootz/dhcp/vendorclass"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+)
+
+var log = logger.GetLogger("plugins/leases")
+
+// saveDelay is how long the changes to the leases are batched for before they
+// are saved to the file.
+const saveDelay = time.Second
+
+var Plugin = plugins.Plugin{
+	Name:   "leases",
+	Setup4: setup4,
+	Setup6: setup6,
+}
+
+// Lease states.
+const (
+	// StateOffered is the state of leases offered to a client which did not request them yet.
+	StateOffered = "offered"
+	// StateBound is the state of leases acknowledged to a client.
+	StateBound = "bound"
+	// StateReleased is the state of leases released by a client.
+	StateReleased = "released"
+)
+
+// Lease is an address handed out to a client.
+type Lease struct {
+	IP string `json:"ip"`
+	// MAC is the hardware address of the client, if known.
+	MAC string `json:"mac,omitempty"`
+	// ClientID is the hex encoded DHCPv4 client identifier, if the client sent one.
+	ClientID string `json:"client_id,omitempty"`
+	// DUID is the hex encoded DHCPv6 client DUID.
+	DUID string `json:"duid,omitempty"`
+	// Relay is the address of the relay agent the request came through, if any.
+	Relay string `json:"relay,omitempty"`
+	// CircuitID and RemoteID are the relay identifiers of the client's port, if any.
+	CircuitID string `json:"circuit_id,omitempty"`
+	RemoteID  string `json:"remote_id,omitempty"`
+	// Manufacturer, Model and Serial are what the client told about itself in
+	// its vendor class options, if anything.
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Serial       string `json:"serial,omitempty"`
+	State        string `json:"state"`
+	// BootzOptionSent reports whether the last response carried the sZTP redirect option.
+	BootzOptionSent bool `json:"bootz_option_sent"`
+	// Created is when the address was first handed out to the client.
+	Created time.Time `json:"created"`
+	// Updated is when the client last got a response.
+	Updated time.Time `json:"updated"`
+	// Expires is when the lease runs out.
+	Expires time.Time `json:"expires"`
+}
+
+// key identifies the client of a lease.
+func (l *Lease) key() string {
+	switch {
+	case l.DUID != "":
+		return "duid:" + l.DUID
+	case l.ClientID != "":
+		return "id:" + l.ClientID
+	}
+	return "mac:" + l.MAC
+}
+
+// setRelay records the relay information of the request, if it was relayed.
+func (l *Lease) setRelay(info *relay.Info) {
+	if info == nil {
+		return
+	}
+	if info.Link != nil {
+		l.Relay = info.Link.String()
+	}
+	if len(info.CircuitID) > 0 {
+		l.CircuitID = relay.ID(info.CircuitID)
+	}
+	if len(info.RemoteID) > 0 {
+		l.RemoteID = relay.ID(info.RemoteID)
+	}
+}
+
+// setVendorClass records the identity the client sent in its vendor class options, if any.
+func (l *Lease) setVendorClass(info *vendorclass.Info) {
+	if info == nil {
+		return
+	}
+	l.Manufacturer = info.Manufacturer
+	l.Model = info.Model
+	l.Serial = info.Serial
+}
+
+// Store keeps the leases handed out by a dhcp server, and persists them to a
+// file if it has one. Each dhcp server has its own. It is safe for concurrent use.
+//
+// The changes are saved in batches off the packet path, see Flush.
+type Store struct {
+	mu sync.Mutex
+	// path is the file the leases are saved to, if any.
+	path   string
+	leases map[string]*Lease
+	// pending is set while changes to the leases wait to be saved.
+	pending *time.Timer
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	// saveMu orders the writes of the file.
+	saveMu sync.Mutex
+}
+
+// Open returns a store of the leases saved to the file, which is created when
+// the first lease is recorded. The leases are only kept in memory if the file
+// is empty.
+func Open(file string) (*Store, error) {
+	s := &Store{path: file, leases: map[string]*Lease{}, now: time.Now}
+	if file == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read leases: %v", err)
+	}
+	list := []*Lease{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to parse leases %v: %v", file, err)
+	}
+	for _, l := range list {
+		s.leases[l.key()] = l
+	}
+	log.Infof("Loaded %d leases from %q", len(s.leases), file)
+	return s, nil
+}
+
+// stores are the stores of the plugin, by file, so that the DHCPv4 and DHCPv6
+// plugins of a configuration share theirs.
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*Store{}
+)
+
+func setup(args ...string) (*Store, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("want at most one lease file, got %v", args)
+	}
+	file := ""
+	if len(args) == 1 {
+		file = args[0]
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if s, ok := stores[file]; ok {
+		return s, nil
+	}
+	s, err := Open(file)
+	if err != nil {
+		return nil, err
+	}
+	stores[file] = s
+	return s, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	s, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	s, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Handler6, nil
+}
+
+// List returns a copy of the recorded leases, sorted by IP address.
+func (s *Store) List() []*Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		c := *l
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := netip.ParseAddr(list[i].IP)
+		b, _ := netip.ParseAddr(list[j].IP)
+		if c := a.Compare(b); c != 0 {
+			return c < 0
+		}
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
+
+// scheduleSave saves the leases to the file, if any, once saveDelay has passed,
+// together with the other changes made until then. The caller must hold s.mu.
+func (s *Store) scheduleSave() {
+	if s.path == "" || s.pending != nil {
+		return
+	}
+	s.pending = time.AfterFunc(saveDelay, func() {
+		if err := s.Flush(); err != nil {
+			log.Errorf("Unable to save leases: %v", err)
+		}
+	})
+}
+
+// Flush saves the changes to the leases which are waiting to be saved, if any.
+func (s *Store) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if s.pending == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pending.Stop()
+	s.pending = nil
+	list := make([]*Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	data, err := json.MarshalIndent(list, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data)
+}
+
+// writeFile replaces the file with data. A temporary file is written and synced
+// first, so a crash never leaves partial leases behind.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Sync the directory too, so that the rename survives a crash.
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// record updates the lease of the client and schedules saving the leases.
+func (s *Store) record(l *Lease) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.now()
+	l.Updated = t
+	l.Created = t
+	if old, ok := s.leases[l.key()]; ok && old.IP == l.IP {
+		l.Created = old.Created
+	}
+	s.leases[l.key()] = l
+	log.Debugf("Recorded %v lease of %v for %v", l.State, l.IP, l.key())
+	s.scheduleSave()
+}
+
+// release marks the lease of the client as released.
+func (s *Store) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[key]
+	if !ok {
+		return
+	}
+	l.State = StateReleased
+	l.Updated = s.now()
+	l.Expires = l.Updated
+	s.scheduleSave()
+}
+
+// Handler4 handles DHCPv4 packets for the leases plugin.
+func (s *Store) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	l := &Lease{MAC: req.ClientHWAddr.String()}
+	if cid := req.GetOneOption(dhcpv4.OptionClientIdentifier); len(cid) > 0 {
+		l.ClientID = hex.EncodeToString(cid)
+	}
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		s.release(l.key())
+		return resp, false
+	}
+	if resp == nil || resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return resp, false
+	}
+	l.IP = resp.YourIPAddr.String()
+	l.BootzOptionSent = resp.Options.Has(dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT))
+	l.setRelay(relay.FromV4(req))
+	l.setVendorClass(vendorclass.FromV4(req))
+	switch resp.MessageType() {
+	case dhcpv4.MessageTypeOffer:
+		l.State = StateOffered
+	case dhcpv4.MessageTypeAck:
+		l.State = StateBound
+	default:
+		return resp, false
+	}
+	if d := resp.IPAddressLeaseTime(0); d > 0 {
+		l.Expires = s.now().Add(d)
+	}
+	s.record(l)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the leases plugin.
+func (s *Store) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	duid := m.Options.ClientID()
+	if duid == nil {
+		return resp, false
+	}
+	l := &Lease{DUID: hex.EncodeToString(duid.ToBytes())}
+	if m.Type() == dhcpv6.MessageTypeRelease {
+		s.release(l.key())
+		return resp, false
+	}
+	r, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		return resp, false
+	}
+	iana := r.Options.OneIANA()
+	if iana == nil || iana.Options.OneAddress() == nil {
+		return resp, false
+	}
+	addr := iana.Options.OneAddress()
+	l.IP = addr.IPv6Addr.String()
+	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
+		l.MAC = mac.String()
+	}
+	l.setRelay(relay.FromV6(req))
+	l.setVendorClass(vendorclass.FromV6(m))
+	l.BootzOptionSent = r.GetOneOption(dhcpv6.OptionCode(plbootz.OPTION_V6_SZTP_REDIRECT)) != nil
+	switch r.Type() {
+	case dhcpv6.MessageTypeAdvertise:
+		l.State = StateOffered
+	case dhcpv6.MessageTypeReply:
+		l.State = StateBound
+	default:
+		return resp, false
+	}
+	if addr.ValidLifetime > 0 {
+		l.Expires = s.now().Add(addr.ValidLifetime)
+	}
+	s.record(l)
+	return resp, false
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leases
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+)
+
+var testMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+// open returns the store of the file, reading the time from the clock.
+func open(t *testing.T, file string, clock *time.Time) *Store {
+	t.Helper()
+	s, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *clock }
+	return s
+}
+
+// reply4 runs the handler of the store on a reply of the type to a request from testMAC.
+func reply4(t *testing.T, s *Store, reqType, respType dhcpv4.MessageType, ip string, bootz bool) {
+	t.Helper()
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(reqType), dhcpv4.WithHwAddr(testMAC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(respType),
+		dhcpv4.WithYourIP(net.ParseIP(ip)),
+		dhcpv4.WithLeaseTime(600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootz {
+		opt, err := plbootz.OptV4SZTPRedirect("bootz://192.0.2.1:15006")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.UpdateOption(opt)
+	}
+	if _, stop := s.Handler4(req, resp); stop {
+		t.Errorf("Handler4() stopped the plugin chain")
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	t.Parallel()
+	clock := time.Unix(1700000000, 0).UTC()
+	file := filepath.Join(t.TempDir(), "leases.json")
+	s := open(t, file, &clock)
+
+	reply4(t, s, dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeOffer, "192.0.2.10", false)
+	created := clock
+	clock = clock.Add(time.Second)
+	reply4(t, s, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeAck, "192.0.2.10", true)
+	// Responses without an address are not leases.
+	reply4(t, s, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeNak, "0.0.0.0", false)
+
+	want := []*Lease{{
+		IP:              "192.0.2.10",
+		MAC:             testMAC.String(),
+		State:           StateBound,
+		BootzOptionSent: true,
+		Created:         created,
+		Updated:         clock,
+		Expires:         clock.Add(10 * time.Minute),
+	}}
+	if diff := cmp.Diff(want, s.List()); diff != "" {
+		t.Errorf("List() diff (-want +got):\n%s", diff)
+	}
+
+	// The leases survive a restart once they are saved.
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush() err = %v", err)
+	}
+	if diff := cmp.Diff(want, open(t, file, &clock).List()); diff != "" {
+		t.Errorf("List() after a restart diff (-want +got):\n%s", diff)
+	}
+
+	clock = clock.Add(time.Second)
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease), dhcpv4.WithHwAddr(testMAC), dhcpv4.WithClientIP(net.ParseIP("192.0.2.10")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stop := s.Handler4(req, nil); stop {
+		t.Errorf("Handler4() stopped the plugin chain")
+	}
+	if got := s.List(); len(got) != 1 || got[0].State != StateReleased || !got[0].Expires.Equal(clock) {
+		t.Errorf("List() after release = %+v, want a lease released at %v", got, clock)
+	}
+}
+
+func TestHandler6(t *testing.T) {
+	t.Parallel()
+	clock := time.Unix(1700000000, 0).UTC()
+	s := open(t, "", &clock)
+	req, err := dhcpv6.NewSolicit(testMAC, dhcpv6.WithOption(&dhcpv6.OptVendorOpts{
+		EnterpriseNumber: 9,
+		VendorOpts:       dhcpv6.Options{&dhcpv6.OptionGeneric{OptionCode: 4, OptionData: []byte("FOX2506P2QT")}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.MessageType = dhcpv6.MessageTypeRequest
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: req.Options.OneIANA().IaId,
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10"), ValidLifetime: time.Hour},
+		}},
+	})
+	s.Handler6(req, resp)
+
+	got := s.List()
+	if len(got) != 1 || got[0].IP != "2001:db8::10" || got[0].MAC != testMAC.String() || got[0].DUID == "" || got[0].State != StateBound || got[0].BootzOptionSent {
+		t.Fatalf("List() = %+v, want a bound lease of 2001:db8::10 to %v without the bootz option", got, testMAC)
+	}
+	if got[0].Manufacturer != "Cisco" || got[0].Serial != "FOX2506P2QT" {
+		t.Errorf("List() vendor class = %q %q, want Cisco FOX2506P2QT", got[0].Manufacturer, got[0].Serial)
+	}
+	if want := clock.Add(time.Hour); !got[0].Expires.Equal(want) {
+		t.Errorf("List() expiry = %v, want %v", got[0].Expires, want)
+	}
+
+	req.MessageType = dhcpv6.MessageTypeRelease
+	s.Handler6(req, resp)
+	if got := s.List(); len(got) != 1 || got[0].State != StateReleased {
+		t.Errorf("List() after release = %+v, want a released lease", got)
+	}
+}
+
+func TestSaveIsBatched(t *testing.T) {
+	t.Parallel()
+	clock := time.Unix(1700000000, 0).UTC()
+	file := filepath.Join(t.TempDir(), "leases.json")
+	s := open(t, file, &clock)
+
+	reply4(t, s, dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeOffer, "192.0.2.10", false)
+	reply4(t, s, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeAck, "192.0.2.10", false)
+	// The leases are saved off the packet path, shortly after they change.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if got := open(t, file, &clock).List(); len(got) == 1 && got[0].State == StateBound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leases not saved within %v", 10*time.Second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Flush(); err != nil {
+		t.Errorf("Flush() without changes err = %v", err)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.json")
+	s4, err := setup(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s6, err := setup(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s4 != s6 {
+		t.Errorf("setup() of the same file returned different stores")
+	}
+	if _, err := setup(file, file); err == nil {
+		t.Errorf("setup() with two files err = nil, want an error")
+	}
+}
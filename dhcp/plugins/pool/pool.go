@@ -0,0 +1,532 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pool implements a dhcp server plugin which assigns addresses from ranges
+// to the clients without a static lease.
+//
+// The plugin must come after slease, so that static leases win. Unknown devices get
+// a short lease by default, so that they move to their static lease soon after they
+// are added to the inventory, and they get the bootz redirect like any other device.
+//
+// Leases are extended by renewing requests. Releases free the address at once.
+// The coredhcp server does not pass DHCPv4 releases to plugins, unlike the bootz
+// dhcp server, so released DHCPv4 addresses coredhcp serves are reused once their
+// lease expires.
+package pool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/openconfig/bootz/dhcp/relay"
+)
+
+var log = logger.GetLogger("plugins/pool")
+
+var Plugin = plugins.Plugin{
+	Name:   "pool",
+	Setup4: setup4,
+	Setup6: setup6,
+}
+
+// DefaultLeaseTime is the lease time of pools which do not set one. It is kept
+// short, as the devices getting addresses from pools are not in the inventory yet.
+const DefaultLeaseTime = 5 * time.Minute
+
+// errNoAddress is returned when every address of a pool is leased.
+var errNoAddress = errors.New("no address left in the pool")
+
+// lease is an address assigned to a client.
+type lease struct {
+	addr   netip.Addr
+	client string
+	expiry time.Time
+}
+
+// pool assigns the addresses of a range. It is safe for concurrent use.
+type pool struct {
+	start, end netip.Addr
+	leaseTime  time.Duration
+	// index is the position of the pool in the arguments of New.
+	index int
+	// subnet is the subnet of the range, which relayed clients must be on.
+	subnet *net.IPNet
+	// mask and gateway are sent with IPv4 leases.
+	mask    net.IPMask
+	gateway net.IP
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	byClient map[string]*lease
+	byAddr   map[netip.Addr]*lease
+}
+
+func newPool(start, end netip.Addr, leaseTime time.Duration) (*pool, error) {
+	if start.Is4() != end.Is4() {
+		return nil, fmt.Errorf("range %v-%v mixes address families", start, end)
+	}
+	if end.Less(start) {
+		return nil, fmt.Errorf("range %v-%v ends before it starts", start, end)
+	}
+	if leaseTime <= 0 {
+		leaseTime = DefaultLeaseTime
+	}
+	return &pool{
+		start:     start,
+		end:       end,
+		leaseTime: leaseTime,
+		now:       time.Now,
+		byClient:  map[string]*lease{},
+		byAddr:    map[netip.Addr]*lease{},
+	}, nil
+}
+
+func (p *pool) contains(addr netip.Addr) bool {
+	return addr.IsValid() && !addr.Less(p.start) && !p.end.Less(addr)
+}
+
+// free reports whether the address can be leased to the client.
+// The caller must hold p.mu.
+func (p *pool) free(addr netip.Addr, client string, now time.Time) bool {
+	l, ok := p.byAddr[addr]
+	return !ok || l.client == client || now.After(l.expiry)
+}
+
+// lookup returns the unexpired lease of the client, if any.
+func (p *pool) lookup(client string) *lease {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.byClient[client]; ok && !p.now().After(l.expiry) {
+		return l
+	}
+	return nil
+}
+
+// allocate leases an address to the client, or extends its lease. A client keeps
+// its address while it has one. Otherwise it gets the hinted address if that is free,
+// or the first free address of the range.
+func (p *pool) allocate(client string, hint netip.Addr) (*lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	addr, ok := netip.Addr{}, false
+	if l, found := p.byClient[client]; found && p.free(l.addr, client, now) {
+		addr, ok = l.addr, true
+	}
+	if !ok && p.contains(hint) && p.free(hint, client, now) {
+		addr, ok = hint, true
+	}
+	for a := p.start; !ok && a.IsValid() && !p.end.Less(a); a = a.Next() {
+		if p.free(a, client, now) {
+			addr, ok = a, true
+		}
+	}
+	if !ok {
+		return nil, errNoAddress
+	}
+	if old, found := p.byClient[client]; found && old.addr != addr {
+		delete(p.byAddr, old.addr)
+	}
+	if old, found := p.byAddr[addr]; found && old.client != client {
+		delete(p.byClient, old.client)
+	}
+	l := &lease{addr: addr, client: client, expiry: now.Add(p.leaseTime)}
+	p.byClient[client] = l
+	p.byAddr[addr] = l
+	return l, nil
+}
+
+// reserve leases the address to the client until expiry, if it is in the range
+// and free.
+func (p *pool) reserve(client string, addr netip.Addr, expiry time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if !p.contains(addr) || !now.Before(expiry) || !p.free(addr, client, now) {
+		return false
+	}
+	if old, found := p.byClient[client]; found {
+		delete(p.byAddr, old.addr)
+	}
+	l := &lease{addr: addr, client: client, expiry: expiry}
+	p.byClient[client] = l
+	p.byAddr[addr] = l
+	return true
+}
+
+// release frees the address leased to the client, if any.
+func (p *pool) release(client string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.byClient[client]; ok {
+		delete(p.byClient, client)
+		delete(p.byAddr, l.addr)
+	}
+}
+
+// onLink reports whether the pool is on the link of the client's relay, if any.
+func (p *pool) onLink(info *relay.Info) bool {
+	return p.subnet == nil || info.OnLink(p.subnet)
+}
+
+// allocate leases an address from the first pool with one free on the client's
+// link, preferring the pool which already leased one to the client.
+func allocate(pools []*pool, client string, hint netip.Addr, onLink func(*pool) bool) (*pool, *lease, error) {
+	for _, p := range pools {
+		if onLink(p) && p.lookup(client) != nil {
+			l, err := p.allocate(client, hint)
+			return p, l, err
+		}
+	}
+	for _, p := range pools {
+		if !onLink(p) {
+			continue
+		}
+		l, err := p.allocate(client, hint)
+		if err == nil {
+			return p, l, nil
+		}
+	}
+	return nil, nil, errNoAddress
+}
+
+// Pools are the address pools of a dhcp server. Each dhcp server has its own.
+type Pools struct {
+	v4 []*pool
+	v6 []*pool
+	// onInterface is set for the pools served on a single interface, whose
+	// addresses are addrs.
+	onInterface bool
+	addrs       []net.IP
+}
+
+// New returns the pools of the arguments: IPv4 pools formatted as
+// start/prefix,end,gw[,lease_time] and IPv6 pools as start/prefix,end[,lease_time].
+func New(args ...string) (*Pools, error) {
+	ps := &Pools{}
+	for i, arg := range args {
+		if strings.Count(strings.SplitN(arg, ",", 2)[0], ":") >= 2 {
+			if err := ps.add6(i, arg); err != nil {
+				return nil, err
+			}
+		} else if err := ps.add4(i, arg); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// Reserve leases the address to the client until expiry, if it is in one of the
+// pools and free, e.g. to restore the leases saved before a restart. DHCPv4
+// clients are identified by "id:" and their hex encoded client identifier, or
+// else by their MAC address, and DHCPv6 clients by their hex encoded DUID.
+func (ps *Pools) Reserve(client string, addr netip.Addr, expiry time.Time) bool {
+	pools := ps.v6
+	if addr.Is4() {
+		pools = ps.v4
+	}
+	for _, p := range pools {
+		if p.reserve(client, addr, expiry) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnInterface returns the pools served on a network interface with the given
+// addresses: those for which keep returns true, given their index in the
+// arguments of New. They share their leases with ps. Clients which were not
+// relayed, or whose relay did not tell its link, only get addresses from the
+// pools whose subnet holds one of the addresses of the interface.
+func (ps *Pools) OnInterface(addrs []net.IP, keep func(index int) bool) *Pools {
+	on := &Pools{onInterface: true, addrs: addrs}
+	for _, p := range ps.v4 {
+		if keep(p.index) {
+			on.v4 = append(on.v4, p)
+		}
+	}
+	for _, p := range ps.v6 {
+		if keep(p.index) {
+			on.v6 = append(on.v6, p)
+		}
+	}
+	return on
+}
+
+// onLink returns whether the pool is on the link of the client, given what
+// its relay added to its request, if anything.
+func (ps *Pools) onLink(info *relay.Info) func(*pool) bool {
+	return func(p *pool) bool {
+		if !ps.onInterface || p.subnet == nil || (info != nil && info.Link != nil) {
+			return p.onLink(info)
+		}
+		for _, a := range ps.addrs {
+			if p.subnet.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (ps *Pools) add4(index int, arg string) error {
+	p, err := parsePool4(arg)
+	if err != nil {
+		return err
+	}
+	p.index = index
+	ps.v4 = append(ps.v4, p)
+	log.Debugf("Added ipv4 pool: %v-%v, %v, %v", p.start, p.end, p.gateway, p.leaseTime)
+	return nil
+}
+
+func (ps *Pools) add6(index int, arg string) error {
+	p, err := parsePool6(arg)
+	if err != nil {
+		return err
+	}
+	p.index = index
+	ps.v6 = append(ps.v6, p)
+	log.Debugf("Added ipv6 pool: %v-%v, %v", p.start, p.end, p.leaseTime)
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	ps := &Pools{}
+	for i, arg := range args {
+		if err := ps.add4(i, arg); err != nil {
+			return nil, err
+		}
+	}
+	return ps.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	ps := &Pools{}
+	for i, arg := range args {
+		if err := ps.add6(i, arg); err != nil {
+			return nil, err
+		}
+	}
+	return ps.Handler6, nil
+}
+
+// clientID4 identifies a DHCPv4 client by its client identifier, or its MAC address without one.
+func clientID4(req *dhcpv4.DHCPv4) string {
+	if cid := req.GetOneOption(dhcpv4.OptionClientIdentifier); len(cid) > 0 {
+		return "id:" + hex.EncodeToString(cid)
+	}
+	return req.ClientHWAddr.String()
+}
+
+// Handler4 handles DHCPv4 packets for the pool plugin.
+func (ps *Pools) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if len(ps.v4) == 0 {
+		return resp, false
+	}
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		client := clientID4(req)
+		for _, p := range ps.v4 {
+			p.release(client)
+		}
+		return resp, false
+	}
+	if !resp.YourIPAddr.IsUnspecified() {
+		// The client has a static lease.
+		return resp, false
+	}
+	requested, _ := netip.AddrFromSlice(req.RequestedIPAddress().To4())
+	if !requested.IsValid() || requested.IsUnspecified() {
+		requested, _ = netip.AddrFromSlice(req.ClientIPAddr.To4())
+	}
+	client := clientID4(req)
+	p, l, err := allocate(ps.v4, client, requested, ps.onLink(relay.FromV4(req)))
+	if err != nil {
+		log.Warningf("Unable to lease an address to %v: %v", client, err)
+		return resp, false
+	}
+	if req.MessageType() == dhcpv4.MessageTypeRequest && requested.IsValid() && !requested.IsUnspecified() && requested != l.addr {
+		log.Infof("Client %v requested %v, which is not its lease %v", client, requested, l.addr)
+		resp.YourIPAddr = net.IPv4zero
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+		return resp, true
+	}
+	log.Debugf("Leasing %v to %v until %v", l.addr, client, l.expiry)
+	resp.YourIPAddr = net.IP(l.addr.AsSlice())
+	resp.Options.Update(dhcpv4.OptSubnetMask(p.mask))
+	if p.gateway != nil {
+		resp.Options.Update(dhcpv4.OptRouter(p.gateway))
+	}
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.leaseTime))
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the pool plugin.
+func (ps *Pools) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if len(ps.v6) == 0 {
+		return resp, false
+	}
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate request: %v", err)
+		return nil, true
+	}
+	iana := m.Options.OneIANA()
+	if iana == nil || m.Options.ClientID() == nil {
+		return resp, false
+	}
+	if r, ok := resp.(*dhcpv6.Message); ok && r.Options.OneIANA() != nil {
+		// The client has a static lease.
+		return resp, false
+	}
+	client := hex.EncodeToString(m.Options.ClientID().ToBytes())
+	switch m.Type() {
+	case dhcpv6.MessageTypeRelease:
+		for _, p := range ps.v6 {
+			p.release(client)
+		}
+		return resp, false
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		return resp, false
+	}
+	var hint netip.Addr
+	if a := iana.Options.OneAddress(); a != nil {
+		hint, _ = netip.AddrFromSlice(a.IPv6Addr)
+	}
+	p, l, err := allocate(ps.v6, client, hint, ps.onLink(relay.FromV6(req)))
+	if err != nil {
+		log.Warningf("Unable to lease an address to %v: %v", client, err)
+		return resp, false
+	}
+	log.Debugf("Leasing %v to %v until %v", l.addr, client, l.expiry)
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: iana.IaId,
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{
+				IPv6Addr:          net.IP(l.addr.AsSlice()),
+				PreferredLifetime: p.leaseTime,
+				ValidLifetime:     p.leaseTime,
+			},
+		}},
+	})
+	return resp, false
+}
+
+// parseRange parses the start/prefix,end pair of a pool.
+func parseRange(startArg, endArg string) (netip.Prefix, netip.Addr, error) {
+	start, err := netip.ParsePrefix(startArg)
+	if err != nil {
+		return netip.Prefix{}, netip.Addr{}, fmt.Errorf("invalid range start %v", startArg)
+	}
+	end, err := netip.ParseAddr(endArg)
+	if err != nil {
+		return netip.Prefix{}, netip.Addr{}, fmt.Errorf("invalid range end %v", endArg)
+	}
+	if !start.Masked().Contains(end) {
+		return netip.Prefix{}, netip.Addr{}, fmt.Errorf("range end %v is not in the subnet %v", end, start.Masked())
+	}
+	return start, end, nil
+}
+
+func parseLeaseTime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid lease time %v", s)
+	}
+	return d, nil
+}
+
+func parsePool4(arg string) (*pool, error) {
+	//format: start/prefix,end,gw[,leasetime]
+	parts := strings.Split(arg, ",")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("invalid pool %v", arg)
+	}
+	start, end, err := parseRange(parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if !start.Addr().Is4() {
+		return nil, fmt.Errorf("invalid ipv4 pool %v", arg)
+	}
+	var gw net.IP
+	if parts[2] != "" {
+		if gw = net.ParseIP(parts[2]); gw == nil {
+			return nil, fmt.Errorf("invalid gw address %v", parts[2])
+		}
+	}
+	var leaseTime time.Duration
+	if len(parts) == 4 {
+		if leaseTime, err = parseLeaseTime(parts[3]); err != nil {
+			return nil, err
+		}
+	}
+	p, err := newPool(start.Addr(), end, leaseTime)
+	if err != nil {
+		return nil, err
+	}
+	p.subnet = prefixNet(start)
+	p.mask = p.subnet.Mask
+	p.gateway = gw
+	return p, nil
+}
+
+func parsePool6(arg string) (*pool, error) {
+	//format: start/prefix,end[,leasetime]
+	parts := strings.Split(arg, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid pool %v", arg)
+	}
+	start, end, err := parseRange(parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if start.Addr().Is4() {
+		return nil, fmt.Errorf("invalid ipv6 pool %v", arg)
+	}
+	var leaseTime time.Duration
+	if len(parts) == 3 {
+		if leaseTime, err = parseLeaseTime(parts[2]); err != nil {
+			return nil, err
+		}
+	}
+	p, err := newPool(start.Addr(), end, leaseTime)
+	if err != nil {
+		return nil, err
+	}
+	p.subnet = prefixNet(start)
+	return p, nil
+}
+
+// prefixNet returns the subnet of the prefix.
+func prefixNet(prefix netip.Prefix) *net.IPNet {
+	addr := prefix.Masked().Addr()
+	return &net.IPNet{IP: addr.AsSlice(), Mask: net.CIDRMask(prefix.Bits(), addr.BitLen())}
+}
@@ -0,0 +1,351 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pool
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/h-fam/errdiff"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// fakeClock is a settable clock for pools.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestPool(t *testing.T, start, end string, leaseTime time.Duration) (*pool, *fakeClock) {
+	t.Helper()
+	p, err := newPool(netip.MustParseAddr(start), netip.MustParseAddr(end), leaseTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	p.now = clock.now
+	return p, clock
+}
+
+func TestPoolAllocate(t *testing.T) {
+	p, clock := newTestPool(t, "192.0.2.100", "192.0.2.101", time.Minute)
+	mustAllocate := func(client string, hint netip.Addr, want string) {
+		t.Helper()
+		l, err := p.allocate(client, hint)
+		if err != nil {
+			t.Fatalf("allocate(%v) err = %v", client, err)
+		}
+		if l.addr.String() != want {
+			t.Errorf("allocate(%v) = %v, want %v", client, l.addr, want)
+		}
+	}
+
+	mustAllocate("a", netip.Addr{}, "192.0.2.100")
+	mustAllocate("b", netip.Addr{}, "192.0.2.101")
+	// A client keeps its address, whatever it asks for.
+	mustAllocate("a", netip.MustParseAddr("192.0.2.101"), "192.0.2.100")
+	if _, err := p.allocate("c", netip.Addr{}); err != errNoAddress {
+		t.Errorf("allocate() from a full pool err = %v, want %v", err, errNoAddress)
+	}
+
+	// Renewing extends the lease, so only b's address expires.
+	clock.t = clock.t.Add(40 * time.Second)
+	mustAllocate("a", netip.Addr{}, "192.0.2.100")
+	clock.t = clock.t.Add(40 * time.Second)
+	if p.lookup("b") != nil {
+		t.Errorf("lookup() found an expired lease")
+	}
+	mustAllocate("c", netip.Addr{}, "192.0.2.101")
+
+	// Released addresses are reused at once, and a free hinted address is honoured.
+	p.release("a")
+	mustAllocate("d", netip.MustParseAddr("192.0.2.100"), "192.0.2.100")
+	// Hints outside the range are ignored.
+	p.release("d")
+	mustAllocate("e", netip.MustParseAddr("192.0.2.7"), "192.0.2.100")
+}
+
+func TestPoolReserve(t *testing.T) {
+	p, clock := newTestPool(t, "192.0.2.100", "192.0.2.101", time.Minute)
+	addr := netip.MustParseAddr("192.0.2.101")
+	if !p.reserve("a", addr, clock.t.Add(time.Hour)) {
+		t.Fatalf("reserve(a) = false, want true")
+	}
+	if p.reserve("b", addr, clock.t.Add(time.Hour)) {
+		t.Errorf("reserve(b) of a leased address = true, want false")
+	}
+	if p.reserve("b", netip.MustParseAddr("192.0.2.102"), clock.t.Add(time.Hour)) {
+		t.Errorf("reserve(b) of an address out of the range = true, want false")
+	}
+	if p.reserve("b", netip.MustParseAddr("192.0.2.100"), clock.t) {
+		t.Errorf("reserve(b) of an expired lease = true, want false")
+	}
+	// The client keeps its reserved address, and others do not get it.
+	if l, err := p.allocate("b", addr); err != nil || l.addr.String() != "192.0.2.100" {
+		t.Errorf("allocate(b) = %v, %v, want 192.0.2.100", l, err)
+	}
+	if l, err := p.allocate("a", netip.Addr{}); err != nil || l.addr != addr {
+		t.Errorf("allocate(a) = %v, %v, want %v", l, err, addr)
+	}
+}
+
+func TestParsePool(t *testing.T) {
+	tests := []struct {
+		desc          string
+		arg           string
+		v6            bool
+		wantLeaseTime time.Duration
+		wantErr       string
+	}{{
+		desc:          "IPv4 pool",
+		arg:           "192.0.2.100/24,192.0.2.199,192.0.2.1,10m",
+		wantLeaseTime: 10 * time.Minute,
+	}, {
+		desc:          "IPv4 pool with default lease time",
+		arg:           "192.0.2.100/24,192.0.2.199,192.0.2.1",
+		wantLeaseTime: DefaultLeaseTime,
+	}, {
+		desc:          "IPv6 pool",
+		arg:           "2001:db8::100/64,2001:db8::1ff,1h",
+		v6:            true,
+		wantLeaseTime: time.Hour,
+	}, {
+		desc:    "End outside the subnet",
+		arg:     "192.0.2.100/24,192.0.3.1,192.0.2.1",
+		wantErr: "not in the subnet",
+	}, {
+		desc:    "End before start",
+		arg:     "192.0.2.100/24,192.0.2.99,192.0.2.1",
+		wantErr: "ends before it starts",
+	}, {
+		desc:    "IPv6 range in an IPv4 pool",
+		arg:     "2001:db8::100/64,2001:db8::1ff,",
+		wantErr: "invalid ipv4 pool",
+	}, {
+		desc:    "Invalid lease time",
+		arg:     "2001:db8::100/64,2001:db8::1ff,forever",
+		v6:      true,
+		wantErr: "invalid lease time",
+	}, {
+		desc:    "Missing end",
+		arg:     "192.0.2.100/24",
+		wantErr: "invalid pool",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			parse := parsePool4
+			if test.v6 {
+				parse = parsePool6
+			}
+			p, err := parse(test.arg)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("parse(%q) %s", test.arg, s)
+			}
+			if err != nil {
+				return
+			}
+			if p.leaseTime != test.wantLeaseTime {
+				t.Errorf("parse(%q) lease time = %v, want %v", test.arg, p.leaseTime, test.wantLeaseTime)
+			}
+		})
+	}
+}
+
+func newPools(t *testing.T, args ...string) *Pools {
+	t.Helper()
+	ps, err := New(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestNew(t *testing.T) {
+	ps := newPools(t, "192.0.2.100/24,192.0.2.199,192.0.2.1", "2001:db8::100/64,2001:db8::1ff,1h")
+	if len(ps.v4) != 1 || len(ps.v6) != 1 {
+		t.Errorf("New() = %d IPv4 and %d IPv6 pools, want one of each", len(ps.v4), len(ps.v6))
+	}
+	if _, err := New("2001:db8::100/64,2001:db8::1ff,2001:db8::1,1h"); err == nil {
+		t.Errorf("New() with a gateway in an IPv6 pool err = nil, want an error")
+	}
+	if _, err := setup4("2001:db8::100/64,2001:db8::1ff"); err == nil {
+		t.Errorf("setup4() with an IPv6 pool err = nil, want an error")
+	}
+}
+
+func newRequest4(t *testing.T, mt dhcpv4.MessageType, mac net.HardwareAddr, mods ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+	t.Helper()
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{dhcpv4.WithMessageType(mt), dhcpv4.WithHwAddr(mac)}, mods...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server sets the reply type before running the plugins.
+	reply := dhcpv4.MessageTypeOffer
+	if mt == dhcpv4.MessageTypeRequest {
+		reply = dhcpv4.MessageTypeAck
+	}
+	resp.UpdateOption(dhcpv4.OptMessageType(reply))
+	return req, resp
+}
+
+func TestHandler4(t *testing.T) {
+	t.Parallel()
+	handler4 := newPools(t, "192.0.2.100/24,192.0.2.101,192.0.2.1,30s").Handler4
+	macA := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	macB := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+
+	req, resp := newRequest4(t, dhcpv4.MessageTypeDiscover, macA)
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" {
+		t.Errorf("offer address = %v, want 192.0.2.100", got)
+	}
+	if got := resp.SubnetMask().String(); got != "ffffff00" {
+		t.Errorf("offer netmask = %v, want ffffff00", got)
+	}
+	if got := resp.Router(); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("offer router = %v, want 192.0.2.1", got)
+	}
+	if got := resp.IPAddressLeaseTime(0); got != 30*time.Second {
+		t.Errorf("offer lease time = %v, want 30s", got)
+	}
+
+	// Renewing keeps the address.
+	req, resp = newRequest4(t, dhcpv4.MessageTypeRequest, macA, dhcpv4.WithClientIP(net.ParseIP("192.0.2.100")))
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" || resp.MessageType() != dhcpv4.MessageTypeAck {
+		t.Errorf("renew = %v %v, want ACK of 192.0.2.100", resp.MessageType(), got)
+	}
+
+	// Asking for another client's address is refused.
+	req, resp = newRequest4(t, dhcpv4.MessageTypeRequest, macB, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.0.2.100"))))
+	resp, stop := handler4(req, resp)
+	if resp.MessageType() != dhcpv4.MessageTypeNak || !stop {
+		t.Errorf("request for a leased address = %v, want a NAK ending the plugin chain", resp.MessageType())
+	}
+
+	// Released addresses are reused at once.
+	req, resp = newRequest4(t, dhcpv4.MessageTypeRelease, macA, dhcpv4.WithClientIP(net.ParseIP("192.0.2.100")))
+	handler4(req, resp)
+	req, resp = newRequest4(t, dhcpv4.MessageTypeDiscover, net.HardwareAddr{0, 0, 0, 0, 0, 0xc})
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" {
+		t.Errorf("offer after a release = %v, want the released 192.0.2.100", got)
+	}
+
+	// Static leases win.
+	req, resp = newRequest4(t, dhcpv4.MessageTypeDiscover, macB)
+	resp.YourIPAddr = net.ParseIP("198.51.100.1")
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "198.51.100.1" {
+		t.Errorf("offer with a static lease = %v, want 198.51.100.1", got)
+	}
+}
+
+func TestHandler4Relayed(t *testing.T) {
+	t.Parallel()
+	handler4 := newPools(t, "192.0.2.100/24,192.0.2.199,192.0.2.1", "198.51.100.100/24,198.51.100.199,198.51.100.1").Handler4
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+	req, resp := newRequest4(t, dhcpv4.MessageTypeDiscover, mac, dhcpv4.WithGatewayIP(net.ParseIP("198.51.100.1")))
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "198.51.100.100" {
+		t.Errorf("offer through a relay = %v, want 198.51.100.100 from the pool of the relay link", got)
+	}
+	req, resp = newRequest4(t, dhcpv4.MessageTypeDiscover, mac, dhcpv4.WithGatewayIP(net.ParseIP("203.0.113.1")))
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr; got != nil && !got.IsUnspecified() {
+		t.Errorf("offer through a relay without a pool = %v, want none", got)
+	}
+}
+
+func TestOnInterface(t *testing.T) {
+	t.Parallel()
+	ps := newPools(t, "192.0.2.100/24,192.0.2.199,192.0.2.1", "198.51.100.100/24,198.51.100.199,198.51.100.1", "203.0.113.100/24,203.0.113.199,203.0.113.1")
+	// The third pool is served on another interface.
+	handler4 := ps.OnInterface([]net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1")}, func(i int) bool { return i != 2 }).Handler4
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+	req, resp := newRequest4(t, dhcpv4.MessageTypeDiscover, mac)
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "198.51.100.100" {
+		t.Errorf("offer on the interface = %v, want 198.51.100.100 from the pool of its subnet", got)
+	}
+	req, resp = newRequest4(t, dhcpv4.MessageTypeDiscover, mac, dhcpv4.WithGatewayIP(net.ParseIP("192.0.2.1")))
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr.String(); got != "192.0.2.100" {
+		t.Errorf("offer through a relay = %v, want 192.0.2.100 from the pool of the relay link", got)
+	}
+	req, resp = newRequest4(t, dhcpv4.MessageTypeDiscover, mac, dhcpv4.WithGatewayIP(net.ParseIP("203.0.113.1")))
+	resp, _ = handler4(req, resp)
+	if got := resp.YourIPAddr; got != nil && !got.IsUnspecified() {
+		t.Errorf("offer through a relay to a pool of another interface = %v, want none", got)
+	}
+}
+
+func newMessage6(t *testing.T, mt dhcpv6.MessageType, mac net.HardwareAddr) (*dhcpv6.Message, *dhcpv6.Message) {
+	t.Helper()
+	req, err := dhcpv6.NewSolicit(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.MessageType = mt
+	resp, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.MessageType = dhcpv6.MessageTypeReply
+	return req, resp
+}
+
+// leased6 returns the address in the IA_NA of a DHCPv6 response, if any.
+func leased6(resp dhcpv6.DHCPv6) string {
+	iana := resp.(*dhcpv6.Message).Options.OneIANA()
+	if iana == nil || iana.Options.OneAddress() == nil {
+		return ""
+	}
+	return iana.Options.OneAddress().IPv6Addr.String()
+}
+
+func TestHandler6(t *testing.T) {
+	t.Parallel()
+	handler6 := newPools(t, "2001:db8::100/64,2001:db8::100,1m").Handler6
+	macA := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	macB := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+
+	req, resp := newMessage6(t, dhcpv6.MessageTypeSolicit, macA)
+	reply, _ := handler6(req, resp)
+	if got := leased6(reply); got != "2001:db8::100" {
+		t.Errorf("advertised address = %q, want 2001:db8::100", got)
+	}
+	// The only address is leased, so the next client gets none until it is released.
+	req, resp = newMessage6(t, dhcpv6.MessageTypeSolicit, macB)
+	if reply, _ := handler6(req, resp); leased6(reply) != "" {
+		t.Errorf("advertised address from a full pool = %q, want none", leased6(reply))
+	}
+	req, resp = newMessage6(t, dhcpv6.MessageTypeRelease, macA)
+	handler6(req, resp)
+	req, resp = newMessage6(t, dhcpv6.MessageTypeRequest, macB)
+	if reply, _ := handler6(req, resp); leased6(reply) != "2001:db8::100" {
+		t.Errorf("address after release = %q, want 2001:db8::100", leased6(reply))
+	}
+}
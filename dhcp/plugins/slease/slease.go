@@ -16,9 +16,12 @@
 package slease
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -27,6 +30,11 @@ import (
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/openconfig/bootz/dhcp/relay"
+	"github.com/openconfig/bootz/dhcp/vendorclass"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
 )
 
 var log = logger.GetLogger("plugins/slease")
@@ -38,58 +46,345 @@ var Plugin = plugins.Plugin{
 }
 
 type ipv4Entry struct {
+	// ip is nil for records of options only, whose clients get their address
+	// from the pools.
 	ip      net.IP
 	netmask net.IPMask
 	gateway net.IP
+	// bootz, if set, is the sZTP redirect option of the client, which replaces the
+	// one of the bootz plugin.
+	bootz *dhcpv4.Option
+	// options are the other options of the client, see Options.
+	options []dhcpv4.Option
+}
+
+type ipv6Entry struct {
+	// ip is nil for records of options only, as for ipv4Entry.
+	ip     net.IP
+	subnet *net.IPNet
+	bootz  dhcpv6.Option
+	// lifetime is the valid and preferred lifetime of the address.
+	lifetime time.Duration
+	options  []dhcpv6.Option
+}
+
+// defaultLifetime is the lifetime of the IPv6 addresses of the records without a lease time.
+const defaultLifetime = time.Hour
+
+// Options are the options of a record besides its address and bootz servers.
+// They are sent to the client whether or not it asked for them.
+type Options struct {
+	// NTP are the ntp servers of the client: IPv4 addresses for IPv4 records,
+	// IPv6 addresses or names for IPv6 records.
+	NTP        []string
+	DomainName string
+	// Hostname is the name of the client. IPv6 clients get it in the client FQDN
+	// option, in the domain if the name is not qualified.
+	Hostname string
+	// MTU is the MTU of the interface of IPv4 clients. DHCPv6 has no MTU option.
+	MTU uint16
+	// LeaseTime is how long the address is leased for, an hour if zero.
+	LeaseTime time.Duration
+	// Raw are options sent as is, keyed by their DHCPv4 code for IPv4 records
+	// and their DHCPv6 code for IPv6 records.
+	Raw map[uint16][]byte
+}
+
+// rawCodes returns the codes of the raw options in order.
+func (o *Options) rawCodes() []uint16 {
+	var codes []uint16
+	for c := range o.Raw {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// v4 returns the DHCPv4 options of an IPv4 record.
+func (o *Options) v4() ([]dhcpv4.Option, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var opts []dhcpv4.Option
+	if len(o.NTP) > 0 {
+		var ips []net.IP
+		for _, s := range o.NTP {
+			ip := net.ParseIP(s).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("ntp server %q is not an IPv4 address", s)
+			}
+			ips = append(ips, ip)
+		}
+		opts = append(opts, dhcpv4.OptNTPServers(ips...))
+	}
+	if o.DomainName != "" {
+		opts = append(opts, dhcpv4.OptDomainName(o.DomainName))
+	}
+	if o.Hostname != "" {
+		opts = append(opts, dhcpv4.OptHostName(o.Hostname))
+	}
+	if o.MTU != 0 {
+		// RFC 2132 section 5.1: the minimum MTU is 68.
+		if o.MTU < 68 {
+			return nil, fmt.Errorf("invalid mtu %d", o.MTU)
+		}
+		opts = append(opts, dhcpv4.OptGeneric(dhcpv4.OptionInterfaceMTU, binary.BigEndian.AppendUint16(nil, o.MTU)))
+	}
+	switch {
+	case o.LeaseTime < 0:
+		return nil, fmt.Errorf("invalid lease time %v", o.LeaseTime)
+	case o.LeaseTime > 0:
+		opts = append(opts, dhcpv4.OptIPAddressLeaseTime(o.LeaseTime))
+	}
+	for _, c := range o.rawCodes() {
+		if c == 0 || c > 254 {
+			return nil, fmt.Errorf("invalid DHCPv4 option code %d", c)
+		}
+		opts = append(opts, dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(c), o.Raw[c]))
+	}
+	return opts, nil
 }
 
-var ipv4Records = map[string]*ipv4Entry{}
-var ipv6Records = map[string]net.IP{}
+// v6 returns the DHCPv6 options of an IPv6 record and the lifetime of its address.
+func (o *Options) v6() ([]dhcpv6.Option, time.Duration, error) {
+	if o == nil {
+		return nil, defaultLifetime, nil
+	}
+	var opts []dhcpv6.Option
+	if len(o.NTP) > 0 {
+		ntp := &dhcpv6.OptNTPServer{}
+		for _, s := range o.NTP {
+			ip := net.ParseIP(s)
+			switch {
+			case ip == nil:
+				ntp.Suboptions = append(ntp.Suboptions, &dhcpv6.NTPSuboptionSrvFQDN{Labels: rfc1035label.Labels{Labels: []string{s}}})
+			case ip.To4() != nil:
+				return nil, 0, fmt.Errorf("ntp server %q is not an IPv6 address", s)
+			default:
+				addr := dhcpv6.NTPSuboptionSrvAddr(ip)
+				ntp.Suboptions = append(ntp.Suboptions, &addr)
+			}
+		}
+		opts = append(opts, ntp)
+	}
+	if o.DomainName != "" {
+		opts = append(opts, dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: []string{o.DomainName}}))
+	}
+	if o.Hostname != "" {
+		fqdn := o.Hostname
+		if o.DomainName != "" && !strings.Contains(fqdn, ".") {
+			fqdn += "." + o.DomainName
+		}
+		opts = append(opts, &dhcpv6.OptFQDN{DomainName: &rfc1035label.Labels{Labels: []string{fqdn}}})
+	}
+	lifetime := defaultLifetime
+	switch {
+	case o.LeaseTime < 0:
+		return nil, 0, fmt.Errorf("invalid lease time %v", o.LeaseTime)
+	case o.LeaseTime > 0:
+		lifetime = o.LeaseTime
+	}
+	for _, c := range o.rawCodes() {
+		if c == 0 {
+			return nil, 0, fmt.Errorf("invalid DHCPv6 option code %d", c)
+		}
+		opts = append(opts, &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(c), OptionData: o.Raw[c]})
+	}
+	return opts, lifetime, nil
+}
+
+// Records are the static leases of a dhcp server, which are updated while it
+// runs. Each dhcp server has its own. They are safe for concurrent use.
+type Records struct {
+	mu sync.RWMutex
+	v4 map[string]*ipv4Entry
+	v6 map[string]*ipv6Entry
+}
+
+// NewRecords returns an empty set of records.
+func NewRecords() *Records {
+	return &Records{
+		v4: map[string]*ipv4Entry{},
+		v6: map[string]*ipv6Entry{},
+	}
+}
 
 func setup4(args ...string) (handler.Handler4, error) {
+	records := NewRecords()
 	for _, r := range args {
 		if k, r, err := parseRecord4(r); err == nil {
-			ipv4Records[k] = r
+			records.v4[k] = r
 			log.Debugf("Added ipv4 record: %v, %v, %v, %v", k, r.ip, r.netmask, r.gateway)
 		} else {
 			return nil, err
 		}
 	}
-	return handler4, nil
+	return records.Handler4, nil
 }
 
 func setup6(args ...string) (handler.Handler6, error) {
+	records := NewRecords()
 	for _, r := range args {
 		if k, r, err := parseRecord6(r); err == nil {
-			ipv6Records[k] = r
-			log.Debugf("Added ipv6 record: %v, %v", k, r.String())
+			records.v6[k] = r
+			log.Debugf("Added ipv6 record: %v, %v", k, r.ip)
 		} else {
 			return nil, err
 		}
 	}
-	return handler6, nil
+	return records.Handler6, nil
+}
+
+// Set adds a record for the mac address or serial number, replacing any
+// record it already has. The ip is in CIDR notation and the gateway is only
+// used for IPv4 records. The bootz URIs, if any, are advertised to the client
+// instead of those of the bootz plugin.
+func (rs *Records) Set(key, ip, gw string, bootzURIs ...string) error {
+	return rs.SetWithOptions(key, ip, gw, nil, bootzURIs...)
+}
+
+// SetWithOptions adds a record as Set does, with the options sent to the client.
+// Without an ip, the record only sends the options and bootz URIs, and the client
+// gets its address from the pools. Such records are kept for each address family
+// the options are valid for.
+func (rs *Records) SetWithOptions(key, ip, gw string, opts *Options, bootzURIs ...string) error {
+	if ip == "" {
+		return rs.setOptions(key, opts, bootzURIs)
+	}
+	ip6 := strings.Count(ip, ":") >= 2
+	var e4 *ipv4Entry
+	var e6 *ipv6Entry
+	var err error
+	if ip6 {
+		if _, e6, err = parseRecord6(strings.Join(append([]string{key, ip}, bootzURIs...), ",")); err == nil {
+			e6.options, e6.lifetime, err = opts.v6()
+		}
+	} else {
+		if _, e4, err = parseRecord4(strings.Join(append([]string{key, ip, gw}, bootzURIs...), ",")); err == nil {
+			e4.options, err = opts.v4()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.v4, key)
+	delete(rs.v6, key)
+	if ip6 {
+		rs.v6[key] = e6
+		log.Debugf("Set ipv6 record: %v, %v", key, e6.ip)
+	} else {
+		rs.v4[key] = e4
+		log.Debugf("Set ipv4 record: %v, %v, %v, %v", key, e4.ip, e4.netmask, e4.gateway)
+	}
+	return nil
+}
+
+// setOptions adds a record of options only for the key, replacing any record it
+// already has.
+func (rs *Records) setOptions(key string, opts *Options, bootzURIs []string) error {
+	e4, e6 := &ipv4Entry{}, &ipv6Entry{}
+	var err4, err6 error
+	e4.options, err4 = opts.v4()
+	e6.options, _, err6 = opts.v6()
+	if err4 != nil && err6 != nil {
+		return fmt.Errorf("invalid options for both address families: %v; %v", err4, err6)
+	}
+	if len(bootzURIs) > 0 {
+		opt, err := plbootz.OptV4SZTPRedirect(bootzURIs...)
+		if err != nil {
+			return fmt.Errorf("invalid bootz uris %v: %v", bootzURIs, err)
+		}
+		e4.bootz = &opt
+		if e6.bootz, err = plbootz.OptV6SZTPRedirect(bootzURIs...); err != nil {
+			return fmt.Errorf("invalid bootz uris %v: %v", bootzURIs, err)
+		}
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.v4, key)
+	delete(rs.v6, key)
+	if err4 == nil {
+		rs.v4[key] = e4
+	}
+	if err6 == nil {
+		rs.v6[key] = e6
+	}
+	log.Debugf("Set options record: %v", key)
+	return nil
+}
+
+// Remove removes the record of the mac address or serial number, if any.
+func (rs *Records) Remove(key string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.v4, key)
+	delete(rs.v6, key)
+	log.Debugf("Removed record: %v", key)
+}
+
+// lookup4 returns the IPv4 record of the key, if any.
+func (rs *Records) lookup4(key string) (*ipv4Entry, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	e, ok := rs.v4[key]
+	return e, ok
 }
 
-func handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+// lookup6 returns the IPv6 record of the key, if any.
+func (rs *Records) lookup6(key string) (*ipv6Entry, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	e, ok := rs.v6[key]
+	return e, ok
+}
+
+// Handler4 handles DHCPv4 packets for the slease plugin.
+func (rs *Records) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	log.Debugf("Got packet: %v", req.Summary())
-	if e, ok := ipv4Records[req.ClientHWAddr.String()]; ok {
-		resp4(e, resp)
-	} else if req.Options.Has(dhcpv4.OptionClientIdentifier) {
-		cid := req.GetOneOption(dhcpv4.OptionClientIdentifier)
-		if e, ok := ipv4Records[toString(cid)]; ok {
-			resp4(e, resp)
+	// Relayed devices are identified by the relay port they are cabled to first.
+	info := relay.FromV4(req)
+	keys := append(info.Keys(), req.ClientHWAddr.String())
+	if req.Options.Has(dhcpv4.OptionClientIdentifier) {
+		keys = append(keys, toString(req.GetOneOption(dhcpv4.OptionClientIdentifier)))
+	}
+	// Devices telling their chassis serial in their vendor class match the
+	// records of the serial.
+	if vc := vendorclass.FromV4(req); vc != nil && vc.Serial != "" {
+		keys = append(keys, vc.Serial)
+	}
+	for _, k := range keys {
+		e, ok := rs.lookup4(k)
+		if !ok {
+			continue
+		}
+		if e.ip != nil && !info.OnLink(&net.IPNet{IP: e.ip.Mask(e.netmask), Mask: e.netmask}) {
+			log.Warningf("Ignoring record %v of %v, which is not on the link of relay %v", e.ip, k, info.Link)
+			continue
 		}
+		resp4(e, req, resp)
+		break
 	}
 	return resp, false
 }
 
-func resp4(e *ipv4Entry, resp *dhcpv4.DHCPv4) {
-	resp.YourIPAddr = e.ip
-	resp.Options.Update(dhcpv4.OptSubnetMask(e.netmask))
-	resp.Options.Update(dhcpv4.OptRouter(e.gateway))
+func resp4(e *ipv4Entry, req, resp *dhcpv4.DHCPv4) {
+	if e.ip != nil {
+		resp.YourIPAddr = e.ip
+		resp.Options.Update(dhcpv4.OptSubnetMask(e.netmask))
+		resp.Options.Update(dhcpv4.OptRouter(e.gateway))
+	}
+	if e.bootz != nil && plbootz.RequestedV4(req) {
+		resp.Options.Update(*e.bootz)
+	}
+	for _, o := range e.options {
+		resp.Options.Update(o)
+	}
 }
 
-func handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+// Handler6 handles DHCPv6 packets for the slease plugin.
+func (rs *Records) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	log.Debugf("Got packet: %v", req.Summary())
 	m, err := req.GetInnerMessage()
 	if err != nil {
@@ -101,30 +396,53 @@ func handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		return resp, false
 	}
 
+	info := relay.FromV6(req)
+	keys := info.Keys()
 	if mac, err := dhcpv6.ExtractMAC(req); err == nil {
-		if ip, ok := ipv6Records[mac.String()]; ok {
-			resp.AddOption(createIpv6LeaseOption(m, ip))
-		}
+		keys = append(keys, mac.String())
 	} else {
 		duid := m.Options.ClientID()
 		if en, ok := duid.(*dhcpv6.DUIDEN); ok {
 			ei := en.EnterpriseIdentifier[:len(en.EnterpriseIdentifier)]
-			if ip, ok := ipv6Records[toString(ei)]; ok {
-				resp.AddOption(createIpv6LeaseOption(m, ip))
+			keys = append(keys, toString(ei))
+		}
+	}
+	if vc := vendorclass.FromV6(m); vc != nil && vc.Serial != "" {
+		keys = append(keys, vc.Serial)
+	}
+	var e *ipv6Entry
+	for _, k := range keys {
+		if r, ok := rs.lookup6(k); ok {
+			if r.ip != nil && !info.OnLink(r.subnet) {
+				log.Warningf("Ignoring record %v of %v, which is not on the link of relay %v", r.ip, k, info.Link)
+				continue
 			}
+			e = r
+			break
+		}
+	}
+	if e != nil {
+		if e.ip != nil {
+			resp.AddOption(createIpv6LeaseOption(m, e.ip, e.lifetime))
+		}
+		if e.bootz != nil && plbootz.RequestedV6(m) {
+			resp.UpdateOption(e.bootz)
+		}
+		for _, o := range e.options {
+			resp.UpdateOption(o)
 		}
 	}
 	return resp, false
 }
 
-func createIpv6LeaseOption(m *dhcpv6.Message, ip net.IP) *dhcpv6.OptIANA {
+func createIpv6LeaseOption(m *dhcpv6.Message, ip net.IP, lifetime time.Duration) *dhcpv6.OptIANA {
 	return &dhcpv6.OptIANA{
 		IaId: m.Options.OneIANA().IaId,
 		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
 			&dhcpv6.OptIAAddress{
 				IPv6Addr:          ip,
-				PreferredLifetime: 3600 * time.Second,
-				ValidLifetime:     3600 * time.Second,
+				PreferredLifetime: lifetime,
+				ValidLifetime:     lifetime,
 			},
 		}},
 	}
@@ -137,9 +455,9 @@ func toString(b []byte) string {
 }
 
 func parseRecord4(r string) (string, *ipv4Entry, error) {
-	//format: mac|serial,ipv4/mask,gw
+	//format: mac|serial|circuit-id:id|remote-id:id,ipv4/mask,gw[,bootz_uri...]
 	parts := strings.Split(r, ",")
-	if len(parts) != 3 {
+	if len(parts) < 3 {
 		return "", nil, fmt.Errorf("invalid entry %v", r)
 	}
 	ip, ipNet, err := net.ParseCIDR(parts[1])
@@ -151,22 +469,36 @@ func parseRecord4(r string) (string, *ipv4Entry, error) {
 		return "", nil, fmt.Errorf("invalid gw address %v", parts[2])
 	}
 
-	return parts[0], &ipv4Entry{
+	e := &ipv4Entry{
 		ip:      ip,
 		netmask: ipNet.Mask,
 		gateway: gw,
-	}, nil
+	}
+	if uris := parts[3:]; len(uris) > 0 {
+		opt, err := plbootz.OptV4SZTPRedirect(uris...)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid bootz uris in entry %v: %v", r, err)
+		}
+		e.bootz = &opt
+	}
+	return parts[0], e, nil
 }
 
-func parseRecord6(r string) (string, net.IP, error) {
-	//format: mac|serial,ipv6
+func parseRecord6(r string) (string, *ipv6Entry, error) {
+	//format: mac|serial|circuit-id:id|remote-id:id,ipv6[,bootz_uri...]
 	parts := strings.Split(r, ",")
-	if len(parts) != 2 {
+	if len(parts) < 2 {
 		return "", nil, fmt.Errorf("invalid entry %v", r)
 	}
-	ip, _, err := net.ParseCIDR(parts[1])
+	ip, subnet, err := net.ParseCIDR(parts[1])
 	if err != nil {
 		return "", nil, fmt.Errorf("invalid ip address %v", parts[1])
 	}
-	return parts[0], ip, nil
+	e := &ipv6Entry{ip: ip, subnet: subnet, lifetime: defaultLifetime}
+	if uris := parts[2:]; len(uris) > 0 {
+		if e.bootz, err = plbootz.OptV6SZTPRedirect(uris...); err != nil {
+			return "", nil, fmt.Errorf("invalid bootz uris in entry %v: %v", r, err)
+		}
+	}
+	return parts[0], e, nil
 }
@@ -0,0 +1,407 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slease
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	plbootz "github.com/openconfig/bootz/dhcp/plugins/bootz"
+)
+
+// offer4 returns the address offered to the mac address by the handler.
+func offer4(t *testing.T, h handler.Handler4, mac net.HardwareAddr) string {
+	t.Helper()
+	req, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = h(req, resp)
+	return resp.YourIPAddr.String()
+}
+
+func TestSetup4(t *testing.T) {
+	h, err := setup4("00:00:00:00:00:0a,192.0.2.10/24,192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := offer4(t, h, net.HardwareAddr{0, 0, 0, 0, 0, 0xa}); got != "192.0.2.10" {
+		t.Errorf("offer from the plugin arguments = %v, want 192.0.2.10", got)
+	}
+	if _, err := setup4("00:00:00:00:00:0a,192.0.2.10/24"); err == nil {
+		t.Errorf("setup4() without a gateway err = nil, want an error")
+	}
+}
+
+func TestRecords(t *testing.T) {
+	t.Parallel()
+	rs := NewRecords()
+	macA := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	macB := net.HardwareAddr{0, 0, 0, 0, 0, 0xb}
+
+	if err := rs.Set(macA.String(), "192.0.2.10/24", "192.0.2.1"); err != nil {
+		t.Fatalf("Set() err = %v", err)
+	}
+	if got := offer4(t, rs.Handler4, macA); got != "192.0.2.10" {
+		t.Errorf("offer after Set() = %v, want 192.0.2.10", got)
+	}
+	if err := rs.Set(macB.String(), "192.0.2.11/24", "192.0.2.1"); err != nil {
+		t.Fatalf("Set() err = %v", err)
+	}
+	if err := rs.Set(macB.String(), "192.0.2.12/24", "192.0.2.1"); err != nil {
+		t.Fatalf("Set() err = %v", err)
+	}
+	if got := offer4(t, rs.Handler4, macB); got != "192.0.2.12" {
+		t.Errorf("offer after updating the record = %v, want 192.0.2.12", got)
+	}
+	// Moving a record to IPv6 removes its IPv4 record.
+	if err := rs.Set(macB.String(), "2001:db8::12/64", ""); err != nil {
+		t.Fatalf("Set() err = %v", err)
+	}
+	if got := offer4(t, rs.Handler4, macB); got != "0.0.0.0" {
+		t.Errorf("offer after moving the record to IPv6 = %v, want none", got)
+	}
+	if _, ok := rs.lookup6(macB.String()); !ok {
+		t.Errorf("lookup6() found no record after Set()")
+	}
+	rs.Remove(macA.String())
+	if got := offer4(t, rs.Handler4, macA); got != "0.0.0.0" {
+		t.Errorf("offer after Remove() = %v, want none", got)
+	}
+	if err := rs.Set(macA.String(), "192.0.2.300/24", "192.0.2.1"); err == nil {
+		t.Errorf("Set() with an invalid address err = nil, want an error")
+	}
+	// Records of other servers are independent.
+	if got := offer4(t, NewRecords().Handler4, macB); got != "0.0.0.0" {
+		t.Errorf("offer from other records = %v, want none", got)
+	}
+}
+
+func TestRecordsConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+	rs := NewRecords()
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := rs.Set(mac.String(), "192.0.2.10/24", "192.0.2.1"); err != nil {
+				t.Error(err)
+			}
+			rs.Remove(mac.String())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if got := offer4(t, rs.Handler4, mac); got != "192.0.2.10" && got != "0.0.0.0" {
+				t.Errorf("offer = %v, want 192.0.2.10 or none", got)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRecordBootzURIs(t *testing.T) {
+	t.Parallel()
+	rs := NewRecords()
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	if err := rs.Set(mac.String(), "192.0.2.10/24", "192.0.2.1", "bootz://192.0.2.2:15006"); err != nil {
+		t.Fatalf("Set() err = %v", err)
+	}
+	global, err := plbootz.OptV4SZTPRedirect("bootz://192.0.2.1:15006")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, requested := range []bool{true, false} {
+		var opts []dhcpv4.Modifier
+		if requested {
+			opts = append(opts, dhcpv4.WithRequestedOptions(dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT)))
+		}
+		req, err := dhcpv4.NewDiscovery(mac, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The bootz plugin comes first and adds the global option.
+		if requested {
+			resp.UpdateOption(global)
+		}
+		resp, _ = rs.Handler4(req, resp)
+		got, err := plbootz.BootstrapServersV4(resp)
+		switch {
+		case requested && (err != nil || len(got) != 1 || got[0] != "bootz://192.0.2.2:15006"):
+			t.Errorf("BootstrapServersV4() = %v, %v, want the bootz server of the record", got, err)
+		case !requested && err == nil:
+			t.Errorf("BootstrapServersV4() = %v, want no sZTP redirect option when not requested", got)
+		}
+	}
+}
+
+func TestRelayedRequests(t *testing.T) {
+	t.Parallel()
+	h, err := setup4(
+		"00:00:00:00:00:0a,192.0.2.10/24,192.0.2.1",
+		"circuit-id:Ethernet1/1,198.51.100.10/24,198.51.100.1",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	tests := []struct {
+		desc      string
+		giaddr    string
+		circuitID string
+		want      string
+	}{{
+		desc:      "Circuit id wins over the mac address",
+		giaddr:    "198.51.100.1",
+		circuitID: "Ethernet1/1",
+		want:      "198.51.100.10",
+	}, {
+		desc:   "Mac address on the relay link",
+		giaddr: "192.0.2.1",
+		want:   "192.0.2.10",
+	}, {
+		desc:   "Record off the relay link",
+		giaddr: "203.0.113.1",
+		want:   "0.0.0.0",
+	}, {
+		desc:      "Circuit id record off the relay link",
+		giaddr:    "192.0.2.1",
+		circuitID: "Ethernet1/1",
+		want:      "192.0.2.10",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			mods := []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.ParseIP(test.giaddr))}
+			if test.circuitID != "" {
+				mods = append(mods, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+					dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(test.circuitID)))))
+			}
+			req, err := dhcpv4.NewDiscovery(mac, mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, _ = h(req, resp)
+			if got := resp.YourIPAddr.String(); got != test.want {
+				t.Errorf("offer = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVendorClassSerial(t *testing.T) {
+	t.Parallel()
+	h, err := setup4(
+		"00:00:00:00:00:0a,192.0.2.10/24,192.0.2.1",
+		"FOX2506P2QT,192.0.2.20/24,192.0.2.1",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc  string
+		mac   net.HardwareAddr
+		class string
+		want  string
+	}{{
+		desc:  "Serial in the vendor class",
+		mac:   net.HardwareAddr{0, 0, 0, 0, 0, 0xb},
+		class: "Cisco:8201:FOX2506P2QT",
+		want:  "192.0.2.20",
+	}, {
+		desc:  "Mac address wins over the serial",
+		mac:   net.HardwareAddr{0, 0, 0, 0, 0, 0xa},
+		class: "PID:8201,SN:FOX2506P2QT",
+		want:  "192.0.2.10",
+	}, {
+		desc:  "Unknown serial",
+		mac:   net.HardwareAddr{0, 0, 0, 0, 0, 0xb},
+		class: "PID:8201,SN:FOX0000AAAA",
+		want:  "0.0.0.0",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(test.mac, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(test.class)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, _ = h(req, resp)
+			if got := resp.YourIPAddr.String(); got != test.want {
+				t.Errorf("offer = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecordOptions(t *testing.T) {
+	t.Parallel()
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	opts := &Options{
+		DomainName: "example.com",
+		Hostname:   "r1",
+		MTU:        9000,
+		LeaseTime:  24 * time.Hour,
+	}
+
+	rs := NewRecords()
+	v4 := *opts
+	v4.NTP = []string{"192.0.2.123"}
+	v4.Raw = map[uint16][]byte{224: []byte("private")}
+	if err := rs.SetWithOptions(mac.String(), "192.0.2.10/24", "192.0.2.1", &v4); err != nil {
+		t.Fatalf("SetWithOptions() err = %v", err)
+	}
+	req, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithLeaseTime(3600))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = rs.Handler4(req, resp)
+	if got := resp.NTPServers(); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.123")) {
+		t.Errorf("NTPServers() = %v, want 192.0.2.123", got)
+	}
+	if got := resp.DomainName(); got != "example.com" {
+		t.Errorf("DomainName() = %q, want example.com", got)
+	}
+	if got := resp.HostName(); got != "r1" {
+		t.Errorf("HostName() = %q, want r1", got)
+	}
+	if got := resp.Options.Get(dhcpv4.OptionInterfaceMTU); !bytes.Equal(got, []byte{0x23, 0x28}) {
+		t.Errorf("mtu option = %x, want 2328", got)
+	}
+	if got := resp.IPAddressLeaseTime(0); got != 24*time.Hour {
+		t.Errorf("IPAddressLeaseTime() = %v, want 24h", got)
+	}
+	if got := resp.Options.Get(dhcpv4.GenericOptionCode(224)); string(got) != "private" {
+		t.Errorf("raw option = %q, want private", got)
+	}
+
+	v6 := *opts
+	v6.NTP = []string{"2001:db8::123", "ntp.example.com"}
+	v6.Raw = map[uint16][]byte{65000: []byte("private")}
+	if err := rs.SetWithOptions(mac.String(), "2001:db8::10/64", "", &v6); err != nil {
+		t.Fatalf("SetWithOptions() err = %v", err)
+	}
+	sol, err := dhcpv6.NewSolicit(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adv, err := dhcpv6.NewAdvertiseFromSolicit(sol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := rs.Handler6(sol, adv)
+	m, err := dhcpv6.MessageFromBytes(reply.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Options.NTPServers(); len(got) != 1 || !got[0].Equal(net.ParseIP("2001:db8::123")) {
+		t.Errorf("NTPServers() = %v, want 2001:db8::123", got)
+	}
+	if got := m.Options.DomainSearchList(); got == nil || len(got.Labels) != 1 || got.Labels[0] != "example.com" {
+		t.Errorf("DomainSearchList() = %v, want example.com", got)
+	}
+	if got := m.Options.FQDN(); got == nil || len(got.DomainName.Labels) != 1 || got.DomainName.Labels[0] != "r1.example.com" {
+		t.Errorf("FQDN() = %v, want r1.example.com", got)
+	}
+	if got := m.Options.OneIANA().Options.OneAddress(); got == nil || got.ValidLifetime != 24*time.Hour || got.PreferredLifetime != 24*time.Hour {
+		t.Errorf("address = %v, want a lifetime of 24h", got)
+	}
+	if got := m.GetOneOption(dhcpv6.OptionCode(65000)); got == nil || string(got.ToBytes()) != "private" {
+		t.Errorf("raw option = %v, want private", got)
+	}
+
+	for _, bad := range []*Options{
+		{NTP: []string{"2001:db8::123"}},
+		{NTP: []string{"ntp.example.com"}},
+		{MTU: 60},
+		{LeaseTime: -time.Second},
+		{Raw: map[uint16][]byte{255: nil}},
+	} {
+		if err := rs.SetWithOptions(mac.String(), "192.0.2.10/24", "192.0.2.1", bad); err == nil {
+			t.Errorf("SetWithOptions(%+v) err = nil, want an error", bad)
+		}
+	}
+	if err := rs.SetWithOptions(mac.String(), "2001:db8::10/64", "", &Options{NTP: []string{"192.0.2.123"}}); err == nil {
+		t.Errorf("SetWithOptions() with an IPv4 ntp server for an IPv6 record err = nil, want an error")
+	}
+}
+
+func TestOptionsOnlyRecord(t *testing.T) {
+	t.Parallel()
+	mac := net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+	rs := NewRecords()
+	opts := &Options{NTP: []string{"192.0.2.123"}, Hostname: "r1"}
+	if err := rs.SetWithOptions(mac.String(), "", "", opts, "bootz://192.0.2.2:15006"); err != nil {
+		t.Fatalf("SetWithOptions() err = %v", err)
+	}
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithRequestedOptions(dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = rs.Handler4(req, resp)
+	// The pools assign the address.
+	if !resp.YourIPAddr.IsUnspecified() {
+		t.Errorf("offer = %v, want none", resp.YourIPAddr)
+	}
+	if got := resp.NTPServers(); len(got) != 1 || !got[0].Equal(net.ParseIP("192.0.2.123")) {
+		t.Errorf("NTPServers() = %v, want 192.0.2.123", got)
+	}
+	if got := resp.HostName(); got != "r1" {
+		t.Errorf("HostName() = %q, want r1", got)
+	}
+	if !resp.Options.Has(dhcpv4.GenericOptionCode(plbootz.OPTION_V4_SZTP_REDIRECT)) {
+		t.Errorf("offer has no sZTP redirect option")
+	}
+	// The IPv4 ntp server is not valid for IPv6 clients, so they get no record.
+	if _, ok := rs.lookup6(mac.String()); ok {
+		t.Errorf("IPv6 record set for options only valid for IPv4")
+	}
+
+	if err := rs.SetWithOptions(mac.String(), "", "", &Options{NTP: []string{"192.0.2.123", "2001:db8::123"}}); err == nil {
+		t.Errorf("SetWithOptions() with options valid for neither family err = nil, want an error")
+	}
+}
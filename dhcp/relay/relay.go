@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay extracts what dhcp relay agents add to the requests they forward,
+// so that devices in remote racks can be told apart by the relay port they are
+// cabled to.
+package relay
+
+import (
+	"encoding/hex"
+	"net"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Prefixes of the record keys matching relay identifiers.
+const (
+	CircuitIDPrefix = "circuit-id:"
+	RemoteIDPrefix  = "remote-id:"
+)
+
+// CircuitIDKey returns the record key matching the relay circuit id.
+func CircuitIDKey(id string) string {
+	return CircuitIDPrefix + id
+}
+
+// RemoteIDKey returns the record key matching the relay remote id.
+func RemoteIDKey(id string) string {
+	return RemoteIDPrefix + id
+}
+
+// Info is what the relay agent closest to a client added to its request.
+type Info struct {
+	// Link is the address of the relay agent on the client's link, the giaddr of
+	// DHCPv4 requests and the link-address of DHCPv6 ones. It is nil if the relay
+	// agent did not set it.
+	Link net.IP
+	// CircuitID is the agent circuit id of DHCPv4 requests (RFC 3046) and the
+	// interface id of DHCPv6 ones (RFC 8415).
+	CircuitID []byte
+	// RemoteID is the agent remote id of DHCPv4 requests (RFC 3046) and the
+	// remote id of DHCPv6 ones without its enterprise number (RFC 4649).
+	RemoteID []byte
+}
+
+// FromV4 returns the relay information of a DHCPv4 request, or nil if it was not relayed.
+func FromV4(req *dhcpv4.DHCPv4) *Info {
+	if req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified() {
+		return nil
+	}
+	info := &Info{Link: req.GatewayIPAddr}
+	if rai := req.RelayAgentInfo(); rai != nil {
+		info.CircuitID = rai.Get(dhcpv4.AgentCircuitIDSubOption)
+		info.RemoteID = rai.Get(dhcpv4.AgentRemoteIDSubOption)
+	}
+	return info
+}
+
+// FromV6 returns the relay information added by the innermost relay agent of a
+// DHCPv6 request, or nil if it was not relayed.
+func FromV6(req dhcpv6.DHCPv6) *Info {
+	r, ok := req.(*dhcpv6.RelayMessage)
+	if !ok {
+		return nil
+	}
+	for {
+		inner, err := dhcpv6.DecapsulateRelay(r)
+		if err != nil {
+			break
+		}
+		next, ok := inner.(*dhcpv6.RelayMessage)
+		if !ok {
+			break
+		}
+		r = next
+	}
+	info := &Info{CircuitID: r.Options.InterfaceID()}
+	if r.LinkAddr != nil && !r.LinkAddr.IsUnspecified() {
+		info.Link = r.LinkAddr
+	}
+	if rid := r.Options.RemoteID(); rid != nil {
+		info.RemoteID = rid.RemoteID
+	}
+	return info
+}
+
+// Keys returns the record keys matching the relay identifiers, the circuit id first.
+func (i *Info) Keys() []string {
+	if i == nil {
+		return nil
+	}
+	var keys []string
+	if len(i.CircuitID) > 0 {
+		keys = append(keys, CircuitIDKey(ID(i.CircuitID)))
+	}
+	if len(i.RemoteID) > 0 {
+		keys = append(keys, RemoteIDKey(ID(i.RemoteID)))
+	}
+	return keys
+}
+
+// OnLink reports whether the subnet is the link of the relay agent. Subnets are
+// on the link of requests which were not relayed, or whose relay did not tell.
+func (i *Info) OnLink(subnet *net.IPNet) bool {
+	return i == nil || i.Link == nil || subnet.Contains(i.Link)
+}
+
+// ID formats a relay identifier as text if it is printable, or else as hex.
+func ID(b []byte) string {
+	if !utf8.Valid(b) {
+		return hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsGraphic(r) {
+			return hex.EncodeToString(b)
+		}
+	}
+	return string(b)
+}
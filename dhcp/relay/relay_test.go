@@ -0,0 +1,146 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var mac = net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+func TestFromV4(t *testing.T) {
+	tests := []struct {
+		desc     string
+		mods     []dhcpv4.Modifier
+		wantNil  bool
+		wantLink string
+		wantKeys []string
+	}{{
+		desc:    "Not relayed",
+		wantNil: true,
+	}, {
+		desc:     "Relayed without option 82",
+		mods:     []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.ParseIP("192.0.2.1"))},
+		wantLink: "192.0.2.1",
+	}, {
+		desc: "Relayed with option 82",
+		mods: []dhcpv4.Modifier{
+			dhcpv4.WithGatewayIP(net.ParseIP("192.0.2.1")),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("Ethernet1/1")),
+				dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte{0, 1, 2, 0xff}),
+			)),
+		},
+		wantLink: "192.0.2.1",
+		wantKeys: []string{"circuit-id:Ethernet1/1", "remote-id:000102ff"},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(mac, test.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := FromV4(req)
+			if test.wantNil {
+				if info != nil {
+					t.Errorf("FromV4() = %+v, want nil", info)
+				}
+				return
+			}
+			if info == nil {
+				t.Fatalf("FromV4() = nil, want relay information")
+			}
+			if got := info.Link.String(); got != test.wantLink {
+				t.Errorf("FromV4() link = %v, want %v", got, test.wantLink)
+			}
+			if diff := cmp.Diff(test.wantKeys, info.Keys()); diff != "" {
+				t.Errorf("Keys() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromV6(t *testing.T) {
+	solicit, err := dhcpv6.NewSolicit(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := FromV6(solicit); info != nil {
+		t.Errorf("FromV6() of a direct request = %+v, want nil", info)
+	}
+
+	// The innermost relay is the one on the client's link.
+	inner, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.AddOption(dhcpv6.OptInterfaceID([]byte("Ethernet1/1")))
+	inner.AddOption(&dhcpv6.OptRemoteID{EnterpriseNumber: 9, RemoteID: []byte("rack1-tor")})
+	outer, err := dhcpv6.EncapsulateRelay(inner, dhcpv6.MessageTypeRelayForward, net.IPv6unspecified, net.ParseIP("2001:db8:1::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer.AddOption(dhcpv6.OptInterfaceID([]byte("uplink")))
+
+	info := FromV6(outer)
+	if info == nil {
+		t.Fatalf("FromV6() = nil, want relay information")
+	}
+	if got := info.Link.String(); got != "2001:db8:1::1" {
+		t.Errorf("FromV6() link = %v, want 2001:db8:1::1", got)
+	}
+	want := []string{"circuit-id:Ethernet1/1", "remote-id:rack1-tor"}
+	if diff := cmp.Diff(want, info.Keys()); diff != "" {
+		t.Errorf("Keys() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestOnLink(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		desc string
+		info *Info
+		want bool
+	}{{
+		desc: "Not relayed",
+		want: true,
+	}, {
+		desc: "Relay without link address",
+		info: &Info{CircuitID: []byte("Ethernet1/1")},
+		want: true,
+	}, {
+		desc: "Relay on the subnet",
+		info: &Info{Link: net.ParseIP("192.0.2.1")},
+		want: true,
+	}, {
+		desc: "Relay on another subnet",
+		info: &Info{Link: net.ParseIP("198.51.100.1")},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.info.OnLink(subnet); got != test.want {
+				t.Errorf("OnLink(%v) = %v, want %v", subnet, got, test.want)
+			}
+		})
+	}
+}
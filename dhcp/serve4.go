@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+
+	cdhandler "github.com/coredhcp/coredhcp/handler"
+	log "github.com/golang/glog"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+)
+
+// listener4 serves DHCPv4 on a connection. The coredhcp server is not used for
+// DHCPv4, as it drops every request but discovers and requests before running
+// the handlers, and the pools and leases must see the releases too.
+type listener4 struct {
+	srv  *server4.Server
+	done chan struct{}
+}
+
+// serve4 starts serving DHCPv4 on the connection with the handlers, in order.
+func serve4(conn net.PacketConn, hs []cdhandler.Handler4) (*listener4, error) {
+	srv, err := server4.NewServer("", nil, handle4(hs), server4.WithConn(conn))
+	if err != nil {
+		return nil, err
+	}
+	l := &listener4{srv: srv, done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		srv.Serve()
+	}()
+	return l, nil
+}
+
+// close stops serving and waits for the listener to return.
+func (l *listener4) close() {
+	l.srv.Close()
+	<-l.done
+}
+
+// handle4 returns a server4 handler running the handlers on each request, and
+// sending the response they return, if any. Releases get no response.
+func handle4(hs []cdhandler.Handler4) server4.Handler {
+	return func(conn net.PacketConn, _ net.Addr, req *dhcpv4.DHCPv4) {
+		if req.OpCode != dhcpv4.OpcodeBootRequest {
+			return
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			log.Errorf("Unable to build the reply to %v: %v", req.ClientHWAddr, err)
+			return
+		}
+		switch mt := req.MessageType(); mt {
+		case dhcpv4.MessageTypeDiscover:
+			resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+		case dhcpv4.MessageTypeRequest:
+			resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+		case dhcpv4.MessageTypeRelease:
+		default:
+			log.V(1).Infof("Ignoring DHCPv4 %v from %v", mt, req.ClientHWAddr)
+			return
+		}
+		for _, h := range hs {
+			var stop bool
+			if resp, stop = h(req, resp); stop {
+				break
+			}
+		}
+		if resp == nil || req.MessageType() == dhcpv4.MessageTypeRelease {
+			return
+		}
+		if _, err := conn.WriteTo(resp.ToBytes(), replyPeer4(req, resp)); err != nil {
+			log.Errorf("Unable to send the DHCPv4 %v to %v: %v", resp.MessageType(), req.ClientHWAddr, err)
+		}
+	}
+}
+
+// replyPeer4 returns where the response to the request is sent, as RFC 2131
+// section 4.1 says. Clients which can only be reached by their hardware address
+// get a broadcast.
+func replyPeer4(req, resp *dhcpv4.DHCPv4) *net.UDPAddr {
+	switch {
+	case !req.GatewayIPAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	case resp.MessageType() == dhcpv4.MessageTypeNak:
+	case !req.ClientIPAddr.IsUnspecified():
+		return &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
+	}
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+}
@@ -0,0 +1,205 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vendorclass extracts the identity devices put in the vendor class
+// options of their dhcp requests, so that they can be matched with the
+// inventory before they have an address.
+//
+// The text of the vendor classes is read as key:value or key=value pairs
+// separated by commas, semicolons or spaces, e.g. "PID:DCS-7280,SN:JPE1234",
+// where the manufacturer keys are manufacturer, mfg and vendor, the model keys
+// model, pid and pn, and the serial keys serial and sn. Text without any of
+// these keys is read as manufacturer:model[:serial], e.g. "Arista:DCS-7280:JPE1234".
+package vendorclass
+
+import (
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Sub-options of the DHCPv6 vendor-specific information option carrying the
+// identity of the device, as CableLabs defines them.
+const (
+	subOptionSerial       = 4
+	subOptionModel        = 9
+	subOptionManufacturer = 10
+)
+
+// manufacturers are the manufacturers of the IANA enterprise numbers of the
+// vendor classes, for the clients which do not name theirs.
+var manufacturers = map[uint32]string{
+	9:     "Cisco",
+	2011:  "Huawei",
+	2636:  "Juniper",
+	6527:  "Nokia",
+	30065: "Arista",
+}
+
+// Info is what a client told about itself in its vendor class options.
+type Info struct {
+	// Class is the vendor class identifier of DHCPv4 option 60, or else the
+	// first vendor class data of DHCPv4 option 124 or DHCPv6 option 16.
+	Class string
+	// Enterprise is the IANA enterprise number of the vendor class, if any.
+	Enterprise   uint32
+	Manufacturer string
+	Model        string
+	Serial       string
+}
+
+// FromV4 returns the vendor class of a DHCPv4 request, read from options 60
+// and 124, or nil if it has none.
+func FromV4(req *dhcpv4.DHCPv4) *Info {
+	info := &Info{}
+	if c := req.ClassIdentifier(); c != "" {
+		info.Class = c
+		info.parse(c)
+	}
+	for _, id := range req.VIVC() {
+		if info.Enterprise == 0 {
+			info.Enterprise = uint32(id.EntID)
+		}
+		for _, d := range splitData(id.Data) {
+			if info.Class == "" {
+				info.Class = string(d)
+			}
+			info.parse(string(d))
+		}
+	}
+	return info.done()
+}
+
+// FromV6 returns the vendor class of a DHCPv6 request, read from options 16
+// and 17, or nil if it has none.
+func FromV6(m *dhcpv6.Message) *Info {
+	info := &Info{}
+	for _, vc := range m.Options.VendorClasses() {
+		if info.Enterprise == 0 {
+			info.Enterprise = vc.EnterpriseNumber
+		}
+		for _, d := range vc.Data {
+			if info.Class == "" {
+				info.Class = string(d)
+			}
+			info.parse(string(d))
+		}
+	}
+	for _, vo := range m.Options.VendorOpts() {
+		if info.Enterprise == 0 {
+			info.Enterprise = vo.EnterpriseNumber
+		}
+		for _, o := range vo.VendorOpts {
+			v := string(o.ToBytes())
+			switch o.Code() {
+			case subOptionSerial:
+				info.set(&info.Serial, v)
+			case subOptionModel:
+				info.set(&info.Model, v)
+			case subOptionManufacturer:
+				info.set(&info.Manufacturer, v)
+			}
+		}
+	}
+	return info.done()
+}
+
+// done fills the manufacturer in from the enterprise number, and returns nil
+// if the client told nothing.
+func (i *Info) done() *Info {
+	if i.Manufacturer == "" {
+		i.Manufacturer = manufacturers[i.Enterprise]
+	}
+	if *i == (Info{}) {
+		return nil
+	}
+	return i
+}
+
+// set sets the field unless an earlier vendor class already did.
+func (i *Info) set(field *string, v string) {
+	if *field == "" {
+		*field = strings.TrimSpace(v)
+	}
+}
+
+// parse reads the identity in the text of a vendor class.
+func (i *Info) parse(s string) {
+	found := false
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	}) {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			k, v, ok = strings.Cut(f, ":")
+		}
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(k) {
+		case "manufacturer", "mfg", "vendor":
+			i.set(&i.Manufacturer, v)
+		case "model", "pid", "pn":
+			i.set(&i.Model, v)
+		case "serial", "sn":
+			i.set(&i.Serial, v)
+		default:
+			continue
+		}
+		found = true
+	}
+	if found {
+		return
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return
+	}
+	for n, field := range []*string{&i.Manufacturer, &i.Model, &i.Serial}[:len(parts)] {
+		i.set(field, parts[n])
+	}
+}
+
+// splitData splits the vendor class data of DHCPv4 option 124 into its items,
+// each preceded by its length. Data which is not a list of items is one item.
+func splitData(data []byte) [][]byte {
+	var items [][]byte
+	for rest := data; len(rest) > 0; {
+		n := int(rest[0])
+		if n == 0 || len(rest) < 1+n {
+			return [][]byte{data}
+		}
+		items = append(items, rest[1:1+n])
+		rest = rest[1+n:]
+	}
+	return items
+}
+
+// Matches reports whether the client is of one of the vendor classes: its
+// manufacturer, or the start of its vendor class, compared without case.
+func (i *Info) Matches(classes []string) bool {
+	if i == nil {
+		return false
+	}
+	for _, c := range classes {
+		if c == "" {
+			continue
+		}
+		if strings.EqualFold(c, i.Manufacturer) || (len(i.Class) >= len(c) && strings.EqualFold(c, i.Class[:len(c)])) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vendorclass
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var mac = net.HardwareAddr{0, 0, 0, 0, 0, 0xa}
+
+func TestFromV4(t *testing.T) {
+	tests := []struct {
+		desc string
+		mods []dhcpv4.Modifier
+		want *Info
+	}{{
+		desc: "No vendor class",
+	}, {
+		desc: "Positional class identifier",
+		mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("Arista:DCS-7280:JPE1234"))},
+		want: &Info{Class: "Arista:DCS-7280:JPE1234", Manufacturer: "Arista", Model: "DCS-7280", Serial: "JPE1234"},
+	}, {
+		desc: "Keyed class identifier",
+		mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PID:8201-32FH,SN:FOX2506P2QT"))},
+		want: &Info{Class: "PID:8201-32FH,SN:FOX2506P2QT", Model: "8201-32FH", Serial: "FOX2506P2QT"},
+	}, {
+		desc: "Class identifier without identity",
+		mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001"))},
+		want: &Info{Class: "PXEClient:Arch:00000:UNDI:002001"},
+	}, {
+		desc: "Vendor-identifying vendor class",
+		mods: []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{
+			EntID: 9,
+			Data:  []byte("\x0dPID:8201-32FH\x0eSN=FOX2506P2QT"),
+		}))},
+		want: &Info{Class: "PID:8201-32FH", Enterprise: 9, Manufacturer: "Cisco", Model: "8201-32FH", Serial: "FOX2506P2QT"},
+	}, {
+		desc: "Class identifier wins over the vendor-identifying vendor class",
+		mods: []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("vendor=Juniper;model=ptx10001")),
+			dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 2636, Data: []byte("sn=AB1234")})),
+		},
+		want: &Info{Class: "vendor=Juniper;model=ptx10001", Enterprise: 2636, Manufacturer: "Juniper", Model: "ptx10001", Serial: "AB1234"},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(mac, test.mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, FromV4(req)); diff != "" {
+				t.Errorf("FromV4() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromV6(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts []dhcpv6.Option
+		want *Info
+	}{{
+		desc: "No vendor class",
+	}, {
+		desc: "Vendor class",
+		opts: []dhcpv6.Option{&dhcpv6.OptVendorClass{
+			EnterpriseNumber: 30065,
+			Data:             [][]byte{[]byte("Arista"), []byte("model:DCS-7280 serial:JPE1234")},
+		}},
+		want: &Info{Class: "Arista", Enterprise: 30065, Manufacturer: "Arista", Model: "DCS-7280", Serial: "JPE1234"},
+	}, {
+		desc: "Vendor-specific information",
+		opts: []dhcpv6.Option{&dhcpv6.OptVendorOpts{
+			EnterpriseNumber: 9,
+			VendorOpts: dhcpv6.Options{
+				&dhcpv6.OptionGeneric{OptionCode: subOptionSerial, OptionData: []byte("FOX2506P2QT")},
+				&dhcpv6.OptionGeneric{OptionCode: subOptionModel, OptionData: []byte("8201-32FH")},
+			},
+		}},
+		want: &Info{Enterprise: 9, Manufacturer: "Cisco", Model: "8201-32FH", Serial: "FOX2506P2QT"},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var mods []dhcpv6.Modifier
+			for _, o := range test.opts {
+				mods = append(mods, dhcpv6.WithOption(o))
+			}
+			req, err := dhcpv6.NewSolicit(mac, mods...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, FromV6(req)); diff != "" {
+				t.Errorf("FromV6() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		desc    string
+		info    *Info
+		classes []string
+		want    bool
+	}{{
+		desc:    "Manufacturer",
+		info:    &Info{Class: "8201-32FH", Manufacturer: "Cisco"},
+		classes: []string{"arista", "cisco"},
+		want:    true,
+	}, {
+		desc:    "Class prefix",
+		info:    &Info{Class: "Arista:DCS-7280"},
+		classes: []string{"arista:"},
+		want:    true,
+	}, {
+		desc:    "Other vendor",
+		info:    &Info{Class: "Juniper:ptx10001", Manufacturer: "Juniper"},
+		classes: []string{"Cisco", ""},
+	}, {
+		desc:    "No vendor class",
+		classes: []string{"Cisco"},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.info.Matches(test.classes); got != test.want {
+				t.Errorf("Matches(%v) = %v, want %v", test.classes, got, test.want)
+			}
+		})
+	}
+}
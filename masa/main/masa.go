@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main runs a local voucher issuing service.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+	ownershipvoucher "github.com/openconfig/bootz/common/ownership_voucher"
+	"github.com/openconfig/bootz/masa"
+)
+
+var (
+	port              = flag.String("port", "15007", "The port to serve the voucher issuing service on localhost.")
+	artifactDirectory = flag.String("artifact_dir", "../../testdata/", "The directory containing vendorca_{pub|priv}.pem and pdc_pub.pem.")
+	ovExpiry          = flag.Duration("ov_expiry", 0, "How long issued vouchers are valid for. Nonceful vouchers need no expiry, so by default expires-on is omitted.")
+)
+
+func readPEM(name string) (*pem.Block, []byte, error) {
+	data, err := os.ReadFile(filepath.Join(*artifactDirectory, name))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read %v: %v", name, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("unable to decode %v", name)
+	}
+	return block, data, nil
+}
+
+func main() {
+	flag.Parse()
+
+	certBlock, _, err := readPEM("vendorca_pub.pem")
+	if err != nil {
+		log.Exit(err)
+	}
+	vendorCACert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		log.Exitf("unable to parse vendor CA cert: %v", err)
+	}
+	keyBlock, _, err := readPEM("vendorca_priv.pem")
+	if err != nil {
+		log.Exit(err)
+	}
+	vendorCAKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		log.Exitf("unable to parse vendor CA key: %v", err)
+	}
+	_, pdcPem, err := readPEM("pdc_pub.pem")
+	if err != nil {
+		log.Exit(err)
+	}
+
+	s := masa.NewServer(vendorCACert, vendorCAKey, pdcPem, ownershipvoucher.WithExpiry(*ovExpiry))
+	addr := fmt.Sprintf("localhost:%v", *port)
+	log.Infof("Voucher issuing service listening on http://%v%v", addr, masa.RequestVoucherPath)
+	if err := http.ListenAndServe(addr, s); err != nil {
+		log.Exit(err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package masa implements a local stand-in for a manufacturer's voucher issuing
+// service (a MASA in RFC 8995 terms) and a client for it.
+//
+// The service issues short-lived, nonce-bound ownership vouchers signed by the
+// vendor CA, so the bootz server does not need a pre-provisioned voucher per device.
+package masa
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	ownershipvoucher "github.com/openconfig/bootz/common/ownership_voucher"
+)
+
+const (
+	// RequestVoucherPath is the path the voucher issuing endpoint is served on.
+	RequestVoucherPath = "/.well-known/brski/requestvoucher"
+	// VoucherContentType is the media type of a CMS signed voucher, see RFC 8366 section 8.3.
+	VoucherContentType = "application/voucher-cms+json"
+	// maxRequestSize limits the size of a voucher request body.
+	maxRequestSize = 64 * 1024
+	// maxVoucherSize limits the size of a voucher read by the client.
+	maxVoucherSize = 1024 * 1024
+)
+
+// VoucherRequest is the body of a request for a new voucher.
+type VoucherRequest struct {
+	SerialNumber string `json:"serial-number"`
+	// Nonce is the nonce the device sent in its bootstrap request.
+	Nonce []byte `json:"nonce"`
+}
+
+// Server issues ownership vouchers over HTTP.
+type Server struct {
+	vendorCACert *x509.Certificate
+	vendorCAKey  *rsa.PrivateKey
+	mu           sync.Mutex
+	// pdcs maps a serial number to the PEM-encoded pinned domain cert of its owner.
+	pdcs map[string][]byte
+	// defaultPDC is used for serial numbers without a registered owner.
+	defaultPDC []byte
+	opts       []ownershipvoucher.Option
+}
+
+// NewServer returns a voucher issuing server that signs with the given vendor CA.
+// Vouchers pin defaultPDC unless an owner has been registered for the serial number.
+// The options are passed to ownershipvoucher.New for every voucher.
+func NewServer(vendorCACert *x509.Certificate, vendorCAKey *rsa.PrivateKey, defaultPDC []byte, opts ...ownershipvoucher.Option) *Server {
+	return &Server{
+		vendorCACert: vendorCACert,
+		vendorCAKey:  vendorCAKey,
+		pdcs:         map[string][]byte{},
+		defaultPDC:   defaultPDC,
+		opts:         opts,
+	}
+}
+
+// RegisterOwner pins the given PEM-encoded domain cert in vouchers for serial.
+func (s *Server) RegisterOwner(serial string, pdcPem []byte) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pdcs[serial] = pdcPem
+	return s
+}
+
+func (s *Server) pdcFor(serial string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pdc, ok := s.pdcs[serial]; ok {
+		return pdc
+	}
+	return s.defaultPDC
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != RequestVoucherPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := VoucherRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid voucher request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.SerialNumber == "" {
+		http.Error(w, "voucher request has no serial number", http.StatusBadRequest)
+		return
+	}
+	pdc := s.pdcFor(req.SerialNumber)
+	if len(pdc) == 0 {
+		http.Error(w, fmt.Sprintf("no owner registered for serial %q", req.SerialNumber), http.StatusNotFound)
+		return
+	}
+	opts := append([]ownershipvoucher.Option{}, s.opts...)
+	if len(req.Nonce) > 0 {
+		opts = append(opts, ownershipvoucher.WithNonce(req.Nonce))
+	}
+	ov, err := ownershipvoucher.New(req.SerialNumber, pdc, s.vendorCACert, s.vendorCAKey, opts...)
+	if err != nil {
+		log.Errorf("Unable to issue voucher for serial %v: %v", req.SerialNumber, err)
+		http.Error(w, "unable to issue voucher", http.StatusInternalServerError)
+		return
+	}
+	log.Infof("Issued voucher for serial %v", req.SerialNumber)
+	w.Header().Set("Content-Type", VoucherContentType)
+	w.Write(ov)
+}
+
+// Client requests vouchers from a voucher issuing server.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the voucher issuing server at baseURL, e.g. "http://localhost:8443".
+// If httpClient is nil, a client with a 10 second timeout is used.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		url:        strings.TrimSuffix(baseURL, "/") + RequestVoucherPath,
+		httpClient: httpClient,
+	}
+}
+
+// RequestVoucher returns a new CMS signed voucher for serial bound to nonce.
+func (c *Client) RequestVoucher(serial string, nonce []byte) ([]byte, error) {
+	body, err := json.Marshal(&VoucherRequest{SerialNumber: serial, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", VoucherContentType)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request voucher: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxVoucherSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read voucher: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("voucher request for serial %q failed with status %v: %s", serial, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package masa
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/h-fam/errdiff"
+	ownershipvoucher "github.com/openconfig/bootz/common/ownership_voucher"
+)
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read file %s: %v", path, err)
+	}
+	return data
+}
+
+func newTestServer(t *testing.T) (*Server, *x509.CertPool) {
+	t.Helper()
+	certPem := readFile(t, "../testdata/vendorca_pub.pem")
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		t.Fatal("could not decode vendor CA cert")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(readFile(t, "../testdata/vendorca_priv.pem"))
+	if block == nil {
+		t.Fatal("could not decode vendor CA key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPem) {
+		t.Fatal("unable to add vendor CA to pool")
+	}
+	return NewServer(cert, key, readFile(t, "../testdata/pdc_pub.pem"), ownershipvoucher.WithExpiry(0)), pool
+}
+
+func TestRequestVoucher(t *testing.T) {
+	s, pool := newTestServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := NewClient(ts.URL, nil)
+
+	tests := []struct {
+		desc    string
+		serial  string
+		nonce   []byte
+		wantErr string
+	}{{
+		desc:   "Nonceful voucher",
+		serial: "123A",
+		nonce:  []byte("abcdef"),
+	}, {
+		desc:   "Nonceless voucher",
+		serial: "123B",
+	}, {
+		desc:    "Missing serial",
+		nonce:   []byte("abcdef"),
+		wantErr: "400",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := c.RequestVoucher(test.serial, test.nonce)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("RequestVoucher() %s", s)
+			}
+			if err != nil {
+				return
+			}
+			ov, err := ownershipvoucher.VerifyAndUnmarshal(got, pool, ownershipvoucher.WithNonce(test.nonce))
+			if err != nil {
+				t.Fatalf("VerifyAndUnmarshal() err = %v, want nil", err)
+			}
+			if ov.OV.SerialNumber != test.serial {
+				t.Errorf("got serial = %v, want %v", ov.OV.SerialNumber, test.serial)
+			}
+			if ov.OV.ExpiresOn != "" {
+				t.Errorf("got expires-on = %v, want none", ov.OV.ExpiresOn)
+			}
+		})
+	}
+}
+
+func TestRegisterOwner(t *testing.T) {
+	s, pool := newTestServer(t)
+	s.defaultPDC = nil
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := NewClient(ts.URL, nil)
+
+	if _, err := c.RequestVoucher("123A", nil); err == nil || !strings.Contains(err.Error(), "no owner registered") {
+		t.Fatalf("RequestVoucher() for unregistered serial err = %v, want no owner registered", err)
+	}
+	s.RegisterOwner("123A", readFile(t, "../testdata/pdc_pub.pem"))
+	got, err := c.RequestVoucher("123A", nil)
+	if err != nil {
+		t.Fatalf("RequestVoucher() err = %v, want nil", err)
+	}
+	if _, err := ownershipvoucher.VerifyAndUnmarshal(got, pool); err != nil {
+		t.Errorf("VerifyAndUnmarshal() err = %v, want nil", err)
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		desc       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{{
+		desc:       "Unknown path",
+		method:     http.MethodPost,
+		path:       "/other",
+		wantStatus: http.StatusNotFound,
+	}, {
+		desc:       "Wrong method",
+		method:     http.MethodGet,
+		path:       RequestVoucherPath,
+		wantStatus: http.StatusMethodNotAllowed,
+	}, {
+		desc:       "Malformed request",
+		method:     http.MethodPost,
+		path:       RequestVoucherPath,
+		body:       "{",
+		wantStatus: http.StatusBadRequest,
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status %v, want %v", resp.StatusCode, test.wantStatus)
+			}
+		})
+	}
+}
@@ -16,12 +16,14 @@
 package entitymanager
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
@@ -30,7 +32,10 @@ import (
 	"regexp"
 	"sync"
 
+	"github.com/openconfig/bootz/common/hashalg"
+	"github.com/openconfig/bootz/common/revocation"
 	"github.com/openconfig/bootz/server/service"
+	"go.mozilla.org/pkcs7"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
@@ -47,6 +52,40 @@ var (
 	rxBase64 = regexp.MustCompile(`^(?:[A-Za-z0-9+\\/]{4})*(?:[A-Za-z0-9+\\/]{2}==|[A-Za-z0-9+\\/]{3}=|[A-Za-z0-9+\\/]{4})$`)
 )
 
+// VoucherIssuer issues ownership vouchers on demand, e.g. a manufacturer's voucher service.
+type VoucherIssuer interface {
+	// RequestVoucher returns a CMS signed ownership voucher for the given serial
+	// number which is bound to the given nonce.
+	RequestVoucher(serial string, nonce []byte) ([]byte, error)
+}
+
+// RevocationStapler fetches revocation information for the ownership certificate chain.
+type RevocationStapler interface {
+	// Staple returns CRLs and OCSP responses for every certificate in chain
+	// except the last, which is the trust anchor.
+	Staple(chain []*x509.Certificate) (*revocation.Info, error)
+}
+
+// InventoryObserver is notified of the changes to the chassis inventory, e.g. to
+// keep the DHCP reservations in sync with it.
+type InventoryObserver interface {
+	// ChassisChanged is called with the chassis before and after each change. old is
+	// nil when a chassis is added and updated is nil when it is deleted. It is called
+	// with the inventory locked, so it must not call back into the entity manager.
+	ChassisChanged(old, updated *epb.Chassis)
+}
+
+// InventoryValidator is an InventoryObserver which can also reject changes to
+// the chassis inventory, e.g. those making DHCP reservations conflict.
+type InventoryValidator interface {
+	InventoryObserver
+	// ValidateInventory is called with every chassis of the inventory as it
+	// would be after a change, and rejects the change if it returns an error.
+	// It is called with the inventory locked, so it must not call back into the
+	// entity manager.
+	ValidateInventory(chassis []*epb.Chassis) error
+}
+
 // InMemoryEntityManager provides a simple in memory handler
 // for Entities.
 type InMemoryEntityManager struct {
@@ -60,6 +99,16 @@ type InMemoryEntityManager struct {
 	// security artifacts  (OVs, OC and PDC).
 	// TODO: handle mutlti-vendor case
 	secArtifacts *service.SecurityArtifacts
+	// voucherIssuer, if set, is used to request a fresh OV for each signed
+	// response instead of using the OVs from the inventory.
+	voucherIssuer VoucherIssuer
+	// revocationStapler, if set, is used to attach revocation information
+	// for the OC chain to each signed response.
+	revocationStapler RevocationStapler
+	// cmsSignature, if set, adds a CMS signature alongside the PKCS#1 one.
+	cmsSignature bool
+	// observer, if set, is notified of the changes to the chassis inventory.
+	observer InventoryObserver
 }
 
 // ResolveChassis returns an entity based on the provided lookup.
@@ -316,14 +365,26 @@ func isBase64(str string) bool {
 
 // Sign unmarshals the SignedResponse bytes then generates a signature from its Ownership Certificate private key.
 func (m *InMemoryEntityManager) Sign(resp *bpb.GetBootstrapDataResponse, chassis *service.EntityLookup, controllerCard string) error {
+	// Copy what is needed under the lock, so that requesting the voucher and
+	// stapling, which may go over the network, do not block other requests.
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	artifacts := m.secArtifacts
+	issuer := m.voucherIssuer
+	stapler := m.revocationStapler
+	cmsSignature := m.cmsSignature
+	var ov string
+	var ovErr error
+	if issuer == nil {
+		ov, ovErr = m.fetchOwnershipVoucher(chassis, controllerCard)
+	}
+	m.mu.Unlock()
+
 	// check if sec artifacts areprovided for signing
-	if m.secArtifacts == nil {
+	if artifacts == nil {
 		return status.Errorf(codes.Internal, "security artifact is missing")
 	}
 	log.Infof("Decoding the OC private key...")
-	block, _ := pem.Decode([]byte(m.secArtifacts.OC.Key))
+	block, _ := pem.Decode([]byte(artifacts.OC.Key))
 	if block == nil {
 		return status.Errorf(codes.Internal, "unable to decode OC private key")
 	}
@@ -337,11 +398,14 @@ func (m *InMemoryEntityManager) Sign(resp *bpb.GetBootstrapDataResponse, chassis
 		return status.Errorf(codes.InvalidArgument, "empty signed response")
 	}
 
+	// The signatures are computed over these exact bytes, which are sent alongside
+	// signed_response since proto serialization is not canonical.
 	log.Infof("Marshalling the response...")
-	signedResponseBytes, err := proto.Marshal(resp.GetSignedResponse())
+	signedResponseBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(resp.GetSignedResponse())
 	if err != nil {
 		return err
 	}
+	resp.SerializedSignedResponse = signedResponseBytes
 	log.Infof("Successfully serialized the response")
 
 	log.Infof("Calculating the sha256 sum to encrypt the response...")
@@ -355,27 +419,123 @@ func (m *InMemoryEntityManager) Sign(resp *bpb.GetBootstrapDataResponse, chassis
 	resp.ResponseSignature = base64.StdEncoding.EncodeToString(sig)
 	log.Infof("Response signature set")
 
-	// Populate the OV
-	ov, err := m.fetchOwnershipVoucher(chassis, controllerCard)
-	if err != nil {
-		return err
+	if cmsSignature {
+		log.Infof("Creating the CMS response signature...")
+		cms, err := cmsSign(signedResponseBytes, priv, artifacts)
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to create CMS response signature: %v", err)
+		}
+		resp.ResponseSignatureCms = cms
+		log.Infof("CMS response signature set")
 	}
-	ovByte := []byte(ov)
-	if isBase64(ov) {
-		ovByte, err = base64.StdEncoding.DecodeString(ov)
+
+	// Populate the OV
+	var ovByte []byte
+	if issuer != nil {
+		serial := controllerCard
+		if serial == "" {
+			serial = chassis.SerialNumber
+		}
+		log.Infof("Requesting a nonceful OV for serial %v", serial)
+		ovByte, err = issuer.RequestVoucher(serial, []byte(resp.GetSignedResponse().GetNonce()))
 		if err != nil {
-			return status.Errorf(codes.Internal, "unable to decode ov from base64")
+			return status.Errorf(codes.Unavailable, "unable to request ownership voucher: %v", err)
+		}
+	} else {
+		if ovErr != nil {
+			return ovErr
+		}
+		ovByte = []byte(ov)
+		if isBase64(ov) {
+			ovByte, err = base64.StdEncoding.DecodeString(ov)
+			if err != nil {
+				return status.Errorf(codes.Internal, "unable to decode ov from base64")
+			}
 		}
 	}
 	resp.OwnershipVoucher = ovByte
 	log.Infof("OV populated")
 
 	// Populate the OC
-	resp.OwnershipCertificate = []byte(m.secArtifacts.OC.Cert)
+	resp.OwnershipCertificate = []byte(artifacts.OC.Cert)
 	log.Infof("OC populated")
+
+	if stapler != nil {
+		stapleRevocationInfo(resp, artifacts, stapler)
+	}
 	return nil
 }
 
+// stapleRevocationInfo attaches revocation information for the OC chain to the response.
+// Failures are not fatal since the device can still fetch the information itself.
+func stapleRevocationInfo(resp *bpb.GetBootstrapDataResponse, artifacts *service.SecurityArtifacts, stapler RevocationStapler) {
+	chain, err := ownershipCertificateChain(artifacts)
+	if err != nil {
+		log.Warningf("Unable to staple revocation information: %v", err)
+		return
+	}
+	info, err := stapler.Staple(chain)
+	if err != nil {
+		log.Warningf("Unable to staple revocation information: %v", err)
+		return
+	}
+	resp.OwnershipCertificateCrls = info.CRLs
+	resp.OwnershipCertificateOcspResponses = info.OCSPResponses
+	log.Infof("Stapled %d CRLs and %d OCSP responses", len(info.CRLs), len(info.OCSPResponses))
+}
+
+// cmsSign returns a CMS SignedData structure with detached content over data,
+// signed by the OC and carrying the OC chain.
+func cmsSign(data []byte, priv *rsa.PrivateKey, artifacts *service.SecurityArtifacts) ([]byte, error) {
+	chain, err := ownershipCertificateChain(artifacts)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := pkcs7.NewSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	sd.SetEncryptionAlgorithm(pkcs7.OIDEncryptionAlgorithmRSA)
+	if err := sd.AddSignerChain(chain[0], priv, chain[1:], pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	sd.Detach()
+	return sd.Finish()
+}
+
+// ownershipCertificateChain returns the OC, any intermediates bundled with it and the PDC.
+func ownershipCertificateChain(artifacts *service.SecurityArtifacts) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for rest := []byte(artifacts.OC.Cert); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OC: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("unable to decode OC")
+	}
+	block, _ := pem.Decode([]byte(artifacts.PDC.Cert))
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode PDC")
+	}
+	pdc, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse PDC: %v", err)
+	}
+	if !bytes.Equal(chain[len(chain)-1].Raw, pdc.Raw) {
+		chain = append(chain, pdc)
+	}
+	return chain, nil
+}
+
 // fetchOwnershipVoucher retrieves the ownership voucher for a control card
 func (m *InMemoryEntityManager) fetchOwnershipVoucher(lookup *service.EntityLookup, ccSerial string) (string, error) {
 	chassis, ok := m.chassisInventory[*lookup]
@@ -416,15 +576,69 @@ func (m *InMemoryEntityManager) AddChassis(bootMode bpb.BootMode, manufacturer s
 		Manufacturer: manufacturer,
 		SerialNumber: serial,
 	}
+	old := m.chassisInventory[l]
 	m.chassisInventory[l] = &epb.Chassis{
 		Manufacturer: manufacturer,
 		SerialNumber: serial,
 		BootMode:     bootMode,
 	}
+	m.notify(old, m.chassisInventory[l])
 	log.Infof("Added %v chassis %v to server entity manager", manufacturer, serial)
 	return m
 }
 
+// SetVoucherIssuer makes the entity manager request a fresh ownership voucher
+// from the issuer for every signed response.
+func (m *InMemoryEntityManager) SetVoucherIssuer(issuer VoucherIssuer) *InMemoryEntityManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.voucherIssuer = issuer
+	return m
+}
+
+// SetRevocationStapler makes the entity manager attach revocation information
+// for the ownership certificate chain to every signed response.
+func (m *InMemoryEntityManager) SetRevocationStapler(stapler RevocationStapler) *InMemoryEntityManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revocationStapler = stapler
+	return m
+}
+
+// SetCMSResponseSignature makes the entity manager add a CMS signature
+// over the signed response alongside the PKCS#1 one.
+func (m *InMemoryEntityManager) SetCMSResponseSignature(enabled bool) *InMemoryEntityManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cmsSignature = enabled
+	return m
+}
+
+// SetInventoryObserver makes the entity manager notify the observer of every
+// change to the chassis inventory.
+func (m *InMemoryEntityManager) SetInventoryObserver(observer InventoryObserver) *InMemoryEntityManager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observer = observer
+	return m
+}
+
+// notify tells the observer, if any, about a change to the chassis inventory.
+// The caller must hold m.mu.
+func (m *InMemoryEntityManager) notify(old, updated *epb.Chassis) {
+	if m.observer == nil || (old == nil && updated == nil) {
+		return
+	}
+	m.observer.ChassisChanged(old, updated)
+}
+
+// GetOptions returns a copy of the global options of the inventory.
+func (m *InMemoryEntityManager) GetOptions() *epb.Options {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return proto.Clone(m.defaults).(*epb.Options)
+}
+
 // GetChassisInventory returns the chassis inventory
 func (m *InMemoryEntityManager) GetChassisInventory() map[service.EntityLookup]*epb.Chassis {
 	return m.chassisInventory
@@ -457,6 +671,9 @@ func New(chassisConfigFile string) (*InMemoryEntityManager, error) {
 			Manufacturer: ch.GetManufacturer(),
 			SerialNumber: ch.GetSerialNumber(),
 		}
+		if err := normalizeSoftwareImage(ch.GetSoftwareImage()); err != nil {
+			return nil, fmt.Errorf("invalid software image for chassis %v: %v", ch.GetSerialNumber(), err)
+		}
 		newManager.chassisInventory[lookup] = ch
 	}
 	newManager.defaults = entities.GetOptions()
@@ -471,26 +688,64 @@ func New(chassisConfigFile string) (*InMemoryEntityManager, error) {
 	return newManager, nil
 }
 
+// normalizeSoftwareImage checks that the image's hash algorithm is supported and that
+// the digest has the right length, and rewrites both in canonical form so that
+// devices only ever see canonical algorithm names and lower case digests.
+func normalizeSoftwareImage(image *bpb.SoftwareImage) error {
+	if image.GetOsImageHash() == "" && image.GetHashAlgorithm() == "" {
+		return nil
+	}
+	alg, err := hashalg.Lookup(image.GetHashAlgorithm())
+	if err != nil {
+		return err
+	}
+	digest, err := alg.DecodeDigest(image.GetOsImageHash())
+	if err != nil {
+		return err
+	}
+	image.HashAlgorithm = alg.Name
+	image.OsImageHash = hex.EncodeToString(digest)
+	return nil
+}
+
 // ReplaceDevice replaces an existing chassis with a new chassis object.
 func (m *InMemoryEntityManager) ReplaceDevice(chassis *service.EntityLookup, newChassis *epb.Chassis) error {
 	// Chassis: old device lookup, newChassis: new device
 
 	// todo: Validate before replace
 	// todo: Forward error from validateConfig
+	if err := normalizeSoftwareImage(newChassis.GetSoftwareImage()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid software image for chassis %v: %v", newChassis.GetSerialNumber(), err)
+	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.chassisInventory, *chassis)
-
 	lookup := service.EntityLookup{
 		Manufacturer: newChassis.GetManufacturer(),
 		SerialNumber: newChassis.GetSerialNumber(),
 	}
+	if v, ok := m.observer.(InventoryValidator); ok {
+		inventory := []*epb.Chassis{newChassis}
+		for l, c := range m.chassisInventory {
+			if l != *chassis && l != lookup {
+				inventory = append(inventory, c)
+			}
+		}
+		if err := v.ValidateInventory(inventory); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid chassis %v: %v", newChassis.GetSerialNumber(), err)
+		}
+	}
 
-	m.chassisInventory[lookup] = newChassis
+	old := m.chassisInventory[*chassis]
+	delete(m.chassisInventory, *chassis)
 
-	// This method will be able to return an error when validation is added.
+	// The new chassis may take the place of another one.
+	if other, ok := m.chassisInventory[lookup]; ok {
+		m.notify(other, nil)
+	}
+	m.chassisInventory[lookup] = newChassis
+	m.notify(old, newChassis)
 	return nil
 }
 
@@ -499,7 +754,9 @@ func (m *InMemoryEntityManager) DeleteDevice(chassis *service.EntityLookup) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	old := m.chassisInventory[*chassis]
 	delete(m.chassisInventory, *chassis)
+	m.notify(old, nil)
 }
 
 // GetDevice returns a copy of the chassis at the provided lookup.
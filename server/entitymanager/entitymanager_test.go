@@ -15,19 +15,26 @@
 package entitymanager
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/h-fam/errdiff"
+	"github.com/openconfig/bootz/common/revocation"
 	"github.com/openconfig/bootz/server/service"
+	"go.mozilla.org/pkcs7"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 
@@ -298,9 +305,13 @@ func TestSign(t *testing.T) {
 				}
 				t.Errorf("Sign() err = %v, want %v", err, test.wantErr)
 			}
-			signedResponseBytes, err := proto.Marshal(test.resp.GetSignedResponse())
-			if err != nil {
-				t.Fatal(err)
+			signedResponseBytes := test.resp.GetSerializedSignedResponse()
+			gotSigned := &bpb.BootstrapDataSigned{}
+			if err := proto.Unmarshal(signedResponseBytes, gotSigned); err != nil {
+				t.Fatalf("unable to unmarshal serialized signed response: %v", err)
+			}
+			if !proto.Equal(gotSigned, test.resp.GetSignedResponse()) {
+				t.Errorf("Sign() serialized signed response = %v, want %v", gotSigned, test.resp.GetSignedResponse())
 			}
 			hashed := sha256.Sum256(signedResponseBytes)
 			sigDecoded, err := base64.StdEncoding.DecodeString(test.resp.GetResponseSignature())
@@ -337,6 +348,210 @@ func TestSign(t *testing.T) {
 	}
 }
 
+func TestSignCMS(t *testing.T) {
+	em, err := New("../../testdata/inventory.prototxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	em.SetCMSResponseSignature(true)
+	resp := &bpb.GetBootstrapDataResponse{
+		SignedResponse: &bpb.BootstrapDataSigned{
+			Responses: []*bpb.BootstrapDataResponse{{SerialNum: "123A"}},
+			Nonce:     "some-nonce",
+		},
+	}
+	if err := em.Sign(resp, &service.EntityLookup{Manufacturer: "Cisco", SerialNumber: "123"}, "123A"); err != nil {
+		t.Fatalf("Sign() err = %v, want nil", err)
+	}
+	if resp.GetResponseSignature() == "" {
+		t.Errorf("Sign() did not set the PKCS#1 response signature")
+	}
+	p7, err := pkcs7.Parse(resp.GetResponseSignatureCms())
+	if err != nil {
+		t.Fatalf("unable to parse CMS response signature: %v", err)
+	}
+	if len(p7.Content) != 0 {
+		t.Errorf("CMS response signature has attached content, want detached")
+	}
+	p7.Content = resp.GetSerializedSignedResponse()
+	pdcPool := x509.NewCertPool()
+	if !pdcPool.AppendCertsFromPEM([]byte(em.secArtifacts.PDC.Cert)) {
+		t.Fatal("unable to add PDC to pool")
+	}
+	if err := p7.VerifyWithChain(pdcPool); err != nil {
+		t.Errorf("VerifyWithChain() err = %v, want nil", err)
+	}
+	block, _ := pem.Decode([]byte(em.secArtifacts.OC.Cert))
+	if block == nil {
+		t.Fatal("unable to decode OC")
+	}
+	if signer := p7.GetOnlySigner(); signer == nil || !bytes.Equal(signer.Raw, block.Bytes) {
+		t.Errorf("CMS signer is not the OC")
+	}
+
+	// The signature must not verify over different content.
+	p7.Content = []byte("tampered")
+	if err := p7.Verify(); err == nil {
+		t.Errorf("Verify() of tampered content err = nil, want error")
+	}
+}
+
+type fakeVoucherIssuer struct {
+	gotSerial string
+	gotNonce  []byte
+	err       error
+	// during, if set, is called while the voucher is requested.
+	during func()
+}
+
+func (f *fakeVoucherIssuer) RequestVoucher(serial string, nonce []byte) ([]byte, error) {
+	f.gotSerial = serial
+	f.gotNonce = nonce
+	if f.during != nil {
+		f.during()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte("issued-ov"), nil
+}
+
+func TestSignWithVoucherIssuer(t *testing.T) {
+	tests := []struct {
+		desc       string
+		serial     string
+		issuerErr  error
+		wantSerial string
+		wantErr    bool
+	}{{
+		desc:       "Control card serial",
+		serial:     "123A",
+		wantSerial: "123A",
+	}, {
+		desc:       "Fixed chassis uses chassis serial",
+		serial:     "",
+		wantSerial: "123",
+	}, {
+		desc:      "Issuer unavailable",
+		serial:    "123A",
+		issuerErr: fmt.Errorf("connection refused"),
+		wantErr:   true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			em, err := New("../../testdata/inventory.prototxt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer := &fakeVoucherIssuer{err: test.issuerErr}
+			em.SetVoucherIssuer(issuer)
+			resp := &bpb.GetBootstrapDataResponse{
+				SignedResponse: &bpb.BootstrapDataSigned{
+					Responses: []*bpb.BootstrapDataResponse{{SerialNum: "123A"}},
+					Nonce:     "some-nonce",
+				},
+			}
+			err = em.Sign(resp, &service.EntityLookup{Manufacturer: "Cisco", SerialNumber: "123"}, test.serial)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Sign() err = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if issuer.gotSerial != test.wantSerial {
+				t.Errorf("RequestVoucher() serial = %v, want %v", issuer.gotSerial, test.wantSerial)
+			}
+			if got, want := string(issuer.gotNonce), "some-nonce"; got != want {
+				t.Errorf("RequestVoucher() nonce = %v, want %v", got, want)
+			}
+			if got, want := string(resp.GetOwnershipVoucher()), "issued-ov"; got != want {
+				t.Errorf("Sign() ov = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSignDoesNotLockWhileRequestingVoucher(t *testing.T) {
+	em, err := New("../../testdata/inventory.prototxt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A slow voucher service must not block the other requests.
+	em.SetVoucherIssuer(&fakeVoucherIssuer{during: func() { em.GetAll() }})
+	resp := &bpb.GetBootstrapDataResponse{
+		SignedResponse: &bpb.BootstrapDataSigned{
+			Responses: []*bpb.BootstrapDataResponse{{SerialNum: "123A"}},
+		},
+	}
+	done := make(chan error)
+	go func() {
+		done <- em.Sign(resp, &service.EntityLookup{Manufacturer: "Cisco", SerialNumber: "123"}, "123A")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Sign() err = %v, want nil", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Sign() held the inventory lock while requesting the voucher")
+	}
+}
+
+type fakeStapler struct {
+	gotChain []*x509.Certificate
+	err      error
+}
+
+func (f *fakeStapler) Staple(chain []*x509.Certificate) (*revocation.Info, error) {
+	f.gotChain = chain
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &revocation.Info{CRLs: [][]byte{[]byte("crl")}, OCSPResponses: [][]byte{[]byte("ocsp")}}, nil
+}
+
+func TestSignStaplesRevocationInfo(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stapleErr  error
+		wantStaple bool
+	}{{
+		desc:       "Stapled",
+		wantStaple: true,
+	}, {
+		desc:      "Stapling failure is not fatal",
+		stapleErr: fmt.Errorf("responder unreachable"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			em, err := New("../../testdata/inventory.prototxt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			stapler := &fakeStapler{err: test.stapleErr}
+			em.SetRevocationStapler(stapler)
+			resp := &bpb.GetBootstrapDataResponse{
+				SignedResponse: &bpb.BootstrapDataSigned{
+					Responses: []*bpb.BootstrapDataResponse{{SerialNum: "123A"}},
+				},
+			}
+			if err := em.Sign(resp, &service.EntityLookup{Manufacturer: "Cisco", SerialNumber: "123"}, "123A"); err != nil {
+				t.Fatalf("Sign() err = %v, want nil", err)
+			}
+			// The test OC is the PDC itself, so the chain is just the trust anchor.
+			if len(stapler.gotChain) != 1 {
+				t.Errorf("Staple() got chain of length %d, want 1", len(stapler.gotChain))
+			}
+			gotStaple := len(resp.GetOwnershipCertificateCrls()) == 1 && len(resp.GetOwnershipCertificateOcspResponses()) == 1
+			if gotStaple != test.wantStaple {
+				t.Errorf("Sign() stapled = %v, want %v", gotStaple, test.wantStaple)
+			}
+		})
+	}
+}
+
 func TestSetStatus(t *testing.T) {
 	tests := []struct {
 		desc    string
@@ -746,6 +961,7 @@ func TestGetAll(t *testing.T) {
 func TestReplaceDevice(t *testing.T) {
 	tests := []struct {
 		chassisInventory     *epb.Entities
+		softwareImage        *bpb.SoftwareImage
 		wantChassisInventory *epb.Entities
 		name                 string
 		wantErr              string
@@ -769,6 +985,81 @@ func TestReplaceDevice(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Hash algorithm and digest are canonicalized",
+			chassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "1234",
+						Manufacturer: "cisco",
+					},
+				},
+			},
+			softwareImage: &bpb.SoftwareImage{
+				OsImageHash:   "E9C0F8B575CBFCB42AB3B78ECC87EFA3B011D9A5D10B09FA4E96F240BF6A82F5",
+				HashAlgorithm: "sha-256",
+			},
+			wantChassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "5678",
+						Manufacturer: "cisco",
+						SoftwareImage: &bpb.SoftwareImage{
+							OsImageHash:   "e9c0f8b575cbfcb42ab3b78ecc87efa3b011d9a5d10b09fa4e96f240bf6a82f5",
+							HashAlgorithm: "SHA256",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Unsupported hash algorithm",
+			chassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "1234",
+						Manufacturer: "cisco",
+					},
+				},
+			},
+			softwareImage: &bpb.SoftwareImage{
+				OsImageHash:   "d41d8cd98f00b204e9800998ecf8427e",
+				HashAlgorithm: "MD5",
+			},
+			wantChassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "1234",
+						Manufacturer: "cisco",
+					},
+				},
+			},
+			wantErr: "unsupported hash algorithm",
+		},
+		{
+			name: "Digest of the wrong length",
+			chassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "1234",
+						Manufacturer: "cisco",
+					},
+				},
+			},
+			softwareImage: &bpb.SoftwareImage{
+				OsImageHash:   "e9c0f8b575cbfcb42ab3b78ecc87efa3b011d9a5d10b09fa4e96f240bf6a82f5",
+				HashAlgorithm: "SHA512",
+			},
+			wantChassisInventory: &epb.Entities{
+				Chassis: []*epb.Chassis{
+					{
+						SerialNumber: "1234",
+						Manufacturer: "cisco",
+					},
+				},
+			},
+			wantErr: "must be 64 bytes",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -787,16 +1078,15 @@ func TestReplaceDevice(t *testing.T) {
 			}
 
 			newObj := &epb.Chassis{
-				SerialNumber: "5678",
-				Manufacturer: "cisco",
+				SerialNumber:  "5678",
+				Manufacturer:  "cisco",
+				SoftwareImage: tt.softwareImage,
 			}
 
 			err := em.ReplaceDevice(&service.EntityLookup{SerialNumber: "1234", Manufacturer: "cisco"}, newObj)
 
 			received := em.chassisInventory
 
-			// todo: This test will require error checking after ValidateConfig is implemented.
-
 			if s := errdiff.Check(err, tt.wantErr); s != "" {
 				t.Errorf("Expected error %s, but got error %v", tt.wantErr, err)
 			} else if !(cmp.Equal(want, received, protocmp.Transform())) {
@@ -868,3 +1158,78 @@ func TestDeleteDevice(t *testing.T) {
 		})
 	}
 }
+
+// fakeObserver records the inventory changes as "old->updated" chassis serials.
+type fakeObserver struct {
+	changes []string
+}
+
+func (o *fakeObserver) ChassisChanged(old, updated *epb.Chassis) {
+	o.changes = append(o.changes, old.GetSerialNumber()+"->"+updated.GetSerialNumber())
+}
+
+func TestInventoryObserver(t *testing.T) {
+	em, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &fakeObserver{}
+	em.SetInventoryObserver(o)
+
+	em.AddChassis(bpb.BootMode_BOOT_MODE_SECURE, "cisco", "1234").AddChassis(bpb.BootMode_BOOT_MODE_SECURE, "cisco", "5678")
+	if err := em.ReplaceDevice(&service.EntityLookup{SerialNumber: "1234", Manufacturer: "cisco"}, &epb.Chassis{SerialNumber: "9999", Manufacturer: "cisco"}); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing a chassis by another one in the inventory removes the latter.
+	if err := em.ReplaceDevice(&service.EntityLookup{SerialNumber: "9999", Manufacturer: "cisco"}, &epb.Chassis{SerialNumber: "5678", Manufacturer: "cisco"}); err != nil {
+		t.Fatal(err)
+	}
+	em.DeleteDevice(&service.EntityLookup{SerialNumber: "5678", Manufacturer: "cisco"})
+	// Deleting a chassis not in the inventory changes nothing.
+	em.DeleteDevice(&service.EntityLookup{SerialNumber: "1234", Manufacturer: "cisco"})
+
+	want := []string{"->1234", "->5678", "1234->9999", "5678->", "9999->5678", "5678->"}
+	if diff := cmp.Diff(want, o.changes); diff != "" {
+		t.Errorf("ChassisChanged() calls diff (-want +got):\n%s", diff)
+	}
+}
+
+// fakeValidator rejects the inventories with more than max chassis.
+type fakeValidator struct {
+	fakeObserver
+	max int
+}
+
+func (v *fakeValidator) ValidateInventory(chassis []*epb.Chassis) error {
+	if len(chassis) > v.max {
+		return fmt.Errorf("%d chassis, want at most %d", len(chassis), v.max)
+	}
+	return nil
+}
+
+func TestInventoryValidator(t *testing.T) {
+	em, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &fakeValidator{max: 1}
+	em.SetInventoryObserver(v)
+	if err := em.ReplaceDevice(&service.EntityLookup{SerialNumber: "1234", Manufacturer: "cisco"}, &epb.Chassis{SerialNumber: "1234", Manufacturer: "cisco"}); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing the chassis keeps one chassis.
+	if err := em.ReplaceDevice(&service.EntityLookup{SerialNumber: "1234", Manufacturer: "cisco"}, &epb.Chassis{SerialNumber: "9999", Manufacturer: "cisco"}); err != nil {
+		t.Fatal(err)
+	}
+	err = em.ReplaceDevice(&service.EntityLookup{SerialNumber: "5678", Manufacturer: "cisco"}, &epb.Chassis{SerialNumber: "5678", Manufacturer: "cisco"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ReplaceDevice() of a rejected inventory err = %v, want InvalidArgument", err)
+	}
+	if _, err := em.GetDevice(&service.EntityLookup{SerialNumber: "5678", Manufacturer: "cisco"}); err == nil {
+		t.Errorf("GetDevice() found a rejected chassis")
+	}
+	want := []string{"->1234", "1234->9999"}
+	if diff := cmp.Diff(want, v.changes); diff != "" {
+		t.Errorf("ChassisChanged() calls diff (-want +got):\n%s", diff)
+	}
+}
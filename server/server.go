@@ -24,31 +24,50 @@ import (
 	"crypto/x509"
 	"flag"
 	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	log "github.com/golang/glog"
+	"github.com/openconfig/bootz/common/revocation"
 	"github.com/openconfig/bootz/dhcp"
+	"github.com/openconfig/bootz/dhcp/relay"
+	"github.com/openconfig/bootz/masa"
 	"github.com/openconfig/bootz/server/entitymanager"
 	"github.com/openconfig/bootz/server/service"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
 	bpb "github.com/openconfig/bootz/proto/bootz"
+	epb "github.com/openconfig/bootz/server/entitymanager/proto/entity"
 )
 
 var (
 	port              = flag.String("port", "15006", "The port to start the Bootz server on localhost")
-	dhcpIntf          = flag.String("dhcp_intf", "", "Network interface to use for dhcp server.")
+	advertiseAddr     = flag.String("bootz_advertise_addr", "", "Address devices reach the Bootz server on, as host or host:port, advertised by the dhcp server to devices without a bootz server of their own. The port defaults to port. If set, the Bootz server listens on all addresses instead of localhost.")
+	dhcpIntf          = flag.String("dhcp_intf", "", "Network interfaces to use for dhcp server, separated by commas.")
+	dhcpFamilies      = flag.String("dhcp_families", "", "Address families the dhcp server serves, ipv4 and/or ipv6 separated by commas. Both if empty.")
+	dhcpVendorClasses = flag.String("dhcp_vendor_classes", "", "Vendor classes of the devices the bootz server is advertised to by the dhcp server, separated by commas. All devices if empty.")
+	dhcpLeaseFile     = flag.String("dhcp_lease_file", "", "File the dhcp server persists its leases to. If empty, the leases are only kept in memory.")
+	dhcpAdminPort     = flag.String("dhcp_admin_port", "", "If set, the dhcp leases are served as JSON on http://localhost:<dhcp_admin_port>/leases.")
 	artifactDirectory = flag.String("artifact_dir", "../testdata/", "The relative directory to look into for certificates, private keys and OVs.")
 	inventoryConfig   = flag.String("inv_config", "../testdata/inventory_local.prototxt", "Devices' config files to be loaded by inventory manager")
+	masaURL           = flag.String("masa_url", "", "Base URL of a voucher issuing service. If set, a nonceful OV is requested for every secure request instead of using the inventory OVs.")
+	cmsSignature      = flag.Bool("cms_response_signature", false, "Whether to add a CMS signature over the signed response alongside the PKCS#1 one.")
+	stapleRevocation  = flag.Bool("staple_revocation_info", false, "Whether to fetch CRLs and OCSP responses for the ownership certificate chain and include them in every secure response.")
 )
 
 type server struct {
 	serv *grpc.Server
 	lis  net.Listener
+	// dhcp is the dhcp server, if one was started.
+	dhcp *dhcp.Server
 }
 
 // readKeyPair reads the cert/key pair from the specified artifacts directory.
@@ -138,6 +157,9 @@ func (s *server) Start() error {
 
 func (s *server) Stop() {
 	s.serv.GracefulStop()
+	if s.dhcp != nil {
+		s.dhcp.Stop()
+	}
 }
 
 // newServer creates a new Bootz gRPC server from flags.
@@ -161,10 +183,16 @@ func newServer() (*server, error) {
 		return nil, fmt.Errorf("unable to initiate inventory manager %v", err)
 	}
 
-	if *dhcpIntf != "" {
-		if err := startDhcpServer(em); err != nil {
-			return nil, fmt.Errorf("unable to start dhcp server %v", err)
-		}
+	if *masaURL != "" {
+		log.Infof("Requesting ownership vouchers from %v", *masaURL)
+		em.SetVoucherIssuer(masa.NewClient(*masaURL, nil))
+	}
+	if *cmsSignature {
+		em.SetCMSResponseSignature(true)
+	}
+	if *stapleRevocation {
+		log.Infof("Stapling revocation information for the ownership certificate chain")
+		em.SetRevocationStapler(revocation.NewStapler())
 	}
 
 	c := service.New(em)
@@ -181,13 +209,25 @@ func newServer() (*server, error) {
 	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tls)))
 	bpb.RegisterBootstrapServer(s, c)
 
-	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%v", *port))
+	listenAddr := fmt.Sprintf("localhost:%v", *port)
+	if *advertiseAddr != "" {
+		listenAddr = fmt.Sprintf(":%v", *port)
+	}
+	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return nil, fmt.Errorf("error listening on port: %v", err)
 	}
+
+	var dhcpServer *dhcp.Server
+	if *dhcpIntf != "" {
+		if dhcpServer, err = startDhcpServer(em, lis.Addr().String()); err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("unable to start dhcp server %v", err)
+		}
+	}
 	log.Infof("Server ready and listening on %s", lis.Addr())
 	log.Infof("=============================================================================")
-	return &server{serv: s, lis: lis}, nil
+	return &server{serv: s, lis: lis, dhcp: dhcpServer}, nil
 }
 
 func main() {
@@ -207,24 +247,306 @@ func main() {
 	}
 }
 
-func startDhcpServer(em *entitymanager.InMemoryEntityManager) error {
-	conf := &dhcp.Config{
-		Interface:  *dhcpIntf,
-		AddressMap: make(map[string]*dhcp.Entry),
+// validBootzURL reports whether s is a bootz server URL devices can connect to,
+// e.g. bootz://192.0.2.1:15006.
+func validBootzURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// advertisedBootzURL returns the URL of this server advertised to the devices
+// without a bootz server of their own, from the bootz_advertise_addr flag, or
+// none if it is not set. The port defaults to that of the listen address.
+// Loopback and unspecified addresses are rejected, as devices cannot reach them.
+func advertisedBootzURL(advertise, listenAddr string) (string, error) {
+	if advertise == "" {
+		return "", nil
 	}
+	host, port, err := net.SplitHostPort(advertise)
+	if err != nil {
+		// No port.
+		host = strings.TrimSuffix(strings.TrimPrefix(advertise, "["), "]")
+		if _, port, err = net.SplitHostPort(listenAddr); err != nil {
+			return "", fmt.Errorf("invalid listen address %q: %v", listenAddr, err)
+		}
+	}
+	if ip := net.ParseIP(host); host == "" || host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return "", fmt.Errorf("bootz advertise address %q is not reachable by devices", advertise)
+	}
+	return "bootz://" + net.JoinHostPort(host, port), nil
+}
 
-	for _, c := range em.GetChassisInventory() {
-		if dhcpConf := c.GetDhcpConfig(); dhcpConf != nil {
-			key := dhcpConf.GetHardwareAddress()
-			if key == "" {
-				key = c.GetSerialNumber()
-			}
-			conf.AddressMap[key] = &dhcp.Entry{
-				IP: dhcpConf.GetIpAddress(),
-				Gw: dhcpConf.GetGateway(),
-			}
+// dhcpEntry returns the dhcp record of the chassis and its key: the relay circuit
+// id or remote id of the port the chassis is cabled to, or else the mac address of
+// its management interface, or else its serial. The record carries the bootz server
+// and the options of the chassis, and its hostname defaults to the chassis name.
+func dhcpEntry(c *epb.Chassis) (string, *dhcp.Entry) {
+	return configEntry(c.GetDhcpConfig(), c.GetSerialNumber(), c.GetName(), "")
+}
+
+// configEntry returns the dhcp record of a dhcp config and its key, as dhcpEntry
+// does, with the serial of the device it belongs to. Devices without a hostname
+// or a bootz server of their own get name and bootzURL, if set.
+func configEntry(dhcpConf *epb.DHCPConfig, serial, name, bootzURL string) (string, *dhcp.Entry) {
+	if dhcpConf == nil {
+		return "", nil
+	}
+	var key string
+	switch {
+	case dhcpConf.GetCircuitId() != "":
+		key = relay.CircuitIDKey(dhcpConf.GetCircuitId())
+	case dhcpConf.GetRemoteId() != "":
+		key = relay.RemoteIDKey(dhcpConf.GetRemoteId())
+	case dhcpConf.GetHardwareAddress() != "":
+		key = dhcpConf.GetHardwareAddress()
+	default:
+		key = serial
+	}
+	entry := &dhcp.Entry{
+		IP:         dhcpConf.GetIpAddress(),
+		Gw:         dhcpConf.GetGateway(),
+		BootzURL:   bootzURL,
+		NTP:        dhcpConf.GetNtpServers(),
+		DomainName: dhcpConf.GetDomainName(),
+		Hostname:   name,
+		LeaseTime:  time.Duration(dhcpConf.GetLeaseTime()) * time.Second,
+	}
+	if u := dhcpConf.GetBootzserver(); u != "" {
+		if validBootzURL(u) {
+			entry.BootzURL = u
+		} else {
+			log.Warningf("Ignoring invalid bootz server %q of %v", u, serial)
+		}
+	}
+	if h := dhcpConf.GetHostname(); h != "" {
+		entry.Hostname = h
+	}
+	if mtu := dhcpConf.GetMtu(); mtu > math.MaxUint16 {
+		log.Warningf("Ignoring invalid mtu %v of %v", mtu, serial)
+	} else {
+		entry.MTU = uint16(mtu)
+	}
+	for code, value := range dhcpConf.GetOptions() {
+		if code > math.MaxUint16 {
+			log.Warningf("Ignoring dhcp option %v of %v, which is not an option code", code, serial)
+			continue
+		}
+		if entry.Options == nil {
+			entry.Options = map[uint16][]byte{}
+		}
+		entry.Options[uint16(code)] = value
+	}
+	return key, entry
+}
+
+// reservations collects the dhcp records of the chassis and their control cards,
+// and rejects the hardware addresses, IP addresses and keys assigned twice.
+type reservations struct {
+	entries map[string]*dhcp.Entry
+	// owners are the devices the keys, hardware addresses and IP addresses are
+	// assigned to.
+	owners map[string]string
+}
+
+func newReservations() *reservations {
+	return &reservations{
+		entries: map[string]*dhcp.Entry{},
+		owners:  map[string]string{},
+	}
+}
+
+// claim assigns the value to the device, unless another device already has it.
+func (r *reservations) claim(what, value, owner string) error {
+	k := what + " " + value
+	if other, ok := r.owners[k]; ok {
+		return fmt.Errorf("%v %v of %v is already assigned to %v", what, value, owner, other)
+	}
+	r.owners[k] = owner
+	return nil
+}
+
+// add adds the record of a dhcp config of the device, if it has one.
+func (r *reservations) add(owner string, dhcpConf *epb.DHCPConfig, serial, name, bootzURL string) error {
+	key, entry := configEntry(dhcpConf, serial, name, bootzURL)
+	if entry == nil {
+		return nil
+	}
+	if err := r.claim("dhcp record", key, owner); err != nil {
+		return err
+	}
+	if hw := dhcpConf.GetHardwareAddress(); hw != "" {
+		if mac, err := net.ParseMAC(hw); err == nil {
+			hw = mac.String()
 		}
+		if err := r.claim("hardware address", hw, owner); err != nil {
+			return err
+		}
+	}
+	// Devices without an address get one from the pools, and the options of
+	// their record if it has any.
+	ip := entry.IP
+	if ip == "" {
+		if sendsOptions(entry) {
+			r.entries[key] = entry
+		}
+		return nil
 	}
+	if addr, _, err := net.ParseCIDR(ip); err == nil {
+		ip = addr.String()
+	}
+	if err := r.claim("IP address", ip, owner); err != nil {
+		return err
+	}
+	r.entries[key] = entry
+	return nil
+}
 
-	return dhcp.Start(conf)
+// sendsOptions reports whether a record without an address sends anything to the
+// device. The pools set the lease time of the devices they serve.
+func sendsOptions(e *dhcp.Entry) bool {
+	return e.BootzURL != "" || len(e.NTP) > 0 || e.DomainName != "" || e.Hostname != "" || e.MTU != 0 || len(e.Options) > 0
+}
+
+// addChassis adds the records of the chassis and of its control cards. Control
+// cards without a hostname or a bootz server of their own get those of the chassis.
+func (r *reservations) addChassis(c *epb.Chassis) error {
+	owner := fmt.Sprintf("chassis %v", c.GetSerialNumber())
+	if err := r.add(owner, c.GetDhcpConfig(), c.GetSerialNumber(), c.GetName(), ""); err != nil {
+		return err
+	}
+	_, chassisEntry := dhcpEntry(c)
+	var bootzURL string
+	if chassisEntry != nil {
+		bootzURL = chassisEntry.BootzURL
+	}
+	for _, cc := range c.GetControllerCards() {
+		owner := fmt.Sprintf("control card %v of chassis %v", cc.GetSerialNumber(), c.GetSerialNumber())
+		if err := r.add(owner, cc.GetDhcpConfig(), cc.GetSerialNumber(), c.GetName(), bootzURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dhcpEntries returns the dhcp records of the chassis and of its control cards,
+// keyed as dhcpEntry does.
+func dhcpEntries(c *epb.Chassis) (map[string]*dhcp.Entry, error) {
+	r := newReservations()
+	if err := r.addChassis(c); err != nil {
+		return nil, err
+	}
+	return r.entries, nil
+}
+
+// inventoryEntries returns the dhcp records of every chassis and control card of
+// the inventory. A hardware address, IP address or key assigned to two devices
+// is an error.
+func inventoryEntries(chassis []*epb.Chassis) (map[string]*dhcp.Entry, error) {
+	// The chassis are sorted, so that the errors name the same devices every time.
+	chassis = append([]*epb.Chassis(nil), chassis...)
+	sort.Slice(chassis, func(i, j int) bool { return chassis[i].GetSerialNumber() < chassis[j].GetSerialNumber() })
+	r := newReservations()
+	for _, c := range chassis {
+		if err := r.addChassis(c); err != nil {
+			return nil, fmt.Errorf("invalid dhcp config in the inventory: %v", err)
+		}
+	}
+	return r.entries, nil
+}
+
+// dhcpSync keeps the dhcp records of a server in sync with the chassis inventory.
+type dhcpSync struct {
+	server *dhcp.Server
+}
+
+// ValidateInventory rejects the inventory changes which would assign a hardware
+// address, IP address or key to two devices, as startDhcpServer does.
+func (d dhcpSync) ValidateInventory(chassis []*epb.Chassis) error {
+	_, err := inventoryEntries(chassis)
+	return err
+}
+
+func (d dhcpSync) ChassisChanged(old, updated *epb.Chassis) {
+	entries, err := dhcpEntries(updated)
+	if err != nil {
+		log.Errorf("Unable to update dhcp records of chassis %v: %v", updated.GetSerialNumber(), err)
+		return
+	}
+	oldEntries, _ := dhcpEntries(old)
+	for key := range oldEntries {
+		if _, ok := entries[key]; !ok {
+			d.server.RemoveEntry(key)
+			log.Infof("Removed dhcp record for %v", key)
+		}
+	}
+	for key, entry := range entries {
+		if err := d.server.AddEntry(key, entry); err != nil {
+			log.Errorf("Unable to update dhcp record %v of chassis %v: %v", key, updated.GetSerialNumber(), err)
+			continue
+		}
+		log.Infof("Updated dhcp record for %v: %v", key, entry.IP)
+	}
+}
+
+// startDhcpServer starts a dhcp server with a record for every chassis and control
+// card in the inventory, and keeps the records in sync with the inventory.
+// Devices are sent to their own bootz server if they have one, or else to the global
+// bootz server of the inventory, or else to listenURL.
+func startDhcpServer(em *entitymanager.InMemoryEntityManager, listenAddr string) (*dhcp.Server, error) {
+	var chassis []*epb.Chassis
+	for _, c := range em.GetAll() {
+		chassis = append(chassis, c)
+	}
+	addressMap, err := inventoryEntries(chassis)
+	if err != nil {
+		return nil, err
+	}
+	conf := &dhcp.Config{
+		Interfaces: strings.Split(*dhcpIntf, ","),
+		AddressMap: addressMap,
+		LeaseFile:  *dhcpLeaseFile,
+	}
+	if conf.BootzURL, err = advertisedBootzURL(*advertiseAddr, listenAddr); err != nil {
+		return nil, err
+	}
+	if u := em.GetOptions().GetBootzserver(); u != "" {
+		if validBootzURL(u) {
+			conf.BootzURL = u
+		} else {
+			log.Warningf("Ignoring invalid global bootz server %q", u)
+		}
+	}
+	if *dhcpFamilies != "" {
+		for _, f := range strings.Split(*dhcpFamilies, ",") {
+			conf.Families = append(conf.Families, dhcp.Family(f))
+		}
+	}
+	if *dhcpVendorClasses != "" {
+		conf.VendorClasses = strings.Split(*dhcpVendorClasses, ",")
+	}
+	if conf.BootzURL != "" {
+		log.Infof("Advertising bootz server %v", conf.BootzURL)
+	} else {
+		log.Warningf("Devices without a bootz server of their own are not redirected: this server listens on %v, which they cannot reach. Set bootz_advertise_addr to advertise a reachable address.", listenAddr)
+	}
+
+	srv, err := dhcp.New(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	em.SetInventoryObserver(dhcpSync{server: srv})
+
+	if *dhcpAdminPort != "" {
+		addr := fmt.Sprintf("localhost:%v", *dhcpAdminPort)
+		log.Infof("Serving dhcp leases on http://%v%v", addr, dhcp.LeasesPath)
+		go func() {
+			if err := http.ListenAndServe(addr, dhcp.NewAdminHandler(srv)); err != nil {
+				log.Errorf("Error serving dhcp admin endpoint: %v", err)
+			}
+		}()
+	}
+	return srv, nil
 }
@@ -16,7 +16,18 @@ package main
 
 import (
 	"flag"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/h-fam/errdiff"
+	"github.com/openconfig/bootz/dhcp"
+	"github.com/openconfig/bootz/server/entitymanager"
+	"github.com/openconfig/bootz/server/service"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	epb "github.com/openconfig/bootz/server/entitymanager/proto/entity"
 )
 
 // TestStartup tests that a gRPC server can be created with the default flags.
@@ -27,3 +38,320 @@ func TestStartup(t *testing.T) {
 		t.Fatalf("newServer() err = %v, want nil", err)
 	}
 }
+
+func TestAdvertisedBootzURL(t *testing.T) {
+	tests := []struct {
+		desc      string
+		advertise string
+		want      string
+		wantErr   string
+	}{{
+		desc: "Not set",
+	}, {
+		desc:      "Address and port",
+		advertise: "192.0.2.1:16006",
+		want:      "bootz://192.0.2.1:16006",
+	}, {
+		desc:      "Address without port",
+		advertise: "192.0.2.1",
+		want:      "bootz://192.0.2.1:15006",
+	}, {
+		desc:      "IPv6 address without port",
+		advertise: "2001:db8::1",
+		want:      "bootz://[2001:db8::1]:15006",
+	}, {
+		desc:      "Host name",
+		advertise: "bootz.example.com",
+		want:      "bootz://bootz.example.com:15006",
+	}, {
+		desc:      "Loopback address",
+		advertise: "127.0.0.1:15006",
+		wantErr:   "not reachable",
+	}, {
+		desc:      "Localhost",
+		advertise: "localhost",
+		wantErr:   "not reachable",
+	}, {
+		desc:      "Unspecified address",
+		advertise: "[::]:15006",
+		wantErr:   "not reachable",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := advertisedBootzURL(test.advertise, "[::]:15006")
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("advertisedBootzURL() %s", s)
+			}
+			if got != test.want {
+				t.Errorf("advertisedBootzURL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDhcpEntry(t *testing.T) {
+	tests := []struct {
+		desc      string
+		chassis   *epb.Chassis
+		wantKey   string
+		wantEntry *dhcp.Entry
+	}{{
+		desc:    "No dhcp config",
+		chassis: &epb.Chassis{SerialNumber: "123"},
+	}, {
+		desc: "Keyed by serial",
+		chassis: &epb.Chassis{SerialNumber: "123", DhcpConfig: &epb.DHCPConfig{
+			IpAddress: "192.0.2.10/24",
+			Gateway:   "192.0.2.1",
+		}},
+		wantKey:   "123",
+		wantEntry: &dhcp.Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1"},
+	}, {
+		desc: "Own bootz server",
+		chassis: &epb.Chassis{SerialNumber: "123", DhcpConfig: &epb.DHCPConfig{
+			HardwareAddress: "00:00:00:00:00:0a",
+			IpAddress:       "2001:db8::10/64",
+			Bootzserver:     "bootz://[2001:db8::1]:15006",
+		}},
+		wantKey:   "00:00:00:00:00:0a",
+		wantEntry: &dhcp.Entry{IP: "2001:db8::10/64", BootzURL: "bootz://[2001:db8::1]:15006"},
+	}, {
+		desc: "Keyed by relay circuit id",
+		chassis: &epb.Chassis{SerialNumber: "123", DhcpConfig: &epb.DHCPConfig{
+			HardwareAddress: "00:00:00:00:00:0a",
+			CircuitId:       "Ethernet1/1",
+			RemoteId:        "rack1-tor",
+			IpAddress:       "192.0.2.10/24",
+			Gateway:         "192.0.2.1",
+		}},
+		wantKey:   "circuit-id:Ethernet1/1",
+		wantEntry: &dhcp.Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1"},
+	}, {
+		desc: "Keyed by relay remote id",
+		chassis: &epb.Chassis{SerialNumber: "123", DhcpConfig: &epb.DHCPConfig{
+			HardwareAddress: "00:00:00:00:00:0a",
+			RemoteId:        "rack1-tor",
+			IpAddress:       "192.0.2.10/24",
+			Gateway:         "192.0.2.1",
+		}},
+		wantKey:   "remote-id:rack1-tor",
+		wantEntry: &dhcp.Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1"},
+	}, {
+		desc: "Invalid bootz server",
+		chassis: &epb.Chassis{SerialNumber: "123", DhcpConfig: &epb.DHCPConfig{
+			IpAddress:   "192.0.2.10/24",
+			Gateway:     "192.0.2.1",
+			Bootzserver: "bootzip:....",
+		}},
+		wantKey:   "123",
+		wantEntry: &dhcp.Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1"},
+	}, {
+		desc: "Hostname defaults to the chassis name",
+		chassis: &epb.Chassis{SerialNumber: "123", Name: "r1", DhcpConfig: &epb.DHCPConfig{
+			IpAddress: "192.0.2.10/24",
+			Gateway:   "192.0.2.1",
+		}},
+		wantKey:   "123",
+		wantEntry: &dhcp.Entry{IP: "192.0.2.10/24", Gw: "192.0.2.1", Hostname: "r1"},
+	}, {
+		desc: "Options",
+		chassis: &epb.Chassis{SerialNumber: "123", Name: "r1", DhcpConfig: &epb.DHCPConfig{
+			IpAddress:  "192.0.2.10/24",
+			Gateway:    "192.0.2.1",
+			NtpServers: []string{"192.0.2.123", "192.0.2.124"},
+			DomainName: "example.com",
+			Hostname:   "r1-mgmt",
+			Mtu:        9000,
+			LeaseTime:  86400,
+			Options:    map[uint32][]byte{224: []byte("private"), 70000: []byte("invalid")},
+		}},
+		wantKey: "123",
+		wantEntry: &dhcp.Entry{
+			IP:         "192.0.2.10/24",
+			Gw:         "192.0.2.1",
+			NTP:        []string{"192.0.2.123", "192.0.2.124"},
+			DomainName: "example.com",
+			Hostname:   "r1-mgmt",
+			MTU:        9000,
+			LeaseTime:  24 * time.Hour,
+			Options:    map[uint16][]byte{224: []byte("private")},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			key, entry := dhcpEntry(test.chassis)
+			if key != test.wantKey {
+				t.Errorf("dhcpEntry() key = %q, want %q", key, test.wantKey)
+			}
+			if diff := cmp.Diff(test.wantEntry, entry); diff != "" {
+				t.Errorf("dhcpEntry() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInventoryEntries(t *testing.T) {
+	modular := &epb.Chassis{
+		SerialNumber: "123",
+		Name:         "r1",
+		DhcpConfig: &epb.DHCPConfig{
+			HardwareAddress: "00:00:00:00:00:0a",
+			IpAddress:       "192.0.2.10/24",
+			Gateway:         "192.0.2.1",
+			Bootzserver:     "bootz://192.0.2.2:15006",
+		},
+		ControllerCards: []*epb.ControlCard{{
+			SerialNumber: "123A",
+			DhcpConfig: &epb.DHCPConfig{
+				HardwareAddress: "00:00:00:00:00:1a",
+				IpAddress:       "192.0.2.11/24",
+				Gateway:         "192.0.2.1",
+			},
+		}, {
+			SerialNumber: "123B",
+			DhcpConfig: &epb.DHCPConfig{
+				IpAddress:   "2001:db8::12/64",
+				Bootzserver: "bootz://[2001:db8::2]:15006",
+			},
+		}, {
+			SerialNumber: "123C",
+		}},
+	}
+	tests := []struct {
+		desc    string
+		chassis []*epb.Chassis
+		want    map[string]*dhcp.Entry
+		wantErr string
+	}{{
+		desc:    "Chassis and control cards",
+		chassis: []*epb.Chassis{modular, {SerialNumber: "456"}},
+		want: map[string]*dhcp.Entry{
+			"00:00:00:00:00:0a": {IP: "192.0.2.10/24", Gw: "192.0.2.1", BootzURL: "bootz://192.0.2.2:15006", Hostname: "r1"},
+			"00:00:00:00:00:1a": {IP: "192.0.2.11/24", Gw: "192.0.2.1", BootzURL: "bootz://192.0.2.2:15006", Hostname: "r1"},
+			"123B":              {IP: "2001:db8::12/64", BootzURL: "bootz://[2001:db8::2]:15006", Hostname: "r1"},
+		},
+	}, {
+		desc: "Control cards without an address",
+		chassis: []*epb.Chassis{{
+			SerialNumber: "456",
+			DhcpConfig:   &epb.DHCPConfig{},
+			ControllerCards: []*epb.ControlCard{
+				{SerialNumber: "456A", DhcpConfig: &epb.DHCPConfig{}},
+				{SerialNumber: "456B", DhcpConfig: &epb.DHCPConfig{}},
+			},
+		}},
+		want: map[string]*dhcp.Entry{},
+	}, {
+		desc: "Control cards without an address with options",
+		chassis: []*epb.Chassis{{
+			SerialNumber: "456",
+			Name:         "r2",
+			DhcpConfig:   &epb.DHCPConfig{Bootzserver: "bootz://192.0.2.2:15006"},
+			ControllerCards: []*epb.ControlCard{
+				{SerialNumber: "456A", DhcpConfig: &epb.DHCPConfig{HardwareAddress: "00:00:00:00:00:2a", NtpServers: []string{"192.0.2.123"}}},
+			},
+		}},
+		want: map[string]*dhcp.Entry{
+			"456":               {BootzURL: "bootz://192.0.2.2:15006", Hostname: "r2"},
+			"00:00:00:00:00:2a": {BootzURL: "bootz://192.0.2.2:15006", NTP: []string{"192.0.2.123"}, Hostname: "r2"},
+		},
+	}, {
+		desc: "Duplicate hardware address",
+		chassis: []*epb.Chassis{modular, {
+			SerialNumber: "456",
+			DhcpConfig: &epb.DHCPConfig{
+				HardwareAddress: "00-00-00-00-00-1A",
+				CircuitId:       "Ethernet1/1",
+				IpAddress:       "192.0.2.20/24",
+				Gateway:         "192.0.2.1",
+			},
+		}},
+		wantErr: "hardware address 00:00:00:00:00:1a of chassis 456 is already assigned to control card 123A of chassis 123",
+	}, {
+		desc: "Duplicate IP address",
+		chassis: []*epb.Chassis{{
+			SerialNumber: "456",
+			DhcpConfig:   &epb.DHCPConfig{IpAddress: "2001:db8::20/64"},
+			ControllerCards: []*epb.ControlCard{{
+				SerialNumber: "456A",
+				DhcpConfig:   &epb.DHCPConfig{IpAddress: "2001:db8::20/64"},
+			}},
+		}},
+		wantErr: "IP address 2001:db8::20 of control card 456A of chassis 456 is already assigned to chassis 456",
+	}, {
+		desc: "Duplicate key",
+		chassis: []*epb.Chassis{{
+			SerialNumber: "456",
+			DhcpConfig:   &epb.DHCPConfig{CircuitId: "Ethernet1/1", IpAddress: "192.0.2.20/24", Gateway: "192.0.2.1"},
+		}, {
+			SerialNumber: "789",
+			DhcpConfig:   &epb.DHCPConfig{CircuitId: "Ethernet1/1", IpAddress: "192.0.2.30/24", Gateway: "192.0.2.1"},
+		}},
+		wantErr: "dhcp record circuit-id:Ethernet1/1 of chassis 789 is already assigned to chassis 456",
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := inventoryEntries(test.chassis)
+			if s := errdiff.Substring(err, test.wantErr); s != "" {
+				t.Fatalf("inventoryEntries() %s", s)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("inventoryEntries() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInventoryFilesEntries(t *testing.T) {
+	for _, file := range []string{"../testdata/inventory.prototxt", "../testdata/inventory_local.prototxt"} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inventory := &epb.Entities{}
+		if err := prototext.Unmarshal(b, inventory); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := inventoryEntries(inventory.GetChassis()); err != nil {
+			t.Errorf("inventoryEntries() of %v err = %v", file, err)
+		}
+	}
+}
+
+func TestDhcpSyncRejectsConflicts(t *testing.T) {
+	srv, err := dhcp.New(&dhcp.Config{Interfaces: []string{"eth0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	em, err := entitymanager.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	em.SetInventoryObserver(dhcpSync{server: srv})
+	chassis := func(serial, mac, ip string) *epb.Chassis {
+		return &epb.Chassis{SerialNumber: serial, Manufacturer: "cisco", DhcpConfig: &epb.DHCPConfig{
+			HardwareAddress: mac,
+			IpAddress:       ip,
+			Gateway:         "192.0.2.1",
+		}}
+	}
+	lookup := func(serial string) *service.EntityLookup {
+		return &service.EntityLookup{SerialNumber: serial, Manufacturer: "cisco"}
+	}
+	if err := em.ReplaceDevice(lookup("123"), chassis("123", "00:00:00:00:00:0a", "192.0.2.10/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.ReplaceDevice(lookup("456"), chassis("456", "00:00:00:00:00:0b", "192.0.2.10/24")); err == nil {
+		t.Errorf("ReplaceDevice() with the IP address of another chassis err = nil, want an error")
+	}
+	if err := em.ReplaceDevice(lookup("456"), chassis("456", "00:00:00:00:00:0a", "192.0.2.11/24")); err == nil {
+		t.Errorf("ReplaceDevice() with the hardware address of another chassis err = nil, want an error")
+	}
+	if _, err := em.GetDevice(lookup("456")); err == nil {
+		t.Errorf("GetDevice() found a rejected chassis")
+	}
+	// A chassis may keep its own addresses.
+	if err := em.ReplaceDevice(lookup("123"), chassis("123", "00:00:00:00:00:0a", "192.0.2.10/24")); err != nil {
+		t.Errorf("ReplaceDevice() with the same addresses err = %v", err)
+	}
+}
@@ -38,6 +38,7 @@ var (
 	vendor             = flag.String("vendor", "", "The name of the vendor to generate self-signed certificates for.")
 	owner              = flag.String("owner", "", "The name of the organization that owns the emulated device.")
 	controlCardSerials = flag.String("serials", "", "Comma-separated list of control card serials to generate OVs for.")
+	ovExpiry           = flag.Duration("ov_expiry", 365*24*time.Hour, "How long the generated OVs are valid for. A non-positive value omits expires-on.")
 )
 
 const (
@@ -191,7 +192,7 @@ func main() {
 	// Generate OVs for each control card.
 	for _, s := range serials {
 		fmt.Printf("Generating OV for control card serial %v\n", s)
-		ov, err := ownershipvoucher.New(s, pdcPem, vendorCAPub, vendorCAPriv)
+		ov, err := ownershipvoucher.New(s, pdcPem, vendorCAPub, vendorCAPriv, ownershipvoucher.WithExpiry(*ovExpiry))
 		if err != nil {
 			log.Exitf("unable to create OV: %v", err)
 		}